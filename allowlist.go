@@ -0,0 +1,277 @@
+package rcmgr
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Allowlist is a set of trusted peers and network addresses. Connections from allowlisted
+// endpoints that are blocked by the system or transient scopes are accounted against the
+// allowlisted system and transient scopes instead, so that trusted infrastructure can always
+// connect, even when the regular scopes are saturated.
+type Allowlist struct {
+	mx    sync.RWMutex
+	peers map[peer.ID]struct{}
+	nets  []allowlistedNet
+}
+
+// allowlistedNet is an allowlisted network; if peer is set, only that peer is allowed.
+type allowlistedNet struct {
+	net  *net.IPNet
+	peer peer.ID
+}
+
+// NewAllowlist creates a new empty allowlist.
+func NewAllowlist() *Allowlist {
+	return &Allowlist{
+		peers: make(map[peer.ID]struct{}),
+	}
+}
+
+// AddPeer allowlists a peer, on any address.
+func (al *Allowlist) AddPeer(p peer.ID) {
+	al.mx.Lock()
+	defer al.mx.Unlock()
+
+	al.peers[p] = struct{}{}
+}
+
+// RemovePeer removes a peer from the allowlist.
+func (al *Allowlist) RemovePeer(p peer.ID) {
+	al.mx.Lock()
+	defer al.mx.Unlock()
+
+	delete(al.peers, p)
+}
+
+// AddCIDR allowlists an IP network, specified in CIDR notation, for any peer.
+func (al *Allowlist) AddCIDR(cidr string) error {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	al.addNet(ipnet, "")
+	return nil
+}
+
+// RemoveCIDR removes an IP network from the allowlist.
+func (al *Allowlist) RemoveCIDR(cidr string) error {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	al.removeNet(ipnet, "")
+	return nil
+}
+
+// Add allowlists an IP address specified as a multiaddr. If the multiaddr has a /p2p component,
+// only the specified peer is allowed on that address.
+func (al *Allowlist) Add(addr multiaddr.Multiaddr) error {
+	ipnet, p, err := parseAllowlistAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	al.addNet(ipnet, p)
+	return nil
+}
+
+// Remove removes a multiaddr from the allowlist.
+func (al *Allowlist) Remove(addr multiaddr.Multiaddr) error {
+	ipnet, p, err := parseAllowlistAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	al.removeNet(ipnet, p)
+	return nil
+}
+
+func parseAllowlistAddr(addr multiaddr.Multiaddr) (*net.IPNet, peer.ID, error) {
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid allowlist address %s: %w", addr, err)
+	}
+
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	}
+	ipnet := &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+
+	var p peer.ID
+	if v, err := addr.ValueForProtocol(multiaddr.P_P2P); err == nil {
+		p, err = peer.Decode(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid peer ID in allowlist address %s: %w", addr, err)
+		}
+	}
+
+	return ipnet, p, nil
+}
+
+func (al *Allowlist) addNet(ipnet *net.IPNet, p peer.ID) {
+	al.mx.Lock()
+	defer al.mx.Unlock()
+
+	for _, n := range al.nets {
+		if n.peer == p && n.net.String() == ipnet.String() {
+			return
+		}
+	}
+
+	al.nets = append(al.nets, allowlistedNet{net: ipnet, peer: p})
+}
+
+func (al *Allowlist) removeNet(ipnet *net.IPNet, p peer.ID) {
+	al.mx.Lock()
+	defer al.mx.Unlock()
+
+	for i, n := range al.nets {
+		if n.peer == p && n.net.String() == ipnet.String() {
+			al.nets = append(al.nets[:i], al.nets[i+1:]...)
+			return
+		}
+	}
+}
+
+// Allowed reports whether a connection from the endpoint may use the allowlisted scopes.
+// The endpoint is allowed if its IP address is in an allowlisted network, or if it has a /p2p
+// component for an allowlisted peer. Networks restricted to a specific peer also allow the
+// endpoint at this point; the peer is verified when the connection is attached to it with
+// AllowedPeerAndAddr.
+func (al *Allowlist) Allowed(endpoint multiaddr.Multiaddr) bool {
+	al.mx.RLock()
+	defer al.mx.RUnlock()
+
+	if v, err := endpoint.ValueForProtocol(multiaddr.P_P2P); err == nil {
+		if p, err := peer.Decode(v); err == nil {
+			if _, ok := al.peers[p]; ok {
+				return true
+			}
+		}
+	}
+
+	ip, err := manet.ToIP(endpoint)
+	if err != nil {
+		return false
+	}
+
+	for _, n := range al.nets {
+		if n.net.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowedPeerAndAddr reports whether the peer is allowed to use the allowlisted scopes for a
+// connection from the endpoint.
+func (al *Allowlist) AllowedPeerAndAddr(p peer.ID, endpoint multiaddr.Multiaddr) bool {
+	al.mx.RLock()
+	defer al.mx.RUnlock()
+
+	if _, ok := al.peers[p]; ok {
+		return true
+	}
+
+	if endpoint == nil {
+		return false
+	}
+
+	ip, err := manet.ToIP(endpoint)
+	if err != nil {
+		return false
+	}
+
+	for _, n := range al.nets {
+		if (n.peer == "" || n.peer == p) && n.net.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// merge adds the contents of another allowlist to the allowlist.
+func (al *Allowlist) merge(other *Allowlist) {
+	other.mx.RLock()
+	defer other.mx.RUnlock()
+
+	for p := range other.peers {
+		al.AddPeer(p)
+	}
+	for _, n := range other.nets {
+		al.addNet(n.net, n.peer)
+	}
+}
+
+// replace replaces the contents of the allowlist with the contents of another allowlist.
+func (al *Allowlist) replace(other *Allowlist) {
+	other.mx.RLock()
+	peers := make(map[peer.ID]struct{}, len(other.peers))
+	for p := range other.peers {
+		peers[p] = struct{}{}
+	}
+	nets := make([]allowlistedNet, len(other.nets))
+	copy(nets, other.nets)
+	other.mx.RUnlock()
+
+	al.mx.Lock()
+	defer al.mx.Unlock()
+
+	al.peers = peers
+	al.nets = nets
+}
+
+// AllowlistConfig is the JSON configuration of an allowlist.
+type AllowlistConfig struct {
+	// Peers is a list of allowlisted peer IDs.
+	Peers []string `json:",omitempty"`
+	// Addrs is a list of allowlisted multiaddrs, optionally restricted to a peer with a /p2p component.
+	Addrs []string `json:",omitempty"`
+	// CIDRs is a list of allowlisted IP networks, in CIDR notation.
+	CIDRs []string `json:",omitempty"`
+}
+
+func (cfg *AllowlistConfig) toAllowlist() (*Allowlist, error) {
+	al := NewAllowlist()
+	if cfg == nil {
+		return al, nil
+	}
+
+	for _, s := range cfg.Peers {
+		p, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID %s: %w", s, err)
+		}
+		al.AddPeer(p)
+	}
+
+	for _, s := range cfg.Addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiaddr %s: %w", s, err)
+		}
+		if err := al.Add(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range cfg.CIDRs {
+		if err := al.AddCIDR(s); err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s: %w", s, err)
+		}
+	}
+
+	return al, nil
+}
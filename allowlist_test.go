@@ -0,0 +1,242 @@
+package rcmgr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestAllowlist(t *testing.T) {
+	peerA, err := peer.Decode("QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerB, err := peer.Decode("QmZ4ekCHNYSdqcvnGNMtUndPpRUJ3bD3E4zWcj4oPv9ytN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	al := NewAllowlist()
+	if err := al.AddCIDR("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := al.Add(multiaddr.StringCast("/ip4/1.2.3.4/p2p/" + peerA.String())); err != nil {
+		t.Fatal(err)
+	}
+	al.AddPeer(peerB)
+
+	for _, tc := range []struct {
+		addr    string
+		allowed bool
+	}{
+		{"/ip4/10.1.2.3/tcp/4001", true},
+		{"/ip4/1.2.3.4/tcp/4001", true},
+		{"/ip4/1.2.3.5/tcp/4001", false},
+		{"/ip4/1.2.3.5/tcp/4001/p2p/" + peerB.String(), true},
+		{"/ip6/::1/tcp/4001", false},
+	} {
+		if al.Allowed(multiaddr.StringCast(tc.addr)) != tc.allowed {
+			t.Fatalf("expected %s allowed to be %t", tc.addr, tc.allowed)
+		}
+	}
+
+	// the address restricted to peerA only allows peerA
+	if !al.AllowedPeerAndAddr(peerA, multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")) {
+		t.Fatal("expected peerA to be allowed")
+	}
+	if al.AllowedPeerAndAddr(peer.ID("C"), multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")) {
+		t.Fatal("expected peer C not to be allowed")
+	}
+	if !al.AllowedPeerAndAddr(peer.ID("C"), multiaddr.StringCast("/ip4/10.1.2.3/tcp/4001")) {
+		t.Fatal("expected peer C to be allowed in an allowlisted network")
+	}
+	if !al.AllowedPeerAndAddr(peerB, multiaddr.StringCast("/ip4/1.2.3.5/tcp/4001")) {
+		t.Fatal("expected peerB to be allowed on any address")
+	}
+
+	al.RemovePeer(peerB)
+	if err := al.RemoveCIDR("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if al.Allowed(multiaddr.StringCast("/ip4/10.1.2.3/tcp/4001")) {
+		t.Fatal("expected removed network not to be allowed")
+	}
+	if al.AllowedPeerAndAddr(peerB, multiaddr.StringCast("/ip4/1.2.3.5/tcp/4001")) {
+		t.Fatal("expected removed peer not to be allowed")
+	}
+}
+
+func TestAllowlistedConnection(t *testing.T) {
+	limit := &StaticLimit{
+		Memory: 4096,
+		BaseLimit: BaseLimit{
+			StreamsInbound:  1,
+			StreamsOutbound: 1,
+			Streams:         1,
+			ConnsInbound:    1,
+			ConnsOutbound:   1,
+			Conns:           1,
+			FD:              1,
+		},
+	}
+	allowlisted := &StaticLimit{
+		Memory: 4096,
+		BaseLimit: BaseLimit{
+			ConnsInbound:  2,
+			ConnsOutbound: 2,
+			Conns:         2,
+			FD:            2,
+		},
+	}
+	nmgr, err := NewResourceManager(&BasicLimiter{
+		SystemLimits:               limit,
+		TransientLimits:            limit,
+		AllowlistedSystemLimits:    allowlisted,
+		AllowlistedTransientLimits: allowlisted,
+		DefaultServiceLimits:       limit,
+		DefaultServicePeerLimits:   limit,
+		DefaultProtocolLimits:      limit,
+		DefaultProtocolPeerLimits:  limit,
+		DefaultPeerLimits:          limit,
+		ConnLimits:                 limit,
+		StreamLimits:               limit,
+	}, WithAllowlistedAddrs(multiaddr.StringCast("/ip4/1.2.3.4")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	allowed := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")
+	denied := multiaddr.StringCast("/ip4/1.2.3.5/tcp/4001")
+
+	// saturate the transient scope
+	conn1, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, denied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn1.Done()
+
+	if _, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, denied); err == nil {
+		t.Fatal("expected connection from a denied endpoint to be blocked")
+	}
+
+	// an allowlisted endpoint uses the allowlisted scopes
+	conn2, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, allowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResources(t, &mgr.transient.rc, network.ScopeStat{NumConnsInbound: 1, NumFD: 1})
+	checkResources(t, &mgr.allowlistedTransient.rc, network.ScopeStat{NumConnsInbound: 1, NumFD: 1})
+	checkResources(t, &mgr.allowlistedSystem.rc, network.ScopeStat{NumConnsInbound: 1, NumFD: 1})
+
+	// attaching to a peer moves the connection from the allowlisted transient scope
+	if err := conn2.SetPeer(peer.ID("A")); err != nil {
+		t.Fatal(err)
+	}
+	checkResources(t, &mgr.allowlistedTransient.rc, network.ScopeStat{})
+	checkResources(t, &mgr.allowlistedSystem.rc, network.ScopeStat{NumConnsInbound: 1, NumFD: 1})
+	checkResources(t, &conn2.PeerScope().(*peerScope).rc, network.ScopeStat{NumConnsInbound: 1, NumFD: 1})
+
+	conn2.Done()
+	checkResources(t, &mgr.allowlistedSystem.rc, network.ScopeStat{})
+
+	// a peer that is not allowed on the endpoint is moved to the regular system scope, which is full
+	if err := mgr.allowlist.Add(multiaddr.StringCast("/ip4/1.2.3.6/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC")); err != nil {
+		t.Fatal(err)
+	}
+	conn3, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.6/tcp/4001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn3.Done()
+	if err := conn3.SetPeer(peer.ID("B")); err == nil {
+		t.Fatal("expected peer not allowed on the endpoint to be blocked")
+	}
+	checkResources(t, &mgr.allowlistedTransient.rc, network.ScopeStat{NumConnsInbound: 1, NumFD: 1})
+	checkResources(t, &mgr.system.rc, network.ScopeStat{NumConnsInbound: 1, NumFD: 1})
+
+	// once there is room, it is accounted in the system scope
+	conn1.Done()
+	if err := conn3.SetPeer(peer.ID("B")); err != nil {
+		t.Fatal(err)
+	}
+	checkResources(t, &mgr.allowlistedTransient.rc, network.ScopeStat{})
+	checkResources(t, &mgr.allowlistedSystem.rc, network.ScopeStat{})
+	checkResources(t, &mgr.system.rc, network.ScopeStat{NumConnsInbound: 1, NumFD: 1})
+
+	conn3.Done()
+	checkResources(t, &mgr.system.rc, network.ScopeStat{})
+}
+
+func TestAllowlistConfig(t *testing.T) {
+	in := `{
+  "AllowlistedSystem": {"Memory": 1048576, "Conns": 10},
+  "Allowlist": {
+    "Peers": ["QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"],
+    "Addrs": ["/ip4/1.2.3.4"],
+    "CIDRs": ["192.168.0.0/16"]
+  }
+}`
+	limiter, err := NewDefaultLimiterFromJSON(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limiter.GetAllowlistedSystemLimits().GetMemoryLimit() != 1048576 {
+		t.Fatalf("unexpected allowlisted system memory limit %d", limiter.GetAllowlistedSystemLimits().GetMemoryLimit())
+	}
+	if limiter.GetAllowlistedSystemLimits().GetConnTotalLimit() != 10 {
+		t.Fatalf("unexpected allowlisted system conn limit %d", limiter.GetAllowlistedSystemLimits().GetConnTotalLimit())
+	}
+
+	al := limiter.GetAllowlist()
+	for _, addr := range []string{
+		"/ip4/1.2.3.4/tcp/4001",
+		"/ip4/192.168.1.1/udp/4001/quic",
+		"/ip4/5.6.7.8/tcp/4001/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC",
+	} {
+		if !al.Allowed(multiaddr.StringCast(addr)) {
+			t.Fatalf("expected %s to be allowed", addr)
+		}
+	}
+
+	if _, err := NewDefaultLimiterFromJSON(strings.NewReader(`{"Allowlist": {"CIDRs": ["bogus"]}}`)); err == nil {
+		t.Fatal("expected invalid allowlist to fail")
+	}
+}
+
+func TestAllowlistedLimitsOptional(t *testing.T) {
+	// a limiter that only implements the Limiter interface
+	limiter := struct{ Limiter }{NewDefaultLimiter()}
+
+	nmgr, err := NewResourceManager(limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	if mgr.allowlistedSystem.Limit() != limiter.GetSystemLimits() {
+		t.Fatal("expected the allowlisted system scope to use the system limits")
+	}
+	if mgr.allowlistedTransient.Limit() != limiter.GetTransientLimits() {
+		t.Fatal("expected the allowlisted transient scope to use the transient limits")
+	}
+
+	basic := NewDefaultLimiter()
+	basic.SystemLimits = basic.SystemLimits.WithFDLimit(1)
+	basic.TransientLimits = basic.TransientLimits.WithFDLimit(1)
+	update := struct{ Limiter }{basic}
+	mgr.UpdateLimiter(update)
+	if mgr.allowlistedSystem.Limit() != update.GetSystemLimits() {
+		t.Fatal("expected the allowlisted system scope to use the updated system limits")
+	}
+	if mgr.allowlistedTransient.Limit() != update.GetTransientLimits() {
+		t.Fatal("expected the allowlisted transient scope to use the updated transient limits")
+	}
+}
@@ -0,0 +1,375 @@
+package rcmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// DebugState is a snapshot of the resource manager state, as rendered by the debug handler.
+type DebugState struct {
+	System               DebugScope
+	Transient            DebugScope
+	AllowlistedSystem    *DebugScope `json:",omitempty"`
+	AllowlistedTransient *DebugScope `json:",omitempty"`
+	Services             map[string]DebugScope
+	Protocols            map[string]DebugScope
+	Peers                map[string]DebugScope
+}
+
+// DebugScope is the resource usage and limit of a scope.
+type DebugScope struct {
+	Stat  network.ScopeStat
+	Limit *BasicLimitConfig `json:",omitempty"`
+}
+
+type debugHandler struct {
+	mgr    network.ResourceManager
+	state  ResourceManagerState
+	lookup ResourceManagerLookup
+}
+
+var errScopeNotFound = errors.New("scope not found")
+
+// NewDebugHandler creates an http.Handler that renders the state of a resource manager, which must
+// implement the ResourceManagerState and ResourceManagerLookup traits. GET requests render the usage and limits of all scopes
+// as an HTML table, or as JSON if the request has a format=json query parameter or accepts
+// application/json. POST requests update the limit of the scope named by the scope query parameter
+// (e.g. system, transient, service:<name>, protocol:<id> or peer:<id>); the body is a JSON
+// BasicLimitConfig, whose non-zero fields override the current limit of the scope. Scopes are looked
+// up without being created, so requests for scopes that do not exist fail with 404.
+func NewDebugHandler(mgr network.ResourceManager) (http.Handler, error) {
+	state, ok := mgr.(ResourceManagerState)
+	if !ok {
+		return nil, fmt.Errorf("resource manager does not implement ResourceManagerState")
+	}
+	lookup, ok := mgr.(ResourceManagerLookup)
+	if !ok {
+		return nil, fmt.Errorf("resource manager does not implement ResourceManagerLookup")
+	}
+
+	return &debugHandler{mgr: mgr, state: state, lookup: lookup}, nil
+}
+
+func (h *debugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		st := h.snapshot()
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(st)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := debugTemplate.Execute(w, debugRows(st)); err != nil {
+			log.Warnf("error rendering resource manager state: %s", err)
+		}
+
+	case http.MethodPost:
+		h.setLimit(w, r)
+
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *debugHandler) snapshot() *DebugState {
+	stat := h.state.Stat()
+
+	st := &DebugState{
+		Services:  make(map[string]DebugScope, len(stat.Services)),
+		Protocols: make(map[string]DebugScope, len(stat.Protocols)),
+		Peers:     make(map[string]DebugScope, len(stat.Peers)),
+	}
+
+	_ = h.mgr.ViewSystem(func(s network.ResourceScope) error {
+		st.System = DebugScope{Stat: stat.System, Limit: debugLimit(s)}
+		return nil
+	})
+	_ = h.mgr.ViewTransient(func(s network.ResourceScope) error {
+		st.Transient = DebugScope{Stat: stat.Transient, Limit: debugLimit(s)}
+		return nil
+	})
+	if al, ok := h.mgr.(ResourceManagerAllowlist); ok {
+		_ = al.ViewAllowlistedSystem(func(s network.ResourceScope) error {
+			st.AllowlistedSystem = &DebugScope{Stat: stat.AllowlistedSystem, Limit: debugLimit(s)}
+			return nil
+		})
+		_ = al.ViewAllowlistedTransient(func(s network.ResourceScope) error {
+			st.AllowlistedTransient = &DebugScope{Stat: stat.AllowlistedTransient, Limit: debugLimit(s)}
+			return nil
+		})
+	}
+
+	for _, svc := range h.state.ListServices() {
+		ss, ok := stat.Services[svc]
+		if !ok {
+			continue
+		}
+		_, _ = h.lookup.LookupService(svc, func(s network.ServiceScope) error {
+			st.Services[svc] = DebugScope{Stat: ss, Limit: debugLimit(s)}
+			return nil
+		})
+	}
+	for _, proto := range h.state.ListProtocols() {
+		ps, ok := stat.Protocols[proto]
+		if !ok {
+			continue
+		}
+		_, _ = h.lookup.LookupProtocol(proto, func(s network.ProtocolScope) error {
+			st.Protocols[string(proto)] = DebugScope{Stat: ps, Limit: debugLimit(s)}
+			return nil
+		})
+	}
+	for _, p := range h.state.ListPeers() {
+		ps, ok := stat.Peers[p]
+		if !ok {
+			continue
+		}
+		_, _ = h.lookup.LookupPeer(p, func(s network.PeerScope) error {
+			st.Peers[p.String()] = DebugScope{Stat: ps, Limit: debugLimit(s)}
+			return nil
+		})
+	}
+
+	return st
+}
+
+func debugLimit(s interface{}) *BasicLimitConfig {
+	ls, ok := s.(ResourceScopeLimiter)
+	if !ok {
+		return nil
+	}
+	l := ls.Limit()
+	if l == nil {
+		return nil
+	}
+
+	return &BasicLimitConfig{
+		Memory:          limitVal64(l.GetMemoryLimit()),
+		StreamsInbound:  limitVal(l.GetStreamLimit(network.DirInbound)),
+		StreamsOutbound: limitVal(l.GetStreamLimit(network.DirOutbound)),
+		Streams:         limitVal(l.GetStreamTotalLimit()),
+		ConnsInbound:    limitVal(l.GetConnLimit(network.DirInbound)),
+		ConnsOutbound:   limitVal(l.GetConnLimit(network.DirOutbound)),
+		Conns:           limitVal(l.GetConnTotalLimit()),
+		FD:              limitVal(l.GetFDLimit()),
+	}
+}
+
+func (h *debugHandler) setLimit(w http.ResponseWriter, r *http.Request) {
+	var cfg BasicLimitConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, fmt.Sprintf("invalid limit: %s", err), http.StatusBadRequest)
+		return
+	}
+	if cfg.Dynamic || cfg.MemoryFraction != 0 || cfg.MinMemory != 0 || cfg.MaxMemory != 0 {
+		http.Error(w, "invalid limit: only fixed memory limits can be set", http.StatusBadRequest)
+		return
+	}
+
+	name := r.URL.Query().Get("scope")
+	var limit *BasicLimitConfig
+	err := h.viewScope(name, func(s network.ResourceScope) error {
+		ls, ok := s.(ResourceScopeLimiter)
+		if !ok {
+			return fmt.Errorf("scope %s does not implement ResourceScopeLimiter", name)
+		}
+
+		l := ls.Limit()
+		base, err := cfg.apply(BaseLimit{
+			StreamsInbound:  l.GetStreamLimit(network.DirInbound),
+			StreamsOutbound: l.GetStreamLimit(network.DirOutbound),
+			Streams:         l.GetStreamTotalLimit(),
+			ConnsInbound:    l.GetConnLimit(network.DirInbound),
+			ConnsOutbound:   l.GetConnLimit(network.DirOutbound),
+			Conns:           l.GetConnTotalLimit(),
+			FD:              l.GetFDLimit(),
+		})
+		if err != nil {
+			return err
+		}
+
+		if cfg.Memory != DefaultLimit64 {
+			m := cfg.Memory.Build(0)
+			l = l.WithMemoryLimit(0, m, m)
+		}
+		if cfg.StreamsInbound != DefaultLimit || cfg.StreamsOutbound != DefaultLimit || cfg.Streams != DefaultLimit {
+			l = l.WithStreamLimit(base.StreamsInbound, base.StreamsOutbound, base.Streams)
+		}
+		if cfg.ConnsInbound != DefaultLimit || cfg.ConnsOutbound != DefaultLimit || cfg.Conns != DefaultLimit {
+			l = l.WithConnLimit(base.ConnsInbound, base.ConnsOutbound, base.Conns)
+		}
+		if cfg.FD != DefaultLimit {
+			l = l.WithFDLimit(base.FD)
+		}
+
+		ls.SetLimit(l)
+		limit = debugLimit(s)
+		return nil
+	})
+	if errors.Is(err, errScopeNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Infow("updated scope limit", "scope", name, "limit", limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(limit)
+}
+
+func (h *debugHandler) viewScope(name string, f func(network.ResourceScope) error) error {
+	switch {
+	case name == "system":
+		return h.mgr.ViewSystem(f)
+
+	case name == "transient":
+		return h.mgr.ViewTransient(f)
+
+	case name == "allowlisted-system" || name == "allowlisted-transient":
+		al, ok := h.mgr.(ResourceManagerAllowlist)
+		if !ok {
+			return fmt.Errorf("resource manager has no allowlisted scopes")
+		}
+		if name == "allowlisted-system" {
+			return al.ViewAllowlistedSystem(f)
+		}
+		return al.ViewAllowlistedTransient(f)
+
+	case strings.HasPrefix(name, "service:"):
+		found, err := h.lookup.LookupService(strings.TrimPrefix(name, "service:"), func(s network.ServiceScope) error {
+			return f(s)
+		})
+		return scopeResult(name, found, err)
+
+	case strings.HasPrefix(name, "protocol:"):
+		found, err := h.lookup.LookupProtocol(protocol.ID(strings.TrimPrefix(name, "protocol:")), func(s network.ProtocolScope) error {
+			return f(s)
+		})
+		return scopeResult(name, found, err)
+
+	case strings.HasPrefix(name, "peer:"):
+		p, err := peer.Decode(strings.TrimPrefix(name, "peer:"))
+		if err != nil {
+			return fmt.Errorf("invalid peer ID: %w", err)
+		}
+		found, err := h.lookup.LookupPeer(p, func(s network.PeerScope) error {
+			return f(s)
+		})
+		return scopeResult(name, found, err)
+
+	default:
+		return fmt.Errorf("unknown scope: %q", name)
+	}
+}
+
+func scopeResult(name string, found bool, err error) error {
+	if !found {
+		return fmt.Errorf("%w: %s", errScopeNotFound, name)
+	}
+	return err
+}
+
+type debugRow struct {
+	Name  string
+	Cells []debugCell
+}
+
+type debugCell struct {
+	Used, Limit int64
+}
+
+func (c debugCell) Utilization() string {
+	if c.Limit <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(c.Used)/float64(c.Limit))
+}
+
+func debugRows(st *DebugState) []debugRow {
+	var rows []debugRow
+	add := func(name string, s *DebugScope) {
+		l := s.Limit
+		if l == nil {
+			l = &BasicLimitConfig{}
+		}
+		rows = append(rows, debugRow{
+			Name: name,
+			Cells: []debugCell{
+				{s.Stat.Memory, l.Memory.Build(0)},
+				{int64(s.Stat.NumStreamsInbound), int64(l.StreamsInbound.Build(0))},
+				{int64(s.Stat.NumStreamsOutbound), int64(l.StreamsOutbound.Build(0))},
+				{int64(s.Stat.NumStreamsInbound + s.Stat.NumStreamsOutbound), int64(l.Streams.Build(0))},
+				{int64(s.Stat.NumConnsInbound), int64(l.ConnsInbound.Build(0))},
+				{int64(s.Stat.NumConnsOutbound), int64(l.ConnsOutbound.Build(0))},
+				{int64(s.Stat.NumConnsInbound + s.Stat.NumConnsOutbound), int64(l.Conns.Build(0))},
+				{int64(s.Stat.NumFD), int64(l.FD.Build(0))},
+			},
+		})
+	}
+	addAll := func(prefix string, scopes map[string]DebugScope) {
+		for _, name := range sortedKeys(scopes) {
+			s := scopes[name]
+			add(prefix+name, &s)
+		}
+	}
+
+	add("system", &st.System)
+	add("transient", &st.Transient)
+	if st.AllowlistedSystem != nil {
+		add("allowlisted-system", st.AllowlistedSystem)
+	}
+	if st.AllowlistedTransient != nil {
+		add("allowlisted-transient", st.AllowlistedTransient)
+	}
+	addAll("service:", st.Services)
+	addAll("protocol:", st.Protocols)
+	addAll("peer:", st.Peers)
+
+	return rows
+}
+
+func sortedKeys(m map[string]DebugScope) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var debugTemplate = template.Must(template.New("rcmgr").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Resource Manager</title>
+<style>
+table { border-collapse: collapse; font-family: monospace; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: right; }
+td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<table>
+<tr><th>Scope</th><th>Memory</th><th>Streams In</th><th>Streams Out</th><th>Streams</th><th>Conns In</th><th>Conns Out</th><th>Conns</th><th>FD</th></tr>
+{{range .}}<tr><td>{{.Name}}</td>{{range .Cells}}<td>{{.Used}} / {{.Limit}} ({{.Utilization}})</td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
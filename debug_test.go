@@ -0,0 +1,141 @@
+package rcmgr
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestDebugHandler(t *testing.T) {
+	mgr, err := NewResourceManager(NewDefaultLimiter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	stream, err := mgr.OpenStream(peer.ID("A"), network.DirInbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Done()
+	if err := stream.SetProtocol("/test"); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewDebugHandler(mgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	get := func(url string) string {
+		t.Helper()
+
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", resp.StatusCode, body)
+		}
+		return string(body)
+	}
+
+	// JSON rendering
+	var st DebugState
+	if err := json.Unmarshal([]byte(get(srv.URL+"?format=json")), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.System.Stat.NumStreamsInbound != 1 {
+		t.Fatalf("unexpected system stat: %+v", st.System.Stat)
+	}
+	proto, ok := st.Protocols["/test"]
+	if !ok {
+		t.Fatalf("missing protocol scope: %+v", st.Protocols)
+	}
+	if proto.Limit == nil || proto.Limit.Streams.Build(0) != DefaultLimits.ProtocolBaseLimit.Streams {
+		t.Fatalf("unexpected protocol limit: %+v", proto.Limit)
+	}
+	if _, ok := st.Peers[peer.ID("A").String()]; !ok {
+		t.Fatalf("missing peer scope: %+v", st.Peers)
+	}
+
+	// HTML rendering
+	html := get(srv.URL)
+	if !strings.Contains(html, "protocol:/test") || !strings.Contains(html, "%)") {
+		t.Fatalf("unexpected HTML: %s", html)
+	}
+
+	// updating a limit keeps the unspecified values
+	resp, err := http.Post(srv.URL+"?scope=protocol:/test", "application/json", strings.NewReader(`{"Memory": 1048576, "StreamsInbound": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	err = mgr.ViewProtocol("/test", func(s network.ProtocolScope) error {
+		l := s.(ResourceScopeLimiter).Limit()
+		if l.GetMemoryLimit() != 1048576 {
+			t.Fatalf("unexpected memory limit %d", l.GetMemoryLimit())
+		}
+		if l.GetStreamLimit(network.DirInbound) != 1 {
+			t.Fatalf("unexpected inbound stream limit %d", l.GetStreamLimit(network.DirInbound))
+		}
+		if l.GetStreamLimit(network.DirOutbound) != DefaultLimits.ProtocolBaseLimit.StreamsOutbound {
+			t.Fatalf("unexpected outbound stream limit %d", l.GetStreamLimit(network.DirOutbound))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scope := range []string{"bogus", "peer:bogus"} {
+		resp, err := http.Post(srv.URL+"?scope="+scope, "application/json", strings.NewReader(`{"FD": 1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected bad request for scope %s, got %d", scope, resp.StatusCode)
+		}
+	}
+
+	// scopes that do not exist are not created
+	for _, scope := range []string{"service:test", "protocol:/missing", "peer:QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"} {
+		resp, err := http.Post(srv.URL+"?scope="+scope, "application/json", strings.NewReader(`{"FD": 1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected not found for scope %s, got %d", scope, resp.StatusCode)
+		}
+	}
+	state := mgr.(ResourceManagerState)
+	if svcs := state.ListServices(); len(svcs) != 0 {
+		t.Fatalf("unexpected services: %v", svcs)
+	}
+	if protos := state.ListProtocols(); len(protos) != 1 {
+		t.Fatalf("unexpected protocols: %v", protos)
+	}
+	if peers := state.ListPeers(); len(peers) != 1 {
+		t.Fatalf("unexpected peers: %v", peers)
+	}
+}
@@ -0,0 +1,88 @@
+package rcmgr
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+// ScopeKind identifies the class of a resource scope.
+type ScopeKind string
+
+const (
+	ScopeKindSystem               ScopeKind = "system"
+	ScopeKindTransient            ScopeKind = "transient"
+	ScopeKindAllowlistedSystem    ScopeKind = "allowlisted-system"
+	ScopeKindAllowlistedTransient ScopeKind = "allowlisted-transient"
+	ScopeKindService              ScopeKind = "service"
+	ScopeKindServicePeer          ScopeKind = "service-peer"
+	ScopeKindProtocol             ScopeKind = "protocol"
+	ScopeKindProtocolPeer         ScopeKind = "protocol-peer"
+	ScopeKindPeer                 ScopeKind = "peer"
+	ScopeKindIP                   ScopeKind = "ip"
+	ScopeKindSubnet               ScopeKind = "subnet"
+	ScopeKindConn                 ScopeKind = "conn"
+	ScopeKindStream               ScopeKind = "stream"
+	ScopeKindSpan                 ScopeKind = "span"
+)
+
+// Resource identifies a basic resource constrained by a limit.
+type Resource string
+
+const (
+	ResourceMemory          Resource = "memory"
+	ResourceStreamsInbound  Resource = "streams-inbound"
+	ResourceStreamsOutbound Resource = "streams-outbound"
+	ResourceStreams         Resource = "streams"
+	ResourceConnsInbound    Resource = "conns-inbound"
+	ResourceConnsOutbound   Resource = "conns-outbound"
+	ResourceConns           Resource = "conns"
+	ResourceFD              Resource = "fd"
+
+	ResourceConnRateInbound    Resource = "conn-rate-inbound"
+	ResourceConnRateOutbound   Resource = "conn-rate-outbound"
+	ResourceStreamRateInbound  Resource = "stream-rate-inbound"
+	ResourceStreamRateOutbound Resource = "stream-rate-outbound"
+)
+
+// ErrLimitExceeded is the error returned when a reservation is blocked by a scope limit.
+// It wraps network.ErrResourceLimitExceeded, so it can be matched with errors.Is, while
+// errors.As gives access to the details of the constraint that blocked the reservation.
+type ErrLimitExceeded struct {
+	// Scope is the name of the scope that blocked the reservation.
+	Scope string
+	// Kind is the class of the scope that blocked the reservation.
+	Kind ScopeKind
+	// Resource is the resource whose limit would have been exceeded.
+	Resource Resource
+	// Current is the amount of the resource in use by the scope; for rate limits, it is the
+	// number of openings currently allowed by the token bucket.
+	Current int64
+	// Requested is the amount of the resource that was requested.
+	Requested int64
+	// Limit is the scope limit for the resource; for rate limits, it is the burst.
+	Limit int64
+	// Priority is the reservation priority; only meaningful for memory reservations.
+	Priority uint8
+}
+
+var _ error = (*ErrLimitExceeded)(nil)
+
+func (e *ErrLimitExceeded) Error() string {
+	return fmt.Sprintf("cannot reserve %s (current: %d, requested: %d, limit: %d): %s",
+		e.Resource, e.Current, e.Requested, e.Limit, network.ErrResourceLimitExceeded)
+}
+
+func (e *ErrLimitExceeded) Unwrap() error {
+	return network.ErrResourceLimitExceeded
+}
+
+// Temporary marks the error as temporary, like network.ErrResourceLimitExceeded.
+func (e *ErrLimitExceeded) Temporary() bool {
+	return true
+}
+
+// Timeout implements net.Error.
+func (e *ErrLimitExceeded) Timeout() bool {
+	return false
+}
@@ -0,0 +1,122 @@
+package rcmgr
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestErrLimitExceeded(t *testing.T) {
+	limit := &StaticLimit{
+		Memory: 4096,
+		BaseLimit: BaseLimit{
+			StreamsInbound:  4,
+			StreamsOutbound: 4,
+			Streams:         4,
+			ConnsInbound:    4,
+			ConnsOutbound:   4,
+			Conns:           4,
+			FD:              4,
+		},
+	}
+	transient := &StaticLimit{
+		Memory: 4096,
+		BaseLimit: BaseLimit{
+			StreamsInbound:  1,
+			StreamsOutbound: 1,
+			Streams:         1,
+			ConnsInbound:    1,
+			ConnsOutbound:   1,
+			Conns:           1,
+			FD:              1,
+		},
+	}
+	nmgr, err := NewResourceManager(&BasicLimiter{
+		SystemLimits:              limit,
+		TransientLimits:           transient,
+		DefaultServiceLimits:      limit,
+		DefaultServicePeerLimits:  limit,
+		DefaultProtocolLimits:     limit,
+		DefaultProtocolPeerLimits: limit,
+		DefaultPeerLimits:         limit,
+		ConnLimits:                limit,
+		StreamLimits:              limit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	checkErr := func(err error, scope string, kind ScopeKind, res Resource, current, requested, limit int64, prio uint8) {
+		t.Helper()
+
+		if !errors.Is(err, network.ErrResourceLimitExceeded) {
+			t.Fatalf("expected resource limit exceeded error, got %v", err)
+		}
+
+		var lerr *ErrLimitExceeded
+		if !errors.As(err, &lerr) {
+			t.Fatalf("expected an ErrLimitExceeded, got %v", err)
+		}
+		if lerr.Scope != scope {
+			t.Fatalf("expected blocking scope %s, got %s", scope, lerr.Scope)
+		}
+		if lerr.Kind != kind {
+			t.Fatalf("expected blocking scope kind %s, got %s", kind, lerr.Kind)
+		}
+		if lerr.Resource != res {
+			t.Fatalf("expected blocked resource %s, got %s", res, lerr.Resource)
+		}
+		if lerr.Current != current || lerr.Requested != requested || lerr.Limit != limit {
+			t.Fatalf("expected current/requested/limit %d/%d/%d, got %d/%d/%d",
+				current, requested, limit, lerr.Current, lerr.Requested, lerr.Limit)
+		}
+		if lerr.Priority != prio {
+			t.Fatalf("expected priority %d, got %d", prio, lerr.Priority)
+		}
+	}
+
+	conn, err := mgr.OpenConnection(network.DirInbound, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Done()
+
+	// the transient scope blocks the second inbound connection
+	_, err = mgr.OpenConnection(network.DirInbound, false)
+	checkErr(err, "transient", ScopeKindTransient, ResourceConnsInbound, 1, 1, 1, 0)
+
+	// the connection scope blocks a memory reservation exceeding its own limit
+	err = conn.ReserveMemory(8192, network.ReservationPriorityAlways)
+	checkErr(err, conn.(*connectionScope).name, ScopeKindConn, ResourceMemory, 0, 8192, 4096, network.ReservationPriorityAlways)
+
+	// a span reports itself as the blocking scope
+	span, err := conn.BeginSpan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer span.Done()
+	err = span.ReserveMemory(4096, network.ReservationPriorityLow)
+	checkErr(err, span.(*resourceScope).name, ScopeKindSpan, ResourceMemory, 0, 4096, 4096, network.ReservationPriorityLow)
+
+	// the peer scope blocks the fifth inbound stream
+	p := peer.ID("A")
+	if err := conn.SetPeer(p); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		s, err := mgr.OpenStream(p, network.DirInbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Done()
+		if err := s.SetProtocol("/A"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = mgr.OpenStream(p, network.DirInbound)
+	checkErr(err, "peer:"+p.String(), ScopeKindPeer, ResourceStreamsInbound, 4, 1, 4, 0)
+}
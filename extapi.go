@@ -2,12 +2,15 @@ package rcmgr
 
 import (
 	"bytes"
+	"context"
 	"sort"
 	"strings"
 
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
+
+	"github.com/multiformats/go-multiaddr"
 )
 
 // ResourceScopeLimiter is a trait interface that allows you to access scope limits.
@@ -18,6 +21,23 @@ type ResourceScopeLimiter interface {
 
 var _ ResourceScopeLimiter = (*resourceScope)(nil)
 
+// ResourceScopeShadow is a trait interface that allows you to control shadow mode for a scope;
+// in shadow mode, limit violations are reported but not enforced.
+type ResourceScopeShadow interface {
+	Shadow() bool
+	SetShadow(bool)
+}
+
+var _ ResourceScopeShadow = (*resourceScope)(nil)
+
+// ResourceScopeWaiter is a trait interface that allows you to block on memory reservations
+// until they can be satisfied.
+type ResourceScopeWaiter interface {
+	ReserveMemoryCtx(ctx context.Context, size int, prio uint8) error
+}
+
+var _ ResourceScopeWaiter = (*resourceScope)(nil)
+
 // ResourceManagerStat is a trait that allows you to access resource manager state.
 type ResourceManagerState interface {
 	ListServices() []string
@@ -28,15 +48,69 @@ type ResourceManagerState interface {
 }
 
 type ResourceManagerStat struct {
-	System    network.ScopeStat
-	Transient network.ScopeStat
-	Services  map[string]network.ScopeStat
-	Protocols map[protocol.ID]network.ScopeStat
-	Peers     map[peer.ID]network.ScopeStat
+	System               network.ScopeStat
+	Transient            network.ScopeStat
+	AllowlistedSystem    network.ScopeStat
+	AllowlistedTransient network.ScopeStat
+	Services             map[string]network.ScopeStat
+	Protocols            map[protocol.ID]network.ScopeStat
+	Peers                map[peer.ID]network.ScopeStat
+	IPs                  map[string]network.ScopeStat
+	Subnets              map[string]network.ScopeStat
 }
 
 var _ ResourceManagerState = (*resourceManager)(nil)
 
+// ResourceManagerLookup is a trait that allows you to view the existing service, protocol and peer
+// scopes without creating them; the lookup functions return false without calling f if the scope
+// does not exist.
+type ResourceManagerLookup interface {
+	LookupService(svc string, f func(network.ServiceScope) error) (bool, error)
+	LookupProtocol(proto protocol.ID, f func(network.ProtocolScope) error) (bool, error)
+	LookupPeer(p peer.ID, f func(network.PeerScope) error) (bool, error)
+}
+
+var _ ResourceManagerLookup = (*resourceManager)(nil)
+
+// ResourceManagerUpdater is a trait that allows you to update the limits of a running resource manager.
+type ResourceManagerUpdater interface {
+	UpdateLimiter(Limiter) LimitUpdate
+}
+
+var _ ResourceManagerUpdater = (*resourceManager)(nil)
+
+// ResourceManagerAllowlist is a trait that allows you to access the allowlist of the resource
+// manager and its dedicated scopes.
+type ResourceManagerAllowlist interface {
+	Allowlist() *Allowlist
+
+	ViewAllowlistedSystem(func(network.ResourceScope) error) error
+	ViewAllowlistedTransient(func(network.ResourceScope) error) error
+}
+
+var _ ResourceManagerAllowlist = (*resourceManager)(nil)
+
+// ResourceManagerEndpoint is a trait that allows you to open connections with a known remote
+// endpoint, which are accounted against the endpoint IP and subnet scopes and can use the
+// allowlisted scopes.
+type ResourceManagerEndpoint interface {
+	OpenConnectionWithEndpoint(dir network.Direction, usefd bool, endpoint multiaddr.Multiaddr) (network.ConnManagementScope, error)
+}
+
+var _ ResourceManagerEndpoint = (*resourceManager)(nil)
+
+// ResourceManagerPeerClasses is a trait that allows you to assign peers to the peer classes of
+// the limiter at runtime; the limits of the live scopes of the peer are updated accordingly.
+// Runtime assignments take precedence over the assignments of the limiter, and are kept when the
+// limiter is updated.
+type ResourceManagerPeerClasses interface {
+	AssignPeerClass(p peer.ID, class string) (LimitUpdate, error)
+	UnassignPeerClass(p peer.ID) LimitUpdate
+	PeerClass(p peer.ID) (string, bool)
+}
+
+var _ ResourceManagerPeerClasses = (*resourceManager)(nil)
+
 func (s *resourceScope) Limit() Limit {
 	s.Lock()
 	defer s.Unlock()
@@ -49,6 +123,21 @@ func (s *resourceScope) SetLimit(limit Limit) {
 	defer s.Unlock()
 
 	s.rc.limit = limit
+	s.notifyWaiters()
+}
+
+func (s *resourceScope) Shadow() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.shadow
+}
+
+func (s *resourceScope) SetShadow(shadow bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.shadow = shadow
 }
 
 func (s *protocolScope) SetLimit(limit Limit) {
@@ -123,6 +212,14 @@ func (r *resourceManager) Stat() (result ResourceManagerStat) {
 	for _, peer := range r.peer {
 		peers = append(peers, peer)
 	}
+	ips := make([]*ipScope, 0, len(r.ip))
+	for _, ip := range r.ip {
+		ips = append(ips, ip)
+	}
+	subnets := make([]*ipScope, 0, len(r.subnet))
+	for _, subnet := range r.subnet {
+		subnets = append(subnets, subnet)
+	}
 	r.mx.Unlock()
 
 	// Note: there is no global lock, so the system is updating while we are dumping its state...
@@ -136,10 +233,20 @@ func (r *resourceManager) Stat() (result ResourceManagerStat) {
 	for _, proto := range protos {
 		result.Protocols[proto.proto] = proto.Stat()
 	}
+	result.IPs = make(map[string]network.ScopeStat, len(ips))
+	for _, ip := range ips {
+		result.IPs[ip.ip.String()] = ip.Stat()
+	}
+	result.Subnets = make(map[string]network.ScopeStat, len(subnets))
+	for _, subnet := range subnets {
+		result.Subnets[subnet.subnet.String()] = subnet.Stat()
+	}
 	result.Services = make(map[string]network.ScopeStat, len(svcs))
 	for _, svc := range svcs {
 		result.Services[svc.name] = svc.Stat()
 	}
+	result.AllowlistedTransient = r.allowlistedTransient.Stat()
+	result.AllowlistedSystem = r.allowlistedSystem.Stat()
 	result.Transient = r.transient.Stat()
 	result.System = r.system.Stat()
 
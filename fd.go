@@ -0,0 +1,77 @@
+package rcmgr
+
+import (
+	"errors"
+)
+
+// FDSource provides the file descriptor limit of the process; it is used to size file
+// descriptor limits.
+type FDSource interface {
+	// FDLimit returns the maximum number of file descriptors the process may open.
+	FDLimit() (int, error)
+	// OpenFDs returns the number of file descriptors currently open by the process.
+	OpenFDs() (int, error)
+}
+
+// DefaultFDSource is the file descriptor source used by the limit configuration helpers.
+var DefaultFDSource FDSource = ProcessFDSource{}
+
+// ProcessFDSource is a file descriptor source that reports the soft RLIMIT_NOFILE limit of the
+// process and its open file descriptors from /proc/self/fd. It is only supported on Linux; on
+// other platforms it returns ErrFDSourceUnsupported.
+type ProcessFDSource struct{}
+
+var _ FDSource = ProcessFDSource{}
+
+// ErrFDSourceUnsupported is returned by ProcessFDSource on platforms where the file descriptor
+// limit of the process cannot be determined.
+var ErrFDSourceUnsupported = errors.New("file descriptor limit not supported on this platform")
+
+// WithSystemFD returns a copy of the config where the system FD limit is set to fdFraction of
+// the file descriptor limit of the process, as reported by DefaultFDSource, but no more than the
+// file descriptors that are not already open. The FD limits of all other scopes, except for
+// connections, are scaled proportionally. If the limit cannot be determined, the config is
+// returned unchanged.
+func (cfg *DefaultLimitConfig) WithSystemFD(fdFraction float64) DefaultLimitConfig {
+	limit, err := DefaultFDSource.FDLimit()
+	if err != nil {
+		log.Warnf("error reading file descriptor limit; using default FD limits: %s", err)
+		return *cfg
+	}
+
+	numFD := int(fdFraction * float64(limit))
+	if open, err := DefaultFDSource.OpenFDs(); err == nil && limit-open < numFD {
+		numFD = limit - open
+	}
+	if numFD < 1 {
+		numFD = 1
+	}
+
+	return cfg.withSystemFDLimit(numFD)
+}
+
+func (cfg *DefaultLimitConfig) withSystemFDLimit(numFD int) DefaultLimitConfig {
+	r := *cfg
+	if cfg.SystemBaseLimit.FD <= 0 {
+		r.SystemBaseLimit.FD = numFD
+		return r
+	}
+
+	refactor := float64(numFD) / float64(cfg.SystemBaseLimit.FD)
+	scale := func(fd int) int {
+		scaled := int(refactor * float64(fd))
+		if scaled < 1 && fd > 0 {
+			return 1
+		}
+		return scaled
+	}
+
+	r.SystemBaseLimit.FD = numFD
+	r.TransientBaseLimit.FD = scale(r.TransientBaseLimit.FD)
+	r.AllowlistedSystemBaseLimit.FD = scale(r.AllowlistedSystemBaseLimit.FD)
+	r.AllowlistedTransientBaseLimit.FD = scale(r.AllowlistedTransientBaseLimit.FD)
+	r.PeerBaseLimit.FD = scale(r.PeerBaseLimit.FD)
+	r.IPBaseLimit.FD = scale(r.IPBaseLimit.FD)
+	r.SubnetBaseLimit.FD = scale(r.SubnetBaseLimit.FD)
+	return r
+}
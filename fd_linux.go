@@ -0,0 +1,32 @@
+package rcmgr
+
+import (
+	"math"
+	"os"
+	"syscall"
+)
+
+func (ProcessFDSource) FDLimit() (int, error) {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, err
+	}
+
+	if rlimit.Cur > math.MaxInt32 {
+		return math.MaxInt32, nil
+	}
+	return int(rlimit.Cur), nil
+}
+
+func (ProcessFDSource) OpenFDs() (int, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+
+	// don't count the descriptor used to read the directory
+	if len(entries) > 0 {
+		return len(entries) - 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package rcmgr
+
+func (ProcessFDSource) FDLimit() (int, error) {
+	return 0, ErrFDSourceUnsupported
+}
+
+func (ProcessFDSource) OpenFDs() (int, error) {
+	return 0, ErrFDSourceUnsupported
+}
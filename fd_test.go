@@ -0,0 +1,71 @@
+package rcmgr
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+type fakeFDSource struct {
+	limit, open int
+	err         error
+}
+
+func (s fakeFDSource) FDLimit() (int, error) { return s.limit, s.err }
+func (s fakeFDSource) OpenFDs() (int, error) { return s.open, s.err }
+
+func TestWithSystemFD(t *testing.T) {
+	saved := DefaultFDSource
+	defer func() { DefaultFDSource = saved }()
+
+	DefaultFDSource = fakeFDSource{limit: 65536, open: 100}
+	cfg := DefaultLimits.WithSystemFD(0.5)
+	if cfg.SystemBaseLimit.FD != 32768 {
+		t.Fatalf("expected system FD limit of 32768, got %d", cfg.SystemBaseLimit.FD)
+	}
+
+	// transient and peer FD limits keep their proportion to the system limit
+	refactor := 32768 / float64(DefaultLimits.SystemBaseLimit.FD)
+	if fd := cfg.TransientBaseLimit.FD; fd != int(refactor*float64(DefaultLimits.TransientBaseLimit.FD)) {
+		t.Fatalf("unexpected transient FD limit: %d", fd)
+	}
+	if fd := cfg.PeerBaseLimit.FD; fd != int(refactor*float64(DefaultLimits.PeerBaseLimit.FD)) {
+		t.Fatalf("unexpected peer FD limit: %d", fd)
+	}
+	if cfg.ConnBaseLimit.FD != DefaultLimits.ConnBaseLimit.FD {
+		t.Fatalf("unexpected conn FD limit: %d", cfg.ConnBaseLimit.FD)
+	}
+
+	// the limit does not exceed the descriptors that are not open yet
+	DefaultFDSource = fakeFDSource{limit: 1024, open: 1000}
+	if fd := DefaultLimits.WithSystemFD(0.9).SystemBaseLimit.FD; fd != 24 {
+		t.Fatalf("expected system FD limit of 24, got %d", fd)
+	}
+
+	// the config is unchanged if the limit is unknown
+	DefaultFDSource = fakeFDSource{err: errors.New("boom")}
+	if fd := DefaultLimits.WithSystemFD(0.5).SystemBaseLimit.FD; fd != DefaultLimits.SystemBaseLimit.FD {
+		t.Fatalf("expected default system FD limit, got %d", fd)
+	}
+}
+
+func TestProcessFDSource(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := (ProcessFDSource{}).FDLimit(); err != ErrFDSourceUnsupported {
+			t.Fatalf("expected unsupported error, got %v", err)
+		}
+		return
+	}
+
+	limit, err := ProcessFDSource{}.FDLimit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, err := ProcessFDSource{}.OpenFDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open < 3 || open > limit {
+		t.Fatalf("unexpected open FDs %d with limit %d", open, limit)
+	}
+}
@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/ipfs/go-log/v2 v2.5.0
 	github.com/libp2p/go-libp2p-core v0.14.0
+	github.com/multiformats/go-multiaddr v0.4.1
 	github.com/pbnjay/memory v0.0.0-20210728143218-7b4eea64cf58
 	github.com/stretchr/testify v1.7.0
 )
@@ -22,7 +23,6 @@ require (
 	github.com/mr-tron/base58 v1.2.0 // indirect
 	github.com/multiformats/go-base32 v0.0.3 // indirect
 	github.com/multiformats/go-base36 v0.1.0 // indirect
-	github.com/multiformats/go-multiaddr v0.4.1 // indirect
 	github.com/multiformats/go-multibase v0.0.3 // indirect
 	github.com/multiformats/go-multihash v0.0.14 // indirect
 	github.com/multiformats/go-varint v0.0.6 // indirect
@@ -0,0 +1,214 @@
+package rcmgr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestIPScopes(t *testing.T) {
+	limit := &StaticLimit{
+		Memory: 4096,
+		BaseLimit: BaseLimit{
+			ConnsInbound:  16,
+			ConnsOutbound: 16,
+			Conns:         16,
+			FD:            16,
+		},
+	}
+	ipLimit := &StaticLimit{
+		Memory: 4096,
+		BaseLimit: BaseLimit{
+			ConnsInbound:  1,
+			ConnsOutbound: 1,
+			Conns:         1,
+			FD:            1,
+		},
+	}
+	subnetLimit := &StaticLimit{
+		Memory: 4096,
+		BaseLimit: BaseLimit{
+			ConnsInbound:  2,
+			ConnsOutbound: 2,
+			Conns:         2,
+			FD:            2,
+		},
+	}
+	nmgr, err := NewResourceManager(&BasicLimiter{
+		SystemLimits:              limit,
+		TransientLimits:           limit,
+		DefaultServiceLimits:      limit,
+		DefaultServicePeerLimits:  limit,
+		DefaultProtocolLimits:     limit,
+		DefaultProtocolPeerLimits: limit,
+		DefaultPeerLimits:         limit,
+		ConnLimits:                limit,
+		StreamLimits:              limit,
+		DefaultIPLimits:           ipLimit,
+		DefaultSubnetLimits:       subnetLimit,
+		IPv4SubnetPrefixLength:    24,
+		IPv6SubnetPrefixLength:    64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	conn1, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the IP address is at its limit
+	if _, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.4/tcp/4002")); err == nil {
+		t.Fatal("expected connection from the same IP to be blocked")
+	}
+
+	conn2, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.5/tcp/4001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Done()
+
+	// the subnet is at its limit
+	if _, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.6/tcp/4001")); err == nil {
+		t.Fatal("expected connection from the same subnet to be blocked")
+	}
+
+	// other subnets and endpoints without an IP address are not affected
+	conn3, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.4.4/tcp/4001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn3.Done()
+	conn4, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, multiaddr.StringCast("/dns4/example.com/tcp/4001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn4.Done()
+
+	stat := mgr.Stat()
+	if stat.IPs["1.2.3.4"].NumConnsInbound != 1 {
+		t.Fatalf("unexpected IP stat: %+v", stat.IPs)
+	}
+	if stat.Subnets["1.2.3.0/24"].NumConnsInbound != 2 {
+		t.Fatalf("unexpected subnet stat: %+v", stat.Subnets)
+	}
+	checkResources(t, &mgr.transient.rc, network.ScopeStat{NumConnsInbound: 4, NumFD: 4})
+
+	// the IP scopes keep constraining the connection once it is attached to a peer
+	if err := conn1.SetPeer(peer.ID("A")); err != nil {
+		t.Fatal(err)
+	}
+	checkResources(t, &mgr.transient.rc, network.ScopeStat{NumConnsInbound: 3, NumFD: 3})
+	checkResources(t, &mgr.ip["1.2.3.4"].rc, network.ScopeStat{NumConnsInbound: 1, NumFD: 1})
+	if _, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.4/tcp/4002")); err == nil {
+		t.Fatal("expected connection from the same IP to be blocked")
+	}
+
+	conn1.Done()
+	checkResources(t, &mgr.ip["1.2.3.4"].rc, network.ScopeStat{})
+	checkResources(t, &mgr.subnet["1.2.3.0/24"].rc, network.ScopeStat{NumConnsInbound: 1, NumFD: 1})
+
+	// unused IP scopes are garbage collected
+	mgr.gc()
+	if _, ok := mgr.ip["1.2.3.4"]; ok {
+		t.Fatal("expected unused IP scope to be garbage collected")
+	}
+	if _, ok := mgr.subnet["1.2.3.0/24"]; !ok {
+		t.Fatal("expected subnet scope in use to be retained")
+	}
+}
+
+func TestIPScopesConfig(t *testing.T) {
+	in := `{
+  "IPv6SubnetPrefixLength": 48,
+  "IP": {"1.2.3.4": {"Memory": 1048576, "ConnsInbound": 100}},
+  "Subnet": {"2001:db8::/48": {"Memory": 1048576, "ConnsInbound": 1000}}
+}`
+	limiter, err := NewDefaultLimiterFromJSON(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limiter.IPv4SubnetPrefixLength != 24 || limiter.IPv6SubnetPrefixLength != 48 {
+		t.Fatalf("unexpected prefix lengths: %d %d", limiter.IPv4SubnetPrefixLength, limiter.IPv6SubnetPrefixLength)
+	}
+	if l := limiter.IPLimits["1.2.3.4"]; l == nil || l.GetConnLimit(network.DirInbound) != 100 {
+		t.Fatalf("unexpected IP limit: %+v", l)
+	}
+	if l := limiter.SubnetLimits["2001:db8::/48"]; l == nil || l.GetConnLimit(network.DirInbound) != 1000 {
+		t.Fatalf("unexpected subnet limit: %+v", l)
+	}
+	if limiter.DefaultIPLimits.GetConnLimit(network.DirInbound) != DefaultLimits.IPBaseLimit.ConnsInbound {
+		t.Fatalf("unexpected default IP limit: %+v", limiter.DefaultIPLimits)
+	}
+
+	for _, in := range []string{
+		`{"IP": {"bogus": {}}}`,
+		`{"Subnet": {"1.2.3.4": {}}}`,
+		`{"IPv4SubnetPrefixLength": 33}`,
+	} {
+		if _, err := NewDefaultLimiterFromJSON(strings.NewReader(in)); err == nil {
+			t.Fatalf("expected invalid config %s to fail", in)
+		}
+	}
+}
+
+func TestIPScopesOptional(t *testing.T) {
+	// a limiter that only implements the Limiter interface does not limit addresses
+	limiter := struct{ Limiter }{NewDefaultLimiter()}
+	nmgr, err := NewResourceManager(limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	conn, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Done()
+
+	if len(mgr.ip) != 0 || len(mgr.subnet) != 0 {
+		t.Fatalf("unexpected IP scopes: %v %v", mgr.ip, mgr.subnet)
+	}
+}
+
+func TestIPScopesUpdateLimiter(t *testing.T) {
+	limiter := newTestUpdateLimiter(16384, 4)
+	limiter.DefaultIPLimits = &StaticLimit{Memory: 4096, BaseLimit: BaseLimit{Conns: 1, ConnsInbound: 1, ConnsOutbound: 1, FD: 1}}
+	nmgr, err := NewResourceManager(limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	addr := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")
+	conn, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Done()
+	if _, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, addr); err == nil {
+		t.Fatal("expected connection from the same IP to be blocked")
+	}
+
+	// the live IP scope is no longer constrained once the limiter stops limiting the address
+	result := mgr.UpdateLimiter(newTestUpdateLimiter(16384, 4))
+	if len(result.Updated) != 1 || result.Updated[0] != "ip:1.2.3.4" {
+		t.Fatalf("unexpected updated scopes: %v", result.Updated)
+	}
+	conn2, err := mgr.OpenConnectionWithEndpoint(network.DirInbound, true, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn2.Done()
+}
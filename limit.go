@@ -1,6 +1,8 @@
 package rcmgr
 
 import (
+	"net"
+
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
@@ -43,12 +45,67 @@ type Limiter interface {
 	GetServicePeerLimits(svc string) Limit
 	GetProtocolLimits(proto protocol.ID) Limit
 	GetProtocolPeerLimits(proto protocol.ID) Limit
+	// GetServicePeerOverrideLimits and GetProtocolPeerOverrideLimits return the limits of the
+	// service and protocol peer scopes of a specific peer, or nil if the peer has no overrides.
+	GetServicePeerOverrideLimits(svc string, p peer.ID) Limit
+	GetProtocolPeerOverrideLimits(proto protocol.ID, p peer.ID) Limit
 	GetPeerLimits(p peer.ID) Limit
 	GetStreamLimits(p peer.ID) Limit
+	// GetProtocolStreamLimits and GetServiceStreamLimits return the limits of a stream once its
+	// protocol and service are known; they replace the limits returned by GetStreamLimits.
+	GetProtocolStreamLimits(p peer.ID, proto protocol.ID) Limit
+	GetServiceStreamLimits(p peer.ID, proto protocol.ID, svc string) Limit
 	GetConnLimits() Limit
 }
 
+// ipLimiter is implemented by limiters that limit connections by remote IP address and subnet; the
+// resource manager creates no IP or subnet scopes with limiters that do not implement it.
+type ipLimiter interface {
+	GetIPLimits(ip net.IP) Limit
+	GetSubnetLimits(subnet *net.IPNet) Limit
+	GetSubnetPrefixLength(ip net.IP) int
+}
+
+var _ ipLimiter = (*BasicLimiter)(nil)
+
+// allowlistLimiter is implemented by limiters that provide limits for the allowlisted system and
+// transient scopes, and that carry an initial allowlist; with limiters that do not implement it,
+// the allowlisted scopes use the system and transient limits.
+type allowlistLimiter interface {
+	GetAllowlistedSystemLimits() Limit
+	GetAllowlistedTransientLimits() Limit
+	GetAllowlist() *Allowlist
+}
+
+var _ allowlistLimiter = (*BasicLimiter)(nil)
+
+func allowlistedSystemLimits(l Limiter) Limit {
+	if al, ok := l.(allowlistLimiter); ok {
+		return al.GetAllowlistedSystemLimits()
+	}
+	return l.GetSystemLimits()
+}
+
+func allowlistedTransientLimits(l Limiter) Limit {
+	if al, ok := l.(allowlistLimiter); ok {
+		return al.GetAllowlistedTransientLimits()
+	}
+	return l.GetTransientLimits()
+}
+
 // BasicLimiter is a limiter with fixed limits.
+//
+// ProtocolLimits and ProtocolPeerLimits are keyed by protocol or by protocol pattern, in which "*"
+// matches any sequence of characters, e.g. "/ipfs/bitswap/*". A protocol without limits of its
+// own uses the limits of the matching pattern with the most literal characters; ties are broken
+// in favor of fewer wildcards, then of the lexicographically smaller pattern.
+//
+// ServicePeerOverrideLimits and ProtocolPeerOverrideLimits are keyed by peer, then by service or
+// protocol (pattern); they take precedence over all other service and protocol peer limits.
+//
+// ProtocolStreamLimits and ServiceStreamLimits are the limits of the streams of a protocol (or
+// protocol pattern) and of a service, applied when the stream is attached to them; service stream
+// limits take precedence over protocol stream limits, which take precedence over StreamLimits.
 type BasicLimiter struct {
 	SystemLimits              Limit
 	TransientLimits           Limit
@@ -64,6 +121,40 @@ type BasicLimiter struct {
 	PeerLimits                map[peer.ID]Limit
 	ConnLimits                Limit
 	StreamLimits              Limit
+
+	ProtocolStreamLimits map[protocol.ID]Limit
+	ServiceStreamLimits  map[string]Limit
+
+	ServicePeerOverrideLimits  map[peer.ID]map[string]Limit
+	ProtocolPeerOverrideLimits map[peer.ID]map[protocol.ID]Limit
+
+	// AllowlistedSystemLimits and AllowlistedTransientLimits are the limits of the scopes used by
+	// allowlisted connections; if unset, the system and transient limits are used respectively.
+	AllowlistedSystemLimits    Limit
+	AllowlistedTransientLimits Limit
+	// Allowlist is the initial allowlist of the resource manager, if any.
+	Allowlist *Allowlist
+
+	// DefaultIPLimits and DefaultSubnetLimits are the limits of the per-IP and per-subnet scopes
+	// constraining connections; if unset, connections are not constrained by remote address.
+	DefaultIPLimits     Limit
+	IPLimits            map[string]Limit
+	DefaultSubnetLimits Limit
+	SubnetLimits        map[string]Limit
+	// IPv4SubnetPrefixLength and IPv6SubnetPrefixLength are the prefix lengths of the subnets
+	// for per-subnet scopes; if zero, there are no per-subnet scopes for the address family.
+	IPv4SubnetPrefixLength int
+	IPv6SubnetPrefixLength int
+
+	// SharedProtocolScopes makes all protocols matching a pattern in ProtocolLimits share a
+	// single protocol scope, named after the pattern, instead of each protocol having its own.
+	SharedProtocolScopes bool
+
+	// PeerClasses are named classes of peers with their own peer, service peer and protocol peer
+	// limits; PeerClassAssignments is the initial assignment of peers to classes. A class limit
+	// takes precedence over the limits of the peer in PeerLimits.
+	PeerClasses          map[string]*PeerClassLimits
+	PeerClassAssignments map[peer.ID]string
 }
 
 var _ Limiter = (*BasicLimiter)(nil)
@@ -77,6 +168,13 @@ type BaseLimit struct {
 	ConnsInbound    int
 	ConnsOutbound   int
 	FD              int
+
+	// ConnRateInbound, ConnRateOutbound, StreamRateInbound and StreamRateOutbound limit the rate
+	// at which connections and streams are opened in the scope; see RateLimit.
+	ConnRateInbound    RateLimit
+	ConnRateOutbound   RateLimit
+	StreamRateInbound  RateLimit
+	StreamRateOutbound RateLimit
 }
 
 // MemoryLimit is a mixin type for memory limits
@@ -122,6 +220,24 @@ func (l *BasicLimiter) GetTransientLimits() Limit {
 	return l.TransientLimits
 }
 
+func (l *BasicLimiter) GetAllowlistedSystemLimits() Limit {
+	if l.AllowlistedSystemLimits == nil {
+		return l.SystemLimits
+	}
+	return l.AllowlistedSystemLimits
+}
+
+func (l *BasicLimiter) GetAllowlistedTransientLimits() Limit {
+	if l.AllowlistedTransientLimits == nil {
+		return l.TransientLimits
+	}
+	return l.AllowlistedTransientLimits
+}
+
+func (l *BasicLimiter) GetAllowlist() *Allowlist {
+	return l.Allowlist
+}
+
 func (l *BasicLimiter) GetServiceLimits(svc string) Limit {
 	sl, ok := l.ServiceLimits[svc]
 	if !ok {
@@ -139,7 +255,7 @@ func (l *BasicLimiter) GetServicePeerLimits(svc string) Limit {
 }
 
 func (l *BasicLimiter) GetProtocolLimits(proto protocol.ID) Limit {
-	pl, ok := l.ProtocolLimits[proto]
+	pl, ok := protocolLimit(l.ProtocolLimits, proto)
 	if !ok {
 		return l.DefaultProtocolLimits
 	}
@@ -147,13 +263,22 @@ func (l *BasicLimiter) GetProtocolLimits(proto protocol.ID) Limit {
 }
 
 func (l *BasicLimiter) GetProtocolPeerLimits(proto protocol.ID) Limit {
-	pl, ok := l.ProtocolPeerLimits[proto]
+	pl, ok := protocolLimit(l.ProtocolPeerLimits, proto)
 	if !ok {
 		return l.DefaultProtocolPeerLimits
 	}
 	return pl
 }
 
+func (l *BasicLimiter) GetServicePeerOverrideLimits(svc string, p peer.ID) Limit {
+	return l.ServicePeerOverrideLimits[p][svc]
+}
+
+func (l *BasicLimiter) GetProtocolPeerOverrideLimits(proto protocol.ID, p peer.ID) Limit {
+	pl, _ := protocolLimit(l.ProtocolPeerOverrideLimits[p], proto)
+	return pl
+}
+
 func (l *BasicLimiter) GetPeerLimits(p peer.ID) Limit {
 	pl, ok := l.PeerLimits[p]
 	if !ok {
@@ -166,10 +291,49 @@ func (l *BasicLimiter) GetStreamLimits(p peer.ID) Limit {
 	return l.StreamLimits
 }
 
+func (l *BasicLimiter) GetProtocolStreamLimits(p peer.ID, proto protocol.ID) Limit {
+	sl, ok := protocolLimit(l.ProtocolStreamLimits, proto)
+	if !ok {
+		return l.GetStreamLimits(p)
+	}
+	return sl
+}
+
+func (l *BasicLimiter) GetServiceStreamLimits(p peer.ID, proto protocol.ID, svc string) Limit {
+	sl, ok := l.ServiceStreamLimits[svc]
+	if !ok {
+		return l.GetProtocolStreamLimits(p, proto)
+	}
+	return sl
+}
+
 func (l *BasicLimiter) GetConnLimits() Limit {
 	return l.ConnLimits
 }
 
+func (l *BasicLimiter) GetIPLimits(ip net.IP) Limit {
+	il, ok := l.IPLimits[ip.String()]
+	if !ok {
+		return l.DefaultIPLimits
+	}
+	return il
+}
+
+func (l *BasicLimiter) GetSubnetLimits(subnet *net.IPNet) Limit {
+	sl, ok := l.SubnetLimits[subnet.String()]
+	if !ok {
+		return l.DefaultSubnetLimits
+	}
+	return sl
+}
+
+func (l *BasicLimiter) GetSubnetPrefixLength(ip net.IP) int {
+	if ip.To4() != nil {
+		return l.IPv4SubnetPrefixLength
+	}
+	return l.IPv6SubnetPrefixLength
+}
+
 func (l *MemoryLimit) GetMemory(memoryCap int64) int64 {
 	return memoryLimit(memoryCap, l.MemoryFraction, l.MinMemory, l.MaxMemory)
 }
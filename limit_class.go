@@ -0,0 +1,378 @@
+package rcmgr
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// PeerClassLimits are the limits of a named class of peers. Limits that are not set fall back to
+// the respective limits of the limiter.
+type PeerClassLimits struct {
+	// PeerLimits is the limit of the peer scopes of the peers in the class.
+	PeerLimits Limit
+	// DefaultServicePeerLimits and ServicePeerLimits are the limits of the per-service peer
+	// scopes of the peers in the class.
+	DefaultServicePeerLimits Limit
+	ServicePeerLimits        map[string]Limit
+	// DefaultProtocolPeerLimits and ProtocolPeerLimits are the limits of the per-protocol peer
+	// scopes of the peers in the class; ProtocolPeerLimits can be keyed by protocol pattern.
+	DefaultProtocolPeerLimits Limit
+	ProtocolPeerLimits        map[protocol.ID]Limit
+}
+
+// peerClassLimiter is implemented by limiters that support peer classes.
+type peerClassLimiter interface {
+	// GetPeerClassLimits returns the limits of a peer class, or nil if there is no such class.
+	GetPeerClassLimits(class string) *PeerClassLimits
+	// GetPeerClassAssignments returns the initial assignment of peers to classes.
+	GetPeerClassAssignments() map[peer.ID]string
+}
+
+var _ peerClassLimiter = (*BasicLimiter)(nil)
+
+func (l *BasicLimiter) GetPeerClassLimits(class string) *PeerClassLimits {
+	return l.PeerClasses[class]
+}
+
+func (l *BasicLimiter) GetPeerClassAssignments() map[peer.ID]string {
+	return l.PeerClassAssignments
+}
+
+// peerClassLimits returns the limits of a peer class, or nil if the class is empty or unknown to
+// the limiter.
+func peerClassLimits(limits Limiter, class string) *PeerClassLimits {
+	if class == "" {
+		return nil
+	}
+	cl, ok := limits.(peerClassLimiter)
+	if !ok {
+		return nil
+	}
+	return cl.GetPeerClassLimits(class)
+}
+
+func classPeerLimits(limits Limiter, class string, p peer.ID) Limit {
+	if c := peerClassLimits(limits, class); c != nil && c.PeerLimits != nil {
+		return c.PeerLimits
+	}
+	return limits.GetPeerLimits(p)
+}
+
+func classServicePeerLimits(limits Limiter, class string, svc string) Limit {
+	if c := peerClassLimits(limits, class); c != nil {
+		if l, ok := c.ServicePeerLimits[svc]; ok {
+			return l
+		}
+		if c.DefaultServicePeerLimits != nil {
+			return c.DefaultServicePeerLimits
+		}
+	}
+	return limits.GetServicePeerLimits(svc)
+}
+
+func classProtocolPeerLimits(limits Limiter, class string, proto protocol.ID) Limit {
+	if c := peerClassLimits(limits, class); c != nil {
+		if l, ok := protocolLimit(c.ProtocolPeerLimits, proto); ok {
+			return l
+		}
+		if c.DefaultProtocolPeerLimits != nil {
+			return c.DefaultProtocolPeerLimits
+		}
+	}
+	return limits.GetProtocolPeerLimits(proto)
+}
+
+// PeerClassConfig is the JSON configuration of a peer class.
+type PeerClassConfig struct {
+	// Peers are the peers initially assigned to the class.
+	Peers []string `json:",omitempty"`
+
+	Peer                *BasicLimitConfig           `json:",omitempty"`
+	ServicePeerDefault  *BasicLimitConfig           `json:",omitempty"`
+	ServicePeer         map[string]BasicLimitConfig `json:",omitempty"`
+	ProtocolPeerDefault *BasicLimitConfig           `json:",omitempty"`
+	ProtocolPeer        map[string]BasicLimitConfig `json:",omitempty"`
+}
+
+func (cfg *PeerClassConfig) toLimits(defaults DefaultLimitConfig) (*PeerClassLimits, error) {
+	c := new(PeerClassLimits)
+	var err error
+
+	optional := func(cfg *BasicLimitConfig, base BaseLimit, mem MemoryLimit) (Limit, error) {
+		if cfg == nil {
+			return nil, nil
+		}
+		return cfg.toLimit(base, mem)
+	}
+
+	c.PeerLimits, err = optional(cfg.Peer, defaults.PeerBaseLimit, defaults.PeerMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer limit: %w", err)
+	}
+
+	c.DefaultServicePeerLimits, err = optional(cfg.ServicePeerDefault, defaults.ServicePeerBaseLimit, defaults.ServicePeerMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default service peer limit: %w", err)
+	}
+
+	if len(cfg.ServicePeer) > 0 {
+		c.ServicePeerLimits = make(map[string]Limit, len(cfg.ServicePeer))
+		for svc, cfgLimit := range cfg.ServicePeer {
+			c.ServicePeerLimits[svc], err = cfgLimit.toLimit(defaults.ServicePeerBaseLimit, defaults.ServicePeerMemory)
+			if err != nil {
+				return nil, fmt.Errorf("invalid service peer limit for %s: %w", svc, err)
+			}
+		}
+	}
+
+	c.DefaultProtocolPeerLimits, err = optional(cfg.ProtocolPeerDefault, defaults.ProtocolPeerBaseLimit, defaults.ProtocolPeerMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default protocol peer limit: %w", err)
+	}
+
+	if len(cfg.ProtocolPeer) > 0 {
+		c.ProtocolPeerLimits = make(map[protocol.ID]Limit, len(cfg.ProtocolPeer))
+		for p, cfgLimit := range cfg.ProtocolPeer {
+			c.ProtocolPeerLimits[protocol.ID(p)], err = cfgLimit.toLimit(defaults.ProtocolPeerBaseLimit, defaults.ProtocolPeerMemory)
+			if err != nil {
+				return nil, fmt.Errorf("invalid protocol peer limit for %s: %w", p, err)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// peerClassesFromConfig creates the peer class limits and initial assignments of a limiter
+// configuration.
+func peerClassesFromConfig(cfgs map[string]PeerClassConfig, defaults DefaultLimitConfig) (map[string]*PeerClassLimits, map[peer.ID]string, error) {
+	if len(cfgs) == 0 {
+		return nil, nil, nil
+	}
+
+	classes := make(map[string]*PeerClassLimits, len(cfgs))
+	var assignments map[peer.ID]string
+	for name, cfg := range cfgs {
+		if name == "" {
+			return nil, nil, fmt.Errorf("empty peer class name")
+		}
+
+		c, err := cfg.toLimits(defaults)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid peer class %s: %w", name, err)
+		}
+		classes[name] = c
+
+		for _, s := range cfg.Peers {
+			p, err := peer.Decode(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid peer ID %s in peer class %s: %w", s, name, err)
+			}
+			if other, ok := assignments[p]; ok && other != name {
+				return nil, nil, fmt.Errorf("peer %s assigned to peer classes %s and %s", s, other, name)
+			}
+			if assignments == nil {
+				assignments = make(map[peer.ID]string)
+			}
+			assignments[p] = name
+		}
+	}
+
+	return classes, assignments, nil
+}
+
+// toConfig returns the configuration of the class, with the peers initially assigned to it.
+func (c *PeerClassLimits) toConfig(peers []string) (PeerClassConfig, error) {
+	cfg := PeerClassConfig{Peers: peers}
+	var err error
+
+	if cfg.Peer, err = limitConfig(c.PeerLimits); err != nil {
+		return cfg, fmt.Errorf("invalid peer limit: %w", err)
+	}
+	if cfg.ServicePeerDefault, err = limitConfig(c.DefaultServicePeerLimits); err != nil {
+		return cfg, fmt.Errorf("invalid default service peer limit: %w", err)
+	}
+	for svc, l := range c.ServicePeerLimits {
+		lcfg, err := limitConfig(l)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid service peer limit for %s: %w", svc, err)
+		}
+		if lcfg == nil {
+			continue
+		}
+		if cfg.ServicePeer == nil {
+			cfg.ServicePeer = make(map[string]BasicLimitConfig)
+		}
+		cfg.ServicePeer[svc] = *lcfg
+	}
+	if cfg.ProtocolPeerDefault, err = limitConfig(c.DefaultProtocolPeerLimits); err != nil {
+		return cfg, fmt.Errorf("invalid default protocol peer limit: %w", err)
+	}
+	for proto, l := range c.ProtocolPeerLimits {
+		lcfg, err := limitConfig(l)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid protocol peer limit for %s: %w", proto, err)
+		}
+		if lcfg == nil {
+			continue
+		}
+		if cfg.ProtocolPeer == nil {
+			cfg.ProtocolPeer = make(map[string]BasicLimitConfig)
+		}
+		cfg.ProtocolPeer[string(proto)] = *lcfg
+	}
+
+	return cfg, nil
+}
+
+// peerClassesToConfig returns the configuration of the peer classes of a limiter.
+func peerClassesToConfig(classes map[string]*PeerClassLimits, assignments map[peer.ID]string) (map[string]PeerClassConfig, error) {
+	if len(classes) == 0 {
+		return nil, nil
+	}
+
+	peers := make(map[string][]string)
+	for p, class := range assignments {
+		peers[class] = append(peers[class], p.String())
+	}
+
+	cfgs := make(map[string]PeerClassConfig, len(classes))
+	for name, c := range classes {
+		if c == nil {
+			continue
+		}
+		sort.Strings(peers[name])
+		cfg, err := c.toConfig(peers[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer class %s: %w", name, err)
+		}
+		cfgs[name] = cfg
+	}
+
+	return cfgs, nil
+}
+
+// setLimiterPeerClasses replaces the assignment of peers to classes made by the limiter; the
+// assignments made through AssignPeerClass and UnassignPeerClass are kept.
+func (r *resourceManager) setLimiterPeerClasses(assignments map[peer.ID]string) {
+	limiterPeerClass := make(map[peer.ID]string, len(assignments))
+	for p, class := range assignments {
+		limiterPeerClass[p] = class
+	}
+
+	r.classMx.Lock()
+	defer r.classMx.Unlock()
+
+	r.limiterPeerClass = limiterPeerClass
+}
+
+func (r *resourceManager) getPeerClass(p peer.ID) string {
+	class, _ := r.PeerClass(p)
+	return class
+}
+
+func (r *resourceManager) peerLimits(limits Limiter, p peer.ID) Limit {
+	return classPeerLimits(limits, r.getPeerClass(p), p)
+}
+
+// servicePeerLimits returns the limits of the service peer scope of a peer: the overrides for the
+// peer take precedence over the limits of its class, which take precedence over the service limits.
+func (r *resourceManager) servicePeerLimits(limits Limiter, svc string, p peer.ID) Limit {
+	if l := limits.GetServicePeerOverrideLimits(svc, p); l != nil {
+		return l
+	}
+	return classServicePeerLimits(limits, r.getPeerClass(p), svc)
+}
+
+// protocolPeerLimits returns the limits of the protocol peer scope of a peer, with the same
+// precedence as servicePeerLimits.
+func (r *resourceManager) protocolPeerLimits(limits Limiter, proto protocol.ID, p peer.ID) Limit {
+	if l := limits.GetProtocolPeerOverrideLimits(proto, p); l != nil {
+		return l
+	}
+	return classProtocolPeerLimits(limits, r.getPeerClass(p), proto)
+}
+
+// AssignPeerClass assigns a peer to a class of the limiter and applies the limits of the class to
+// the live scopes of the peer.
+func (r *resourceManager) AssignPeerClass(p peer.ID, class string) (LimitUpdate, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	limits := r.limiter()
+	if peerClassLimits(limits, class) == nil {
+		return LimitUpdate{}, fmt.Errorf("unknown peer class %s", class)
+	}
+
+	r.classMx.Lock()
+	if r.peerClass == nil {
+		r.peerClass = make(map[peer.ID]string)
+	}
+	r.peerClass[p] = class
+	r.classMx.Unlock()
+
+	return r.updatePeerLimits(limits, p), nil
+}
+
+// UnassignPeerClass removes a peer from its class and applies the limits of the limiter to the
+// live scopes of the peer.
+func (r *resourceManager) UnassignPeerClass(p peer.ID) LimitUpdate {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.classMx.Lock()
+	if r.peerClass == nil {
+		r.peerClass = make(map[peer.ID]string)
+	}
+	r.peerClass[p] = ""
+	r.classMx.Unlock()
+
+	return r.updatePeerLimits(r.limiter(), p)
+}
+
+// PeerClass returns the class a peer is assigned to, if any.
+func (r *resourceManager) PeerClass(p peer.ID) (string, bool) {
+	r.classMx.RLock()
+	defer r.classMx.RUnlock()
+
+	class, ok := r.peerClass[p]
+	if !ok {
+		class = r.limiterPeerClass[p]
+	}
+	return class, class != ""
+}
+
+// updatePeerLimits re-resolves the limits of the peer scope of a peer and of its per-service and
+// per-protocol peer scopes; the caller must hold the resource manager lock.
+func (r *resourceManager) updatePeerLimits(limits Limiter, p peer.ID) LimitUpdate {
+	var result LimitUpdate
+
+	if s, ok := r.peer[p]; ok {
+		result.update(s.resourceScope, r.peerLimits(limits, p))
+	}
+
+	for name, s := range r.svc {
+		s.Lock()
+		if ps, ok := s.peers[p]; ok {
+			result.update(ps, r.servicePeerLimits(limits, name, p))
+		}
+		s.Unlock()
+	}
+
+	for proto, s := range r.proto {
+		s.Lock()
+		if ps, ok := s.peers[p]; ok {
+			result.update(ps, r.protocolPeerLimits(limits, proto, p))
+		}
+		s.Unlock()
+	}
+
+	for _, name := range result.OverLimit {
+		log.Warnw("scope exceeds its limit after peer class change", "scope", name)
+	}
+
+	return result
+}
@@ -0,0 +1,386 @@
+package rcmgr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+func TestPeerClasses(t *testing.T) {
+	peerA := peer.ID("A")
+	peerB := peer.ID("B")
+	protoA := protocol.ID("/A")
+
+	limiter := newTestUpdateLimiter(16384, 4)
+	limiter.PeerClasses = map[string]*PeerClassLimits{
+		"restricted": {
+			PeerLimits: &StaticLimit{Memory: 4096, BaseLimit: limiter.DefaultPeerLimits.(*StaticLimit).BaseLimit},
+			ProtocolPeerLimits: map[protocol.ID]Limit{
+				"/*": &StaticLimit{Memory: 2048, BaseLimit: limiter.DefaultPeerLimits.(*StaticLimit).BaseLimit},
+			},
+		},
+	}
+	limiter.PeerClassAssignments = map[peer.ID]string{peerB: "restricted"}
+
+	nmgr, err := NewResourceManager(limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	peerMemory := func(p peer.ID) int64 {
+		var mem int64
+		if err := mgr.ViewPeer(p, func(s network.PeerScope) error {
+			mem = s.(*peerScope).Limit().GetMemoryLimit()
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return mem
+	}
+	protocolPeerMemory := func(p peer.ID) int64 {
+		var mem int64
+		if err := mgr.ViewProtocol(protoA, func(s network.ProtocolScope) error {
+			ps := s.(*protocolScope).getPeerScope(p)
+			defer ps.DecRef()
+			mem = ps.Limit().GetMemoryLimit()
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return mem
+	}
+
+	// initial assignments apply to new scopes
+	if class, ok := mgr.PeerClass(peerB); !ok || class != "restricted" {
+		t.Fatalf("expected peer B in class restricted, got %q", class)
+	}
+	if mem := peerMemory(peerB); mem != 4096 {
+		t.Fatalf("expected memory limit of 4096, got %d", mem)
+	}
+
+	stream, err := mgr.OpenStream(peerA, network.DirInbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Done()
+	if err := stream.SetProtocol(protoA); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.ReserveMemory(8192, network.ReservationPriorityAlways); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mgr.AssignPeerClass(peerA, "unknown"); err == nil {
+		t.Fatal("expected error assigning unknown peer class")
+	}
+
+	// assigning re-resolves the limits of the live scopes of the peer
+	result, err := mgr.AssignPeerClass(peerA, "restricted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectUpdated := map[string]bool{
+		"peer:" + peerA.String():             true,
+		"protocol:/A.peer:" + peerA.String(): true,
+	}
+	if len(result.Updated) != len(expectUpdated) || len(result.OverLimit) != len(expectUpdated) {
+		t.Fatalf("unexpected update result: %+v", result)
+	}
+	for _, name := range result.Updated {
+		if !expectUpdated[name] {
+			t.Fatalf("unexpected updated scope %s", name)
+		}
+	}
+	if mem := protocolPeerMemory(peerA); mem != 2048 {
+		t.Fatalf("expected memory limit of 2048, got %d", mem)
+	}
+	if err := stream.ReserveMemory(1024, network.ReservationPriorityAlways); err == nil {
+		t.Fatal("expected memory reservation to fail")
+	}
+
+	// unassigning restores the limits of the limiter
+	result = mgr.UnassignPeerClass(peerA)
+	if len(result.Updated) != 2 || len(result.OverLimit) != 0 {
+		t.Fatalf("unexpected update result: %+v", result)
+	}
+	if _, ok := mgr.PeerClass(peerA); ok {
+		t.Fatal("expected peer A to have no class")
+	}
+	if mem := peerMemory(peerA); mem != 16384 {
+		t.Fatalf("expected memory limit of 16384, got %d", mem)
+	}
+	if err := stream.ReserveMemory(1024, network.ReservationPriorityAlways); err != nil {
+		t.Fatal(err)
+	}
+
+	// assignments survive limiter updates, and the class limits of the new limiter apply
+	if _, err := mgr.AssignPeerClass(peerA, "restricted"); err != nil {
+		t.Fatal(err)
+	}
+	mgr.UpdateLimiter(newTestUpdateLimiter(16384, 4))
+	if mem := peerMemory(peerA); mem != 16384 {
+		t.Fatalf("expected memory limit of 16384, got %d", mem)
+	}
+	if class, ok := mgr.PeerClass(peerA); !ok || class != "restricted" {
+		t.Fatalf("expected peer A in class restricted, got %q", class)
+	}
+}
+
+func TestPeerClassUpdateLimiter(t *testing.T) {
+	peerA := peer.ID("A")
+	peerB := peer.ID("B")
+	peerC := peer.ID("C")
+
+	newLimiter := func(assignments map[peer.ID]string) *BasicLimiter {
+		limiter := newTestUpdateLimiter(16384, 4)
+		limiter.PeerClasses = map[string]*PeerClassLimits{
+			"restricted": {
+				PeerLimits: &StaticLimit{Memory: 4096, BaseLimit: limiter.DefaultPeerLimits.(*StaticLimit).BaseLimit},
+			},
+		}
+		limiter.PeerClassAssignments = assignments
+		return limiter
+	}
+
+	nmgr, err := NewResourceManager(newLimiter(map[peer.ID]string{peerA: "restricted", peerB: "restricted"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	peerMemory := func(p peer.ID) int64 {
+		var mem int64
+		if err := mgr.ViewPeer(p, func(s network.PeerScope) error {
+			mem = s.(*peerScope).Limit().GetMemoryLimit()
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return mem
+	}
+
+	if mem := peerMemory(peerA); mem != 4096 {
+		t.Fatalf("expected memory limit of 4096, got %d", mem)
+	}
+	if _, err := mgr.AssignPeerClass(peerC, "restricted"); err != nil {
+		t.Fatal(err)
+	}
+
+	// removing a peer from a class in the limiter removes it from the class, while runtime
+	// assignments are kept
+	mgr.UpdateLimiter(newLimiter(map[peer.ID]string{peerB: "restricted"}))
+	if class, ok := mgr.PeerClass(peerA); ok {
+		t.Fatalf("expected peer A to have no class, got %q", class)
+	}
+	if mem := peerMemory(peerA); mem != 16384 {
+		t.Fatalf("expected memory limit of 16384, got %d", mem)
+	}
+	for _, p := range []peer.ID{peerB, peerC} {
+		if class, ok := mgr.PeerClass(p); !ok || class != "restricted" {
+			t.Fatalf("expected peer %s in class restricted, got %q", p, class)
+		}
+		if mem := peerMemory(p); mem != 4096 {
+			t.Fatalf("expected memory limit of 4096 for peer %s, got %d", p, mem)
+		}
+	}
+
+	// unassigning a peer at runtime takes precedence over the limiter
+	mgr.UnassignPeerClass(peerB)
+	mgr.UpdateLimiter(newLimiter(map[peer.ID]string{peerB: "restricted"}))
+	if class, ok := mgr.PeerClass(peerB); ok {
+		t.Fatalf("expected peer B to have no class, got %q", class)
+	}
+	if mem := peerMemory(peerB); mem != 16384 {
+		t.Fatalf("expected memory limit of 16384, got %d", mem)
+	}
+}
+
+func TestPeerClassConfig(t *testing.T) {
+	const cfg = `{
+  "PeerDefault": {"Streams": 10},
+  "PeerClass": {
+    "restricted": {
+      "Peers": ["QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"],
+      "Peer": {"Streams": 100, "Memory": 1048576},
+      "ServicePeer": {"A.svc": {"Streams": 50, "Memory": 1024}},
+      "ProtocolPeerDefault": {"Streams": "unlimited", "Memory": 2048}
+    }
+  }
+}`
+
+	limiter, err := NewLimiterFromJSONStrict(strings.NewReader(cfg), DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := peer.Decode("QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limiter.PeerClassAssignments[p] != "restricted" {
+		t.Fatalf("expected peer in class restricted, got %v", limiter.PeerClassAssignments)
+	}
+
+	class := limiter.GetPeerClassLimits("restricted")
+	if class == nil {
+		t.Fatal("missing peer class")
+	}
+	if l := classPeerLimits(limiter, "restricted", p); l.GetStreamTotalLimit() != 100 {
+		t.Fatalf("expected 100 streams, got %d", l.GetStreamTotalLimit())
+	}
+	if l := classServicePeerLimits(limiter, "restricted", "A.svc"); l.GetStreamTotalLimit() != 50 {
+		t.Fatalf("expected 50 streams, got %d", l.GetStreamTotalLimit())
+	}
+	if l := classServicePeerLimits(limiter, "restricted", "B.svc"); l != limiter.DefaultServicePeerLimits {
+		t.Fatal("expected service peer limits to fall back to the limiter")
+	}
+	if l := classProtocolPeerLimits(limiter, "restricted", "/A"); l.GetMemoryLimit() != 2048 {
+		t.Fatalf("expected memory limit of 2048, got %d", l.GetMemoryLimit())
+	}
+	if l := classPeerLimits(limiter, "", p); l.GetStreamTotalLimit() != 10 {
+		t.Fatalf("expected 10 streams, got %d", l.GetStreamTotalLimit())
+	}
+
+	// classes round trip through the limiter configuration
+	out, err := limiter.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter2, err := NewLimiter(out, DefaultLimitConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(limiter.PeerClasses, limiter2.PeerClasses) ||
+		!reflect.DeepEqual(limiter.PeerClassAssignments, limiter2.PeerClassAssignments) {
+		t.Fatal("peer classes do not round trip")
+	}
+
+	const bad = `{
+  "PeerClass": {
+    "a": {"Peers": ["QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC", "not a peer"]},
+    "b": {"Peers": ["QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"], "Peers2": []}
+  }
+}`
+	diags, err := ValidateJSON(strings.NewReader(bad))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		`$.PeerClass["a"].Peers[1]`,
+		`$.PeerClass["b"].Peers[0]`,
+		`$.PeerClass["b"].Peers2`,
+	} {
+		if !hasDiagnostic(diags, SeverityError, path) {
+			t.Errorf("missing error at %s in %v", path, diags)
+		}
+	}
+	if _, err := NewLimiterFromJSON(strings.NewReader(bad), DefaultLimits); err == nil {
+		t.Fatal("expected error for invalid peer classes")
+	}
+}
+
+func TestPeerOverrides(t *testing.T) {
+	const cfg = `{
+  "ServicePeerDefault": {"StreamsInbound": 10},
+  "ProtocolPeerDefault": {"StreamsInbound": 10},
+  "PeerClass": {
+    "indexers": {
+      "Peers": ["QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"],
+      "ServicePeerDefault": {"StreamsInbound": 20},
+      "ProtocolPeerDefault": {"StreamsInbound": 20}
+    }
+  },
+  "ServicePeerOverride": {
+    "QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC": {"A.svc": {"StreamsInbound": 100}}
+  },
+  "ProtocolPeerOverride": {
+    "QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC": {"/ipfs/bitswap/*": {"StreamsInbound": 100}}
+  }
+}`
+
+	limiter, err := NewLimiterFromJSONStrict(strings.NewReader(cfg), DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexer, err := peer.Decode("QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := peer.ID("A")
+
+	if l := limiter.GetProtocolPeerOverrideLimits("/ipfs/bitswap/1.2.0", indexer); l == nil || l.GetStreamLimit(network.DirInbound) != 100 {
+		t.Fatalf("expected protocol peer override, got %v", l)
+	}
+	if l := limiter.GetProtocolPeerOverrideLimits("/ipfs/bitswap/1.2.0", other); l != nil {
+		t.Fatalf("unexpected protocol peer override %v", l)
+	}
+
+	nmgr, err := NewResourceManager(limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	// overrides take precedence over the limits of the peer class
+	for _, c := range []struct {
+		p       peer.ID
+		svc     string
+		proto   protocol.ID
+		streams int
+	}{
+		{indexer, "A.svc", "/ipfs/bitswap/1.2.0", 100},
+		{indexer, "B.svc", "/ipfs/id/1.0.0", 20},
+		{other, "A.svc", "/ipfs/bitswap/1.2.0", 10},
+	} {
+		if err := mgr.ViewService(c.svc, func(s network.ServiceScope) error {
+			ps := s.(*serviceScope).getPeerScope(c.p)
+			defer ps.DecRef()
+			if n := ps.Limit().GetStreamLimit(network.DirInbound); n != c.streams {
+				t.Fatalf("expected %d streams for %s and %s, got %d", c.streams, c.svc, c.p, n)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := mgr.ViewProtocol(c.proto, func(s network.ProtocolScope) error {
+			ps := s.(*protocolScope).getPeerScope(c.p)
+			defer ps.DecRef()
+			if n := ps.Limit().GetStreamLimit(network.DirInbound); n != c.streams {
+				t.Fatalf("expected %d streams for %s and %s, got %d", c.streams, c.proto, c.p, n)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// overrides round trip through the limiter configuration
+	out, err := limiter.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter2, err := NewLimiter(out, DefaultLimitConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(limiter.ServicePeerOverrideLimits, limiter2.ServicePeerOverrideLimits) ||
+		!reflect.DeepEqual(limiter.ProtocolPeerOverrideLimits, limiter2.ProtocolPeerOverrideLimits) {
+		t.Fatal("peer overrides do not round trip")
+	}
+
+	diags := Validate(BasicLimiterConfig{
+		ServicePeerOverride: map[string]map[string]BasicLimitConfig{
+			"not a peer": {"A.svc": {}},
+		},
+	})
+	if !hasDiagnostic(diags, SeverityError, `$.ServicePeerOverride["not a peer"]`) {
+		t.Fatalf("missing invalid peer error in %v", diags)
+	}
+}
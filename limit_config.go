@@ -4,69 +4,252 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"net"
 
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
+)
 
-	"github.com/pbnjay/memory"
+// LimitVal is a count limit in a limit configuration. Besides positive values, it can be one of
+// the sentinel values DefaultLimit, Unlimited and BlockAllLimit, which are encoded in JSON as
+// the strings "default", "unlimited" and "blocked" respectively; the default limit is encoded as 0.
+type LimitVal int
+
+const (
+	// DefaultLimit is the default value of a limit, which falls back to the default limits.
+	DefaultLimit LimitVal = 0
+	// Unlimited is a limit that allows any use of the resource.
+	Unlimited LimitVal = -1
+	// BlockAllLimit is a limit that blocks any use of the resource.
+	BlockAllLimit LimitVal = -2
 )
 
+// Build returns the concrete value of the limit, using def for the default limit.
+func (v LimitVal) Build(def int) int {
+	switch {
+	case v == DefaultLimit:
+		return def
+	case v == Unlimited:
+		return math.MaxInt
+	case v == BlockAllLimit:
+		return 0
+	default:
+		return int(v)
+	}
+}
+
+func (v LimitVal) valid() bool {
+	return v >= BlockAllLimit
+}
+
+func (v LimitVal) MarshalJSON() ([]byte, error) {
+	return marshalLimitVal(int64(v))
+}
+
+func (v *LimitVal) UnmarshalJSON(b []byte) error {
+	val, err := unmarshalLimitVal(b)
+	if err != nil {
+		return err
+	}
+	if val < math.MinInt || val > math.MaxInt {
+		return fmt.Errorf("limit out of range: %d", val)
+	}
+	*v = LimitVal(val)
+	return nil
+}
+
+// limitVal returns the configuration value of a concrete count limit.
+func limitVal(n int) LimitVal {
+	switch n {
+	case math.MaxInt:
+		return Unlimited
+	case 0:
+		return BlockAllLimit
+	default:
+		return LimitVal(n)
+	}
+}
+
+// LimitVal64 is a memory limit in a limit configuration, with the same sentinel values as
+// LimitVal.
+type LimitVal64 int64
+
+const (
+	// DefaultLimit64 is the default value of a limit, which falls back to the default limits.
+	DefaultLimit64 LimitVal64 = 0
+	// Unlimited64 is a limit that allows any use of the resource.
+	Unlimited64 LimitVal64 = -1
+	// BlockAllLimit64 is a limit that blocks any use of the resource.
+	BlockAllLimit64 LimitVal64 = -2
+)
+
+// Build returns the concrete value of the limit, using def for the default limit.
+func (v LimitVal64) Build(def int64) int64 {
+	switch {
+	case v == DefaultLimit64:
+		return def
+	case v == Unlimited64:
+		return math.MaxInt64
+	case v == BlockAllLimit64:
+		return 0
+	default:
+		return int64(v)
+	}
+}
+
+func (v LimitVal64) valid() bool {
+	return v >= BlockAllLimit64
+}
+
+func (v LimitVal64) MarshalJSON() ([]byte, error) {
+	return marshalLimitVal(int64(v))
+}
+
+func (v *LimitVal64) UnmarshalJSON(b []byte) error {
+	val, err := unmarshalLimitVal(b)
+	if err != nil {
+		return err
+	}
+	*v = LimitVal64(val)
+	return nil
+}
+
+// limitVal64 returns the configuration value of a concrete memory limit.
+func limitVal64(n int64) LimitVal64 {
+	switch n {
+	case math.MaxInt64:
+		return Unlimited64
+	case 0:
+		return BlockAllLimit64
+	default:
+		return LimitVal64(n)
+	}
+}
+
+func marshalLimitVal(v int64) ([]byte, error) {
+	switch v {
+	case int64(Unlimited):
+		return json.Marshal("unlimited")
+	case int64(BlockAllLimit):
+		return json.Marshal("blocked")
+	default:
+		return json.Marshal(v)
+	}
+}
+
+func unmarshalLimitVal(b []byte) (int64, error) {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		switch s {
+		case "default":
+			return int64(DefaultLimit), nil
+		case "unlimited":
+			return int64(Unlimited), nil
+		case "blocked":
+			return int64(BlockAllLimit), nil
+		default:
+			return 0, fmt.Errorf("unknown limit value %q", s)
+		}
+	}
+
+	var v int64
+	if err := json.Unmarshal(b, &v); err != nil {
+		return 0, fmt.Errorf("invalid limit value %s", b)
+	}
+	return v, nil
+}
+
 type BasicLimitConfig struct {
 	// if true, then a dynamic limit is used
 	Dynamic bool `json:",omitempty"`
 	// either Memory is set for fixed memory limit
-	Memory int64 `json:",omitempty"`
+	Memory LimitVal64 `json:",omitempty"`
 	// or the following 3 fields for computed memory limits
 	MinMemory      int64   `json:",omitempty"`
 	MaxMemory      int64   `json:",omitempty"`
 	MemoryFraction float64 `json:",omitempty"`
 
-	StreamsInbound  int
-	StreamsOutbound int
-	Streams         int
+	StreamsInbound  LimitVal
+	StreamsOutbound LimitVal
+	Streams         LimitVal
+
+	ConnsInbound  LimitVal
+	ConnsOutbound LimitVal
+	Conns         LimitVal
 
-	ConnsInbound  int
-	ConnsOutbound int
-	Conns         int
+	FD LimitVal
 
-	FD int
+	// ConnRateInbound, ConnRateOutbound, StreamRateInbound and StreamRateOutbound are the rate
+	// limits of the scope; if unset, the default rate limits are used.
+	ConnRateInbound    *RateLimit `json:",omitempty"`
+	ConnRateOutbound   *RateLimit `json:",omitempty"`
+	StreamRateInbound  *RateLimit `json:",omitempty"`
+	StreamRateOutbound *RateLimit `json:",omitempty"`
+}
+
+// apply applies the count limits of the config to a base limit.
+func (cfg *BasicLimitConfig) apply(base BaseLimit) (BaseLimit, error) {
+	for _, v := range []LimitVal{
+		cfg.StreamsInbound, cfg.StreamsOutbound, cfg.Streams,
+		cfg.ConnsInbound, cfg.ConnsOutbound, cfg.Conns,
+		cfg.FD,
+	} {
+		if !v.valid() {
+			return base, fmt.Errorf("invalid limit value: %d", v)
+		}
+	}
+	if !cfg.Memory.valid() {
+		return base, fmt.Errorf("invalid memory limit: %d", cfg.Memory)
+	}
+
+	base.StreamsInbound = cfg.StreamsInbound.Build(base.StreamsInbound)
+	base.StreamsOutbound = cfg.StreamsOutbound.Build(base.StreamsOutbound)
+	base.Streams = cfg.Streams.Build(base.Streams)
+	base.ConnsInbound = cfg.ConnsInbound.Build(base.ConnsInbound)
+	base.ConnsOutbound = cfg.ConnsOutbound.Build(base.ConnsOutbound)
+	base.Conns = cfg.Conns.Build(base.Conns)
+	base.FD = cfg.FD.Build(base.FD)
+
+	for _, r := range []struct {
+		cfg *RateLimit
+		dst *RateLimit
+	}{
+		{cfg.ConnRateInbound, &base.ConnRateInbound},
+		{cfg.ConnRateOutbound, &base.ConnRateOutbound},
+		{cfg.StreamRateInbound, &base.StreamRateInbound},
+		{cfg.StreamRateOutbound, &base.StreamRateOutbound},
+	} {
+		if r.cfg == nil {
+			continue
+		}
+		if err := r.cfg.validate(); err != nil {
+			return base, fmt.Errorf("invalid rate limit: %w", err)
+		}
+		*r.dst = *r.cfg
+	}
+
+	return base, nil
 }
 
 func (cfg *BasicLimitConfig) toLimit(base BaseLimit, mem MemoryLimit) (Limit, error) {
 	if cfg == nil {
-		m := mem.GetMemory(int64(memory.TotalMemory()))
+		m := mem.GetMemory(DefaultMemorySource.TotalMemory())
 		return &StaticLimit{
 			Memory:    m,
 			BaseLimit: base,
 		}, nil
 	}
 
-	if cfg.Streams > 0 {
-		base.Streams = cfg.Streams
-	}
-	if cfg.StreamsInbound > 0 {
-		base.StreamsInbound = cfg.StreamsInbound
-	}
-	if cfg.StreamsOutbound > 0 {
-		base.StreamsOutbound = cfg.StreamsOutbound
-	}
-	if cfg.Conns > 0 {
-		base.Conns = cfg.Conns
-	}
-	if cfg.ConnsInbound > 0 {
-		base.ConnsInbound = cfg.ConnsInbound
-	}
-	if cfg.ConnsOutbound > 0 {
-		base.ConnsOutbound = cfg.ConnsOutbound
-	}
-	if cfg.FD > 0 {
-		base.FD = cfg.FD
+	base, err := cfg.apply(base)
+	if err != nil {
+		return nil, err
 	}
 
 	switch {
-	case cfg.Memory > 0:
+	case cfg.Memory != DefaultLimit64:
 		return &StaticLimit{
-			Memory:    cfg.Memory,
+			Memory:    cfg.Memory.Build(0),
 			BaseLimit: base,
 		}, nil
 
@@ -103,7 +286,7 @@ func (cfg *BasicLimitConfig) toLimit(base BaseLimit, mem MemoryLimit) (Limit, er
 			mem.MaxMemory = cfg.MaxMemory
 		}
 
-		m := mem.GetMemory(int64(memory.TotalMemory()))
+		m := mem.GetMemory(DefaultMemorySource.TotalMemory())
 		return &StaticLimit{
 			Memory:    m,
 			BaseLimit: base,
@@ -119,32 +302,15 @@ func (cfg *BasicLimitConfig) toLimitFixed(base BaseLimit, mem int64) (Limit, err
 		}, nil
 	}
 
-	if cfg.Streams > 0 {
-		base.Streams = cfg.Streams
-	}
-	if cfg.StreamsInbound > 0 {
-		base.StreamsInbound = cfg.StreamsInbound
-	}
-	if cfg.StreamsOutbound > 0 {
-		base.StreamsOutbound = cfg.StreamsOutbound
-	}
-	if cfg.Conns > 0 {
-		base.Conns = cfg.Conns
-	}
-	if cfg.ConnsInbound > 0 {
-		base.ConnsInbound = cfg.ConnsInbound
-	}
-	if cfg.ConnsOutbound > 0 {
-		base.ConnsOutbound = cfg.ConnsOutbound
-	}
-	if cfg.FD > 0 {
-		base.FD = cfg.FD
+	base, err := cfg.apply(base)
+	if err != nil {
+		return nil, err
 	}
 
 	switch {
-	case cfg.Memory > 0:
+	case cfg.Memory != DefaultLimit64:
 		return &StaticLimit{
-			Memory:    cfg.Memory,
+			Memory:    cfg.Memory.Build(0),
 			BaseLimit: base,
 		}, nil
 
@@ -166,6 +332,10 @@ type BasicLimiterConfig struct {
 	System    *BasicLimitConfig `json:",omitempty"`
 	Transient *BasicLimitConfig `json:",omitempty"`
 
+	AllowlistedSystem    *BasicLimitConfig `json:",omitempty"`
+	AllowlistedTransient *BasicLimitConfig `json:",omitempty"`
+	Allowlist            *AllowlistConfig  `json:",omitempty"`
+
 	ServiceDefault     *BasicLimitConfig           `json:",omitempty"`
 	ServicePeerDefault *BasicLimitConfig           `json:",omitempty"`
 	Service            map[string]BasicLimitConfig `json:",omitempty"`
@@ -175,12 +345,34 @@ type BasicLimiterConfig struct {
 	ProtocolPeerDefault *BasicLimitConfig           `json:",omitempty"`
 	Protocol            map[string]BasicLimitConfig `json:",omitempty"`
 	ProtocolPeer        map[string]BasicLimitConfig `json:",omitempty"`
+	// Protocol and ProtocolPeer keys can be protocol patterns, as in BasicLimiter; if
+	// SharedProtocolScopes is set, all protocols matching a pattern share a single scope.
+	SharedProtocolScopes bool `json:",omitempty"`
 
 	PeerDefault *BasicLimitConfig           `json:",omitempty"`
 	Peer        map[string]BasicLimitConfig `json:",omitempty"`
+	PeerClass   map[string]PeerClassConfig  `json:",omitempty"`
+
+	// ServicePeerOverride and ProtocolPeerOverride are keyed by peer ID, then by service or
+	// protocol (pattern).
+	ServicePeerOverride  map[string]map[string]BasicLimitConfig `json:",omitempty"`
+	ProtocolPeerOverride map[string]map[string]BasicLimitConfig `json:",omitempty"`
+
+	IPDefault     *BasicLimitConfig           `json:",omitempty"`
+	IP            map[string]BasicLimitConfig `json:",omitempty"`
+	SubnetDefault *BasicLimitConfig           `json:",omitempty"`
+	Subnet        map[string]BasicLimitConfig `json:",omitempty"`
+
+	IPv4SubnetPrefixLength int `json:",omitempty"`
+	IPv6SubnetPrefixLength int `json:",omitempty"`
 
 	Conn   *BasicLimitConfig `json:",omitempty"`
 	Stream *BasicLimitConfig `json:",omitempty"`
+
+	// ProtocolStream and ServiceStream are the limits of the streams of a protocol (pattern) or
+	// service; like Stream, they only support fixed memory limits.
+	ProtocolStream map[string]BasicLimitConfig `json:",omitempty"`
+	ServiceStream  map[string]BasicLimitConfig `json:",omitempty"`
 }
 
 // NewDefaultLimiterFromJSON creates a new limiter by parsing a json configuration,
@@ -190,14 +382,24 @@ func NewDefaultLimiterFromJSON(in io.Reader) (*BasicLimiter, error) {
 }
 
 // NewLimiterFromJSON creates a new limiter by parsing a json configuration.
+// The configuration is validated, and any diagnostics are logged as warnings; use
+// NewLimiterFromJSONStrict to reject configurations with diagnostics.
 func NewLimiterFromJSON(in io.Reader, defaults DefaultLimitConfig) (*BasicLimiter, error) {
 	jin := json.NewDecoder(in)
 
-	var cfg BasicLimiterConfig
+	var data json.RawMessage
+
+	if err := jin.Decode(&data); err != nil {
+		return nil, err
+	}
 
-	if err := jin.Decode(&cfg); err != nil {
+	cfg, diags, err := parseLimiterConfig(data)
+	if err != nil {
 		return nil, err
 	}
+	for _, d := range diags {
+		log.Warnf("limiter configuration %s", d)
+	}
 
 	return NewLimiter(cfg, defaults)
 }
@@ -216,6 +418,23 @@ func NewLimiter(cfg BasicLimiterConfig, defaults DefaultLimitConfig) (*BasicLimi
 		return nil, fmt.Errorf("invalid transient limit: %w", err)
 	}
 
+	limiter.AllowlistedSystemLimits, err = cfg.AllowlistedSystem.toLimit(defaults.AllowlistedSystemBaseLimit, defaults.AllowlistedSystemMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowlisted system limit: %w", err)
+	}
+
+	limiter.AllowlistedTransientLimits, err = cfg.AllowlistedTransient.toLimit(defaults.AllowlistedTransientBaseLimit, defaults.AllowlistedTransientMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowlisted transient limit: %w", err)
+	}
+
+	if cfg.Allowlist != nil {
+		limiter.Allowlist, err = cfg.Allowlist.toAllowlist()
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist: %w", err)
+		}
+	}
+
 	limiter.DefaultServiceLimits, err = cfg.ServiceDefault.toLimit(defaults.ServiceBaseLimit, defaults.ServiceMemory)
 	if err != nil {
 		return nil, fmt.Errorf("invalid default service limit: %w", err)
@@ -276,6 +495,8 @@ func NewLimiter(cfg BasicLimiterConfig, defaults DefaultLimitConfig) (*BasicLimi
 		}
 	}
 
+	limiter.SharedProtocolScopes = cfg.SharedProtocolScopes
+
 	limiter.DefaultPeerLimits, err = cfg.PeerDefault.toLimit(defaults.PeerBaseLimit, defaults.PeerMemory)
 	if err != nil {
 		return nil, fmt.Errorf("invalid peer limit: %w", err)
@@ -295,6 +516,101 @@ func NewLimiter(cfg BasicLimiterConfig, defaults DefaultLimitConfig) (*BasicLimi
 		}
 	}
 
+	limiter.PeerClasses, limiter.PeerClassAssignments, err = peerClassesFromConfig(cfg.PeerClass, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.ServicePeerOverride) > 0 {
+		limiter.ServicePeerOverrideLimits = make(map[peer.ID]map[string]Limit, len(cfg.ServicePeerOverride))
+		for p, cfgLimits := range cfg.ServicePeerOverride {
+			pid, err := peer.Decode(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid peer ID %s: %w", p, err)
+			}
+			limits := make(map[string]Limit, len(cfgLimits))
+			for svc, cfgLimit := range cfgLimits {
+				limits[svc], err = cfgLimit.toLimit(defaults.ServicePeerBaseLimit, defaults.ServicePeerMemory)
+				if err != nil {
+					return nil, fmt.Errorf("invalid service peer override limit for %s and %s: %w", svc, p, err)
+				}
+			}
+			limiter.ServicePeerOverrideLimits[pid] = limits
+		}
+	}
+
+	if len(cfg.ProtocolPeerOverride) > 0 {
+		limiter.ProtocolPeerOverrideLimits = make(map[peer.ID]map[protocol.ID]Limit, len(cfg.ProtocolPeerOverride))
+		for p, cfgLimits := range cfg.ProtocolPeerOverride {
+			pid, err := peer.Decode(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid peer ID %s: %w", p, err)
+			}
+			limits := make(map[protocol.ID]Limit, len(cfgLimits))
+			for proto, cfgLimit := range cfgLimits {
+				limits[protocol.ID(proto)], err = cfgLimit.toLimit(defaults.ProtocolPeerBaseLimit, defaults.ProtocolPeerMemory)
+				if err != nil {
+					return nil, fmt.Errorf("invalid protocol peer override limit for %s and %s: %w", proto, p, err)
+				}
+			}
+			limiter.ProtocolPeerOverrideLimits[pid] = limits
+		}
+	}
+
+	limiter.DefaultIPLimits, err = cfg.IPDefault.toLimit(defaults.IPBaseLimit, defaults.IPMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip limit: %w", err)
+	}
+
+	if len(cfg.IP) > 0 {
+		limiter.IPLimits = make(map[string]Limit, len(cfg.IP))
+		for s, cfgLimit := range cfg.IP {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %s", s)
+			}
+			limiter.IPLimits[ip.String()], err = cfgLimit.toLimit(defaults.IPBaseLimit, defaults.IPMemory)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ip limit for %s: %w", s, err)
+			}
+		}
+	}
+
+	limiter.DefaultSubnetLimits, err = cfg.SubnetDefault.toLimit(defaults.SubnetBaseLimit, defaults.SubnetMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet limit: %w", err)
+	}
+
+	if len(cfg.Subnet) > 0 {
+		limiter.SubnetLimits = make(map[string]Limit, len(cfg.Subnet))
+		for s, cfgLimit := range cfg.Subnet {
+			_, subnet, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid subnet %s: %w", s, err)
+			}
+			limiter.SubnetLimits[subnet.String()], err = cfgLimit.toLimit(defaults.SubnetBaseLimit, defaults.SubnetMemory)
+			if err != nil {
+				return nil, fmt.Errorf("invalid subnet limit for %s: %w", s, err)
+			}
+		}
+	}
+
+	limiter.IPv4SubnetPrefixLength = defaults.IPv4SubnetPrefixLength
+	if cfg.IPv4SubnetPrefixLength != 0 {
+		if cfg.IPv4SubnetPrefixLength < 0 || cfg.IPv4SubnetPrefixLength > 8*net.IPv4len {
+			return nil, fmt.Errorf("invalid IPv4 subnet prefix length: %d", cfg.IPv4SubnetPrefixLength)
+		}
+		limiter.IPv4SubnetPrefixLength = cfg.IPv4SubnetPrefixLength
+	}
+
+	limiter.IPv6SubnetPrefixLength = defaults.IPv6SubnetPrefixLength
+	if cfg.IPv6SubnetPrefixLength != 0 {
+		if cfg.IPv6SubnetPrefixLength < 0 || cfg.IPv6SubnetPrefixLength > 8*net.IPv6len {
+			return nil, fmt.Errorf("invalid IPv6 subnet prefix length: %d", cfg.IPv6SubnetPrefixLength)
+		}
+		limiter.IPv6SubnetPrefixLength = cfg.IPv6SubnetPrefixLength
+	}
+
 	limiter.ConnLimits, err = cfg.Conn.toLimitFixed(defaults.ConnBaseLimit, defaults.ConnMemory)
 	if err != nil {
 		return nil, fmt.Errorf("invalid conn limit: %w", err)
@@ -305,5 +621,25 @@ func NewLimiter(cfg BasicLimiterConfig, defaults DefaultLimitConfig) (*BasicLimi
 		return nil, fmt.Errorf("invalid stream limit: %w", err)
 	}
 
+	if len(cfg.ProtocolStream) > 0 {
+		limiter.ProtocolStreamLimits = make(map[protocol.ID]Limit, len(cfg.ProtocolStream))
+		for p, cfgLimit := range cfg.ProtocolStream {
+			limiter.ProtocolStreamLimits[protocol.ID(p)], err = cfgLimit.toLimitFixed(defaults.StreamBaseLimit, defaults.StreamMemory)
+			if err != nil {
+				return nil, fmt.Errorf("invalid protocol stream limit for %s: %w", p, err)
+			}
+		}
+	}
+
+	if len(cfg.ServiceStream) > 0 {
+		limiter.ServiceStreamLimits = make(map[string]Limit, len(cfg.ServiceStream))
+		for svc, cfgLimit := range cfg.ServiceStream {
+			limiter.ServiceStreamLimits[svc], err = cfgLimit.toLimitFixed(defaults.StreamBaseLimit, defaults.StreamMemory)
+			if err != nil {
+				return nil, fmt.Errorf("invalid service stream limit for %s: %w", svc, err)
+			}
+		}
+	}
+
 	return limiter, nil
 }
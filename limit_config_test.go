@@ -1,9 +1,14 @@
 package rcmgr
 
 import (
+	"encoding/json"
+	"math"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/libp2p/go-libp2p-core/network"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -122,3 +127,48 @@ func TestLimitConfigParser(t *testing.T) {
 		limiter.StreamLimits)
 
 }
+
+func TestLimitConfigSentinels(t *testing.T) {
+	const cfg = `{
+  "ProtocolDefault": {"StreamsInbound": "blocked", "StreamsOutbound": "unlimited", "Streams": "unlimited", "Memory": "unlimited"},
+  "PeerDefault": {"StreamsInbound": "default", "Memory": "blocked"}
+}`
+	limiter, err := NewLimiterFromJSON(strings.NewReader(cfg), DefaultLimits)
+	require.NoError(t, err)
+
+	proto := limiter.DefaultProtocolLimits
+	require.Equal(t, 0, proto.GetStreamLimit(network.DirInbound))
+	require.Equal(t, math.MaxInt, proto.GetStreamLimit(network.DirOutbound))
+	require.Equal(t, int64(math.MaxInt64), proto.GetMemoryLimit())
+
+	peer := limiter.DefaultPeerLimits
+	require.Equal(t, DefaultLimits.PeerBaseLimit.StreamsInbound, peer.GetStreamLimit(network.DirInbound))
+	require.Equal(t, int64(0), peer.GetMemoryLimit())
+
+	// the resources checks honor the sentinels
+	rc := resources{limit: proto}
+	require.Error(t, rc.addStreams(1, 0))
+	require.NoError(t, rc.addStreams(0, 1<<20))
+	require.NoError(t, rc.reserveMemory(1<<40, 255))
+	require.NoError(t, rc.reserveMemory(1<<40, 0))
+
+	rc = resources{limit: peer}
+	require.Error(t, rc.reserveMemory(1, 255))
+
+	// the sentinels survive serialization
+	data, err := json.Marshal(proto)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"StreamsInbound":"blocked"`)
+	require.Contains(t, string(data), `"Memory":"unlimited"`)
+
+	var parsed BasicLimitConfig
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	require.Equal(t, BlockAllLimit, parsed.StreamsInbound)
+	require.Equal(t, Unlimited, parsed.Streams)
+	require.Equal(t, Unlimited64, parsed.Memory)
+
+	_, err = NewLimiterFromJSON(strings.NewReader(`{"System": {"Streams": "lots"}}`), DefaultLimits)
+	require.Error(t, err)
+	_, err = NewLimiterFromJSON(strings.NewReader(`{"System": {"Streams": -3}}`), DefaultLimits)
+	require.Error(t, err)
+}
@@ -8,6 +8,12 @@ type DefaultLimitConfig struct {
 	TransientBaseLimit BaseLimit
 	TransientMemory    MemoryLimit
 
+	AllowlistedSystemBaseLimit BaseLimit
+	AllowlistedSystemMemory    MemoryLimit
+
+	AllowlistedTransientBaseLimit BaseLimit
+	AllowlistedTransientMemory    MemoryLimit
+
 	ServiceBaseLimit BaseLimit
 	ServiceMemory    MemoryLimit
 
@@ -23,6 +29,16 @@ type DefaultLimitConfig struct {
 	PeerBaseLimit BaseLimit
 	PeerMemory    MemoryLimit
 
+	IPBaseLimit BaseLimit
+	IPMemory    MemoryLimit
+
+	SubnetBaseLimit BaseLimit
+	SubnetMemory    MemoryLimit
+
+	// prefix lengths of the subnets accounted by the per-subnet scopes
+	IPv4SubnetPrefixLength int
+	IPv6SubnetPrefixLength int
+
 	ConnBaseLimit BaseLimit
 	ConnMemory    int64
 
@@ -37,11 +53,15 @@ func (cfg *DefaultLimitConfig) WithSystemMemory(memFraction float64, minMemory,
 	r.SystemMemory.MinMemory = minMemory
 	r.SystemMemory.MaxMemory = maxMemory
 	r.TransientMemory.MemoryFraction *= refactor
+	r.AllowlistedSystemMemory.MemoryFraction *= refactor
+	r.AllowlistedTransientMemory.MemoryFraction *= refactor
 	r.ServiceMemory.MemoryFraction *= refactor
 	r.ServicePeerMemory.MemoryFraction *= refactor
 	r.ProtocolMemory.MemoryFraction *= refactor
 	r.ProtocolPeerMemory.MemoryFraction *= refactor
 	r.PeerMemory.MemoryFraction *= refactor
+	r.IPMemory.MemoryFraction *= refactor
+	r.SubnetMemory.MemoryFraction *= refactor
 	return r
 }
 
@@ -79,6 +99,38 @@ var DefaultLimits = DefaultLimitConfig{
 		MaxMemory:      64 << 24,
 	},
 
+	AllowlistedSystemBaseLimit: BaseLimit{
+		StreamsInbound:  4096 << 4,
+		StreamsOutbound: 16384 << 4,
+		Streams:         16384 << 4,
+		ConnsInbound:    256 << 6,
+		ConnsOutbound:   1024 << 4,
+		Conns:           1024 << 4,
+		FD:              512 << 4,
+	},
+
+	AllowlistedSystemMemory: MemoryLimit{
+		MemoryFraction: 0.125,
+		MinMemory:      128 << 22,
+		MaxMemory:      1 << 34,
+	},
+
+	AllowlistedTransientBaseLimit: BaseLimit{
+		StreamsInbound:  128 << 4,
+		StreamsOutbound: 512 << 4,
+		Streams:         512 << 4,
+		ConnsInbound:    32 << 4,
+		ConnsOutbound:   128 << 4,
+		Conns:           128 << 4,
+		FD:              128 << 4,
+	},
+
+	AllowlistedTransientMemory: MemoryLimit{
+		MemoryFraction: 1,
+		MinMemory:      64 << 20,
+		MaxMemory:      64 << 24,
+	},
+
 	ServiceBaseLimit: BaseLimit{
 		StreamsInbound:  2048 << 4,
 		StreamsOutbound: 8192 << 4,
@@ -143,6 +195,35 @@ var DefaultLimits = DefaultLimitConfig{
 		MaxMemory:      128 << 24,
 	},
 
+	IPBaseLimit: BaseLimit{
+		ConnsInbound:  16 << 4,
+		ConnsOutbound: 32 << 4,
+		Conns:         32 << 4,
+		FD:            16 << 4,
+	},
+
+	IPMemory: MemoryLimit{
+		MemoryFraction: 0.125 / 16,
+		MinMemory:      64 << 20,
+		MaxMemory:      128 << 24,
+	},
+
+	SubnetBaseLimit: BaseLimit{
+		ConnsInbound:  64 << 4,
+		ConnsOutbound: 128 << 4,
+		Conns:         128 << 4,
+		FD:            64 << 4,
+	},
+
+	SubnetMemory: MemoryLimit{
+		MemoryFraction: 0.125 / 8,
+		MinMemory:      64 << 20,
+		MaxMemory:      256 << 24,
+	},
+
+	IPv4SubnetPrefixLength: 24,
+	IPv6SubnetPrefixLength: 64,
+
 	ConnBaseLimit: BaseLimit{
 		ConnsInbound:  1 << 4,
 		ConnsOutbound: 1 << 4,
@@ -2,8 +2,6 @@ package rcmgr
 
 import (
 	"runtime"
-
-	"github.com/pbnjay/memory"
 )
 
 // DynamicLimit is a limit with dynamic memory values, based on available (free) memory
@@ -15,7 +13,7 @@ type DynamicLimit struct {
 var _ Limit = (*DynamicLimit)(nil)
 
 func (l *DynamicLimit) GetMemoryLimit() int64 {
-	freemem := memory.FreeMemory()
+	freemem := DefaultMemorySource.FreeMemory()
 
 	// account for memory retained by the runtime that is actually free
 	// HeapInuse - HeapAlloc is the memory available in allocator spans
@@ -23,8 +21,8 @@ func (l *DynamicLimit) GetMemoryLimit() int64 {
 	var memstat runtime.MemStats
 	runtime.ReadMemStats(&memstat)
 
-	freemem += (memstat.HeapInuse - memstat.HeapAlloc) + (memstat.HeapIdle - memstat.HeapReleased)
-	return l.MemoryLimit.GetMemory(int64(freemem))
+	freemem += int64((memstat.HeapInuse - memstat.HeapAlloc) + (memstat.HeapIdle - memstat.HeapReleased))
+	return l.MemoryLimit.GetMemory(freemem)
 }
 
 func (l *DynamicLimit) WithMemoryLimit(memFraction float64, minMemory, maxMemory int64) Limit {
@@ -85,6 +83,14 @@ func NewDynamicLimiter(cfg DefaultLimitConfig) *BasicLimiter {
 		MemoryLimit: cfg.TransientMemory,
 		BaseLimit:   cfg.TransientBaseLimit,
 	}
+	allowlistedSystem := &DynamicLimit{
+		MemoryLimit: cfg.AllowlistedSystemMemory,
+		BaseLimit:   cfg.AllowlistedSystemBaseLimit,
+	}
+	allowlistedTransient := &DynamicLimit{
+		MemoryLimit: cfg.AllowlistedTransientMemory,
+		BaseLimit:   cfg.AllowlistedTransientBaseLimit,
+	}
 	svc := &DynamicLimit{
 		MemoryLimit: cfg.ServiceMemory,
 		BaseLimit:   cfg.ServiceBaseLimit,
@@ -105,6 +111,14 @@ func NewDynamicLimiter(cfg DefaultLimitConfig) *BasicLimiter {
 		MemoryLimit: cfg.PeerMemory,
 		BaseLimit:   cfg.PeerBaseLimit,
 	}
+	ip := &DynamicLimit{
+		MemoryLimit: cfg.IPMemory,
+		BaseLimit:   cfg.IPBaseLimit,
+	}
+	subnet := &DynamicLimit{
+		MemoryLimit: cfg.SubnetMemory,
+		BaseLimit:   cfg.SubnetBaseLimit,
+	}
 	conn := &StaticLimit{
 		Memory:    cfg.ConnMemory,
 		BaseLimit: cfg.ConnBaseLimit,
@@ -115,14 +129,20 @@ func NewDynamicLimiter(cfg DefaultLimitConfig) *BasicLimiter {
 	}
 
 	return &BasicLimiter{
-		SystemLimits:              system,
-		TransientLimits:           transient,
-		DefaultServiceLimits:      svc,
-		DefaultServicePeerLimits:  svcPeer,
-		DefaultProtocolLimits:     proto,
-		DefaultProtocolPeerLimits: protoPeer,
-		DefaultPeerLimits:         peer,
-		ConnLimits:                conn,
-		StreamLimits:              stream,
+		SystemLimits:               system,
+		TransientLimits:            transient,
+		DefaultServiceLimits:       svc,
+		DefaultServicePeerLimits:   svcPeer,
+		DefaultProtocolLimits:      proto,
+		DefaultProtocolPeerLimits:  protoPeer,
+		DefaultPeerLimits:          peer,
+		ConnLimits:                 conn,
+		StreamLimits:               stream,
+		AllowlistedSystemLimits:    allowlistedSystem,
+		AllowlistedTransientLimits: allowlistedTransient,
+		DefaultIPLimits:            ip,
+		DefaultSubnetLimits:        subnet,
+		IPv4SubnetPrefixLength:     cfg.IPv4SubnetPrefixLength,
+		IPv6SubnetPrefixLength:     cfg.IPv6SubnetPrefixLength,
 	}
 }
@@ -0,0 +1,245 @@
+package rcmgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+var _ json.Marshaler = (*StaticLimit)(nil)
+var _ json.Marshaler = (*DynamicLimit)(nil)
+var _ json.Marshaler = (*BasicLimiter)(nil)
+
+// ToConfig returns the configuration of the limit, with a fixed memory limit.
+func (l *StaticLimit) ToConfig() BasicLimitConfig {
+	cfg := baseLimitConfig(l.BaseLimit)
+	cfg.Memory = limitVal64(l.Memory)
+	return cfg
+}
+
+// MarshalJSON encodes the limit in the schema of BasicLimitConfig.
+func (l *StaticLimit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.ToConfig())
+}
+
+// ToConfig returns the configuration of the limit, with a dynamic memory limit.
+func (l *DynamicLimit) ToConfig() BasicLimitConfig {
+	cfg := baseLimitConfig(l.BaseLimit)
+	cfg.Dynamic = true
+	cfg.MemoryFraction = l.MemoryFraction
+	cfg.MinMemory = l.MinMemory
+	cfg.MaxMemory = l.MaxMemory
+	return cfg
+}
+
+// MarshalJSON encodes the limit in the schema of BasicLimitConfig.
+func (l *DynamicLimit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.ToConfig())
+}
+
+func baseLimitConfig(base BaseLimit) BasicLimitConfig {
+	rate := func(l RateLimit) *RateLimit {
+		if !l.enabled() {
+			return nil
+		}
+		return &l
+	}
+
+	return BasicLimitConfig{
+		StreamsInbound:  limitVal(base.StreamsInbound),
+		StreamsOutbound: limitVal(base.StreamsOutbound),
+		Streams:         limitVal(base.Streams),
+		ConnsInbound:    limitVal(base.ConnsInbound),
+		ConnsOutbound:   limitVal(base.ConnsOutbound),
+		Conns:           limitVal(base.Conns),
+		FD:              limitVal(base.FD),
+
+		ConnRateInbound:    rate(base.ConnRateInbound),
+		ConnRateOutbound:   rate(base.ConnRateOutbound),
+		StreamRateInbound:  rate(base.StreamRateInbound),
+		StreamRateOutbound: rate(base.StreamRateOutbound),
+	}
+}
+
+// limitConfig returns the configuration of a limit; only static and dynamic limits can be
+// represented in a limiter configuration.
+func limitConfig(l Limit) (*BasicLimitConfig, error) {
+	var cfg BasicLimitConfig
+	switch l := l.(type) {
+	case nil:
+		return nil, nil
+	case *StaticLimit:
+		cfg = l.ToConfig()
+	case *DynamicLimit:
+		cfg = l.ToConfig()
+	default:
+		return nil, fmt.Errorf("unsupported limit type %T", l)
+	}
+	return &cfg, nil
+}
+
+// ToConfig returns the configuration of the limiter, after all defaults and memory fractions
+// have been applied. Creating a limiter from the configuration with NewLimiter and an empty
+// DefaultLimitConfig results in a limiter with the same limits. Unset allowlisted limits are
+// represented by a copy of the respective system or transient limit, while unset IP and subnet
+// limits cannot be represented and are omitted. Only static and dynamic limits are supported.
+func (l *BasicLimiter) ToConfig() (BasicLimiterConfig, error) {
+	var cfg BasicLimiterConfig
+	var err error
+
+	set := func(name string, dst **BasicLimitConfig, limit Limit) {
+		if err != nil {
+			return
+		}
+		*dst, err = limitConfig(limit)
+		if err != nil {
+			err = fmt.Errorf("invalid %s limit: %w", name, err)
+		}
+	}
+	setMap := func(name string, dst *map[string]BasicLimitConfig, key string, limit Limit) {
+		if err != nil {
+			return
+		}
+		var lcfg *BasicLimitConfig
+		lcfg, err = limitConfig(limit)
+		if err != nil {
+			err = fmt.Errorf("invalid %s limit for %s: %w", name, key, err)
+			return
+		}
+		if lcfg == nil {
+			return
+		}
+		if *dst == nil {
+			*dst = make(map[string]BasicLimitConfig)
+		}
+		(*dst)[key] = *lcfg
+	}
+
+	set("system", &cfg.System, l.SystemLimits)
+	set("transient", &cfg.Transient, l.TransientLimits)
+	set("allowlisted system", &cfg.AllowlistedSystem, l.GetAllowlistedSystemLimits())
+	set("allowlisted transient", &cfg.AllowlistedTransient, l.GetAllowlistedTransientLimits())
+	if l.Allowlist != nil {
+		cfg.Allowlist = l.Allowlist.toConfig()
+	}
+
+	set("default service", &cfg.ServiceDefault, l.DefaultServiceLimits)
+	set("default service peer", &cfg.ServicePeerDefault, l.DefaultServicePeerLimits)
+	for svc, limit := range l.ServiceLimits {
+		setMap("service", &cfg.Service, svc, limit)
+	}
+	for svc, limit := range l.ServicePeerLimits {
+		setMap("service peer", &cfg.ServicePeer, svc, limit)
+	}
+
+	set("default protocol", &cfg.ProtocolDefault, l.DefaultProtocolLimits)
+	set("default protocol peer", &cfg.ProtocolPeerDefault, l.DefaultProtocolPeerLimits)
+	for proto, limit := range l.ProtocolLimits {
+		setMap("protocol", &cfg.Protocol, string(proto), limit)
+	}
+	for proto, limit := range l.ProtocolPeerLimits {
+		setMap("protocol peer", &cfg.ProtocolPeer, string(proto), limit)
+	}
+
+	cfg.SharedProtocolScopes = l.SharedProtocolScopes
+
+	set("peer", &cfg.PeerDefault, l.DefaultPeerLimits)
+	for p, limit := range l.PeerLimits {
+		setMap("peer", &cfg.Peer, p.String(), limit)
+	}
+	if err == nil {
+		cfg.PeerClass, err = peerClassesToConfig(l.PeerClasses, l.PeerClassAssignments)
+	}
+
+	for p, limits := range l.ServicePeerOverrideLimits {
+		var svcCfg map[string]BasicLimitConfig
+		for svc, limit := range limits {
+			setMap("service peer override", &svcCfg, svc, limit)
+		}
+		if svcCfg != nil {
+			if cfg.ServicePeerOverride == nil {
+				cfg.ServicePeerOverride = make(map[string]map[string]BasicLimitConfig)
+			}
+			cfg.ServicePeerOverride[p.String()] = svcCfg
+		}
+	}
+	for p, limits := range l.ProtocolPeerOverrideLimits {
+		var protoCfg map[string]BasicLimitConfig
+		for proto, limit := range limits {
+			setMap("protocol peer override", &protoCfg, string(proto), limit)
+		}
+		if protoCfg != nil {
+			if cfg.ProtocolPeerOverride == nil {
+				cfg.ProtocolPeerOverride = make(map[string]map[string]BasicLimitConfig)
+			}
+			cfg.ProtocolPeerOverride[p.String()] = protoCfg
+		}
+	}
+
+	set("ip", &cfg.IPDefault, l.DefaultIPLimits)
+	for ip, limit := range l.IPLimits {
+		setMap("ip", &cfg.IP, ip, limit)
+	}
+	set("subnet", &cfg.SubnetDefault, l.DefaultSubnetLimits)
+	for subnet, limit := range l.SubnetLimits {
+		setMap("subnet", &cfg.Subnet, subnet, limit)
+	}
+
+	cfg.IPv4SubnetPrefixLength = l.IPv4SubnetPrefixLength
+	cfg.IPv6SubnetPrefixLength = l.IPv6SubnetPrefixLength
+
+	set("conn", &cfg.Conn, l.ConnLimits)
+	set("stream", &cfg.Stream, l.StreamLimits)
+	for proto, limit := range l.ProtocolStreamLimits {
+		setMap("protocol stream", &cfg.ProtocolStream, string(proto), limit)
+	}
+	for svc, limit := range l.ServiceStreamLimits {
+		setMap("service stream", &cfg.ServiceStream, svc, limit)
+	}
+
+	return cfg, err
+}
+
+// MarshalJSON encodes the limiter as a JSON limiter configuration; see ToConfig.
+func (l *BasicLimiter) MarshalJSON() ([]byte, error) {
+	cfg, err := l.ToConfig()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(cfg)
+}
+
+// toConfig returns the configuration of the allowlist, or nil if it is empty.
+func (al *Allowlist) toConfig() *AllowlistConfig {
+	al.mx.RLock()
+	defer al.mx.RUnlock()
+
+	if len(al.peers) == 0 && len(al.nets) == 0 {
+		return nil
+	}
+
+	cfg := new(AllowlistConfig)
+	for p := range al.peers {
+		cfg.Peers = append(cfg.Peers, p.String())
+	}
+	sort.Strings(cfg.Peers)
+
+	for _, n := range al.nets {
+		if n.peer == "" {
+			cfg.CIDRs = append(cfg.CIDRs, n.net.String())
+			continue
+		}
+
+		// networks restricted to a peer are single addresses added as multiaddrs
+		addr, err := manet.FromIP(n.net.IP)
+		if err != nil {
+			log.Warnf("cannot represent allowlisted address %s for peer %s: %s", n.net, n.peer, err)
+			continue
+		}
+		cfg.Addrs = append(cfg.Addrs, fmt.Sprintf("%s/p2p/%s", addr, n.peer))
+	}
+
+	return cfg
+}
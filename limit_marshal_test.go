@@ -0,0 +1,84 @@
+package rcmgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestLimiterConfigRoundTrip(t *testing.T) {
+	p, err := peer.Decode("QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limiter := NewDefaultLimiter()
+	limiter.ServiceLimits = map[string]Limit{
+		"test": limiter.DefaultServiceLimits.WithStreamLimit(1, 2, 3),
+	}
+	limiter.ProtocolLimits = map[protocol.ID]Limit{
+		"/test": &DynamicLimit{
+			BaseLimit:   BaseLimit{Streams: 10},
+			MemoryLimit: MemoryLimit{MemoryFraction: 0.5, MinMemory: 1 << 20, MaxMemory: 1 << 30},
+		},
+	}
+	limiter.PeerLimits = map[peer.ID]Limit{
+		p: limiter.DefaultPeerLimits.WithFDLimit(1),
+	}
+	limiter.IPLimits = map[string]Limit{
+		"1.2.3.4": limiter.DefaultIPLimits.WithConnLimit(1, 1, 1),
+	}
+	limiter.Allowlist = NewAllowlist()
+	limiter.Allowlist.AddPeer(p)
+	if err := limiter.Allowlist.AddCIDR("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Allowlist.Add(multiaddr.StringCast("/ip4/1.2.3.4/p2p/" + p.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := NewLimiterFromJSON(bytes.NewReader(data), DefaultLimitConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(limiter.Allowlist.toConfig(), parsed.Allowlist.toConfig()) {
+		t.Fatalf("allowlist mismatch: %+v != %+v", limiter.Allowlist.toConfig(), parsed.Allowlist.toConfig())
+	}
+	limiter.Allowlist, parsed.Allowlist = nil, nil
+	if !reflect.DeepEqual(limiter, parsed) {
+		t.Fatalf("limiter mismatch:\n%+v\n%+v", limiter, parsed)
+	}
+
+	// a second round trip results in the same configuration
+	again, err := json.Marshal(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiterData, _ := json.Marshal(limiter)
+	if !bytes.Equal(limiterData, again) {
+		t.Fatalf("configuration mismatch:\n%s\n%s", limiterData, again)
+	}
+}
+
+func TestLimiterConfigUnsupportedLimit(t *testing.T) {
+	limiter := NewDefaultLimiter()
+	limiter.ConnLimits = &unsupportedLimit{StaticLimit: limiter.ConnLimits.(*StaticLimit)}
+	if _, err := limiter.ToConfig(); err == nil {
+		t.Fatal("expected error for unsupported limit type")
+	}
+}
+
+type unsupportedLimit struct {
+	*StaticLimit
+}
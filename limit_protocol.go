@@ -0,0 +1,80 @@
+package rcmgr
+
+import (
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// protocolFamilyLimiter is implemented by limiters that group protocols into families sharing a
+// single protocol scope.
+type protocolFamilyLimiter interface {
+	// GetProtocolFamily returns the key of the protocol scope used by a protocol.
+	GetProtocolFamily(proto protocol.ID) protocol.ID
+}
+
+var _ protocolFamilyLimiter = (*BasicLimiter)(nil)
+
+// GetProtocolFamily returns the pattern in ProtocolLimits matching the protocol if
+// SharedProtocolScopes is set and the protocol has no limits of its own; otherwise it returns the
+// protocol itself.
+func (l *BasicLimiter) GetProtocolFamily(proto protocol.ID) protocol.ID {
+	if !l.SharedProtocolScopes {
+		return proto
+	}
+	if _, ok := l.ProtocolLimits[proto]; ok {
+		return proto
+	}
+	if pattern, ok := matchProtocolPattern(l.ProtocolLimits, proto); ok {
+		return pattern
+	}
+	return proto
+}
+
+// isProtocolPattern reports whether a protocol limit key is a pattern, in which "*" matches any
+// sequence of characters; a prefix pattern is a pattern ending in "*", e.g. "/ipfs/bitswap/*".
+func isProtocolPattern(key protocol.ID) bool {
+	return strings.Contains(string(key), "*")
+}
+
+// matchProtocolPattern returns the pattern key in limits that matches the protocol with the
+// highest precedence: the pattern with the most literal characters, then the pattern with the
+// fewest wildcards, then the lexicographically smallest pattern.
+func matchProtocolPattern(limits map[protocol.ID]Limit, proto protocol.ID) (protocol.ID, bool) {
+	var best protocol.ID
+	found := false
+	for key := range limits {
+		if !isProtocolPattern(key) || !globMatch(string(key), string(proto)) {
+			continue
+		}
+		if !found || protocolPatternBefore(key, best) {
+			best = key
+			found = true
+		}
+	}
+	return best, found
+}
+
+func protocolPatternBefore(a, b protocol.ID) bool {
+	starsA, starsB := strings.Count(string(a), "*"), strings.Count(string(b), "*")
+	litA, litB := len(a)-starsA, len(b)-starsB
+	switch {
+	case litA != litB:
+		return litA > litB
+	case starsA != starsB:
+		return starsA < starsB
+	default:
+		return a < b
+	}
+}
+
+// protocolLimit looks up the limit of a protocol, falling back to the best matching pattern.
+func protocolLimit(limits map[protocol.ID]Limit, proto protocol.ID) (Limit, bool) {
+	if l, ok := limits[proto]; ok {
+		return l, true
+	}
+	if pattern, ok := matchProtocolPattern(limits, proto); ok {
+		return limits[pattern], true
+	}
+	return nil, false
+}
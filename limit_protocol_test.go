@@ -0,0 +1,182 @@
+package rcmgr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+func TestProtocolLimitPatterns(t *testing.T) {
+	limit := func(n int) Limit {
+		return &StaticLimit{BaseLimit: BaseLimit{Streams: n}}
+	}
+
+	limiter := NewDefaultLimiter()
+	limiter.ProtocolLimits = map[protocol.ID]Limit{
+		"/ipfs/bitswap/1.2.0": limit(1),
+		"/ipfs/bitswap/*":     limit(2),
+		"/ipfs/*":             limit(3),
+		"/ipfs/*/1.0.0":       limit(4),
+		"*/1.0.0":             limit(5),
+	}
+
+	for proto, streams := range map[protocol.ID]int{
+		"/ipfs/bitswap/1.2.0": 1, // exact match
+		"/ipfs/bitswap/1.1.0": 2, // longest pattern
+		"/ipfs/bitswap/1.0.0": 2, // "/ipfs/bitswap/*" has more literal characters than "/ipfs/*/1.0.0"
+		"/ipfs/kad/1.0.0":     4,
+		"/ipfs/id/2.0.0":      3,
+		"/meshsub/1.0.0":      5,
+		"/meshsub/1.1.0":      DefaultLimits.ProtocolBaseLimit.Streams,
+	} {
+		if got := limiter.GetProtocolLimits(proto).GetStreamTotalLimit(); got != streams {
+			t.Errorf("expected %d streams for %s, got %d", streams, proto, got)
+		}
+	}
+
+	if got := limiter.GetProtocolPeerLimits("/ipfs/bitswap/1.2.0"); got != limiter.DefaultProtocolPeerLimits {
+		t.Fatal("expected default protocol peer limits")
+	}
+}
+
+func TestSharedProtocolScopes(t *testing.T) {
+	const cfg = `{
+  "Protocol": {"/ipfs/bitswap/*": {"StreamsInbound": 2, "Streams": 2}, "/ipfs/bitswap/1.2.0": {"Streams": 1}},
+  "SharedProtocolScopes": true
+}`
+	limiter, err := NewLimiterFromJSON(strings.NewReader(cfg), DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mgr, err := NewResourceManager(limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	p := peer.ID("A")
+	var streams []network.StreamManagementScope
+	for _, proto := range []protocol.ID{"/ipfs/bitswap/1.0.0", "/ipfs/bitswap/1.1.0", "/ipfs/bitswap/1.2.0"} {
+		s, err := mgr.OpenStream(p, network.DirInbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetProtocol(proto); err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, s)
+	}
+
+	// the versions share the scope of the family, while the version with its own limits has its own
+	protos := mgr.(ResourceManagerState).ListProtocols()
+	if len(protos) != 2 {
+		t.Fatalf("expected 2 protocol scopes, got %v", protos)
+	}
+	if got := streams[0].ProtocolScope().Protocol(); got != "/ipfs/bitswap/*" {
+		t.Fatalf("unexpected protocol scope %s", got)
+	}
+	err = mgr.ViewProtocol("/ipfs/bitswap/1.0.0", func(s network.ProtocolScope) error {
+		if n := s.Stat().NumStreamsInbound; n != 2 {
+			t.Fatalf("expected 2 inbound streams in the family scope, got %d", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the family budget is exhausted
+	s, err := mgr.OpenStream(p, network.DirInbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetProtocol("/ipfs/bitswap/1.3.0"); err == nil {
+		t.Fatal("expected the family limit to block the stream")
+	}
+	s.Done()
+
+	for _, s := range streams {
+		s.Done()
+	}
+}
+
+func TestProtocolStreamLimits(t *testing.T) {
+	const cfg = `{
+  "Stream": {"Memory": 1024},
+  "ProtocolStream": {
+    "/bulk/*": {"Memory": 8192},
+    "/chat/1.0.0": {"Memory": 512}
+  },
+  "ServiceStream": {"bulk.archive": {"Memory": 16384}}
+}`
+
+	limiter, err := NewLimiterFromJSONStrict(strings.NewReader(cfg), DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter.SharedProtocolScopes = true
+	limiter.ProtocolLimits = map[protocol.ID]Limit{"/bulk/*": limiter.DefaultProtocolLimits}
+
+	mgr, err := NewResourceManager(limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	p := peer.ID("A")
+	streamMemory := func(s network.StreamManagementScope) int64 {
+		return s.(*streamScope).Limit().GetMemoryLimit()
+	}
+
+	for _, c := range []struct {
+		proto  protocol.ID
+		svc    string
+		before int64
+		after  int64
+	}{
+		{"/bulk/1.0.0", "bulk.archive", 8192, 16384},
+		{"/bulk/2.0.0", "bulk.other", 8192, 8192},
+		{"/chat/1.0.0", "chat", 512, 512},
+		{"/other/1.0.0", "other", 1024, 1024},
+	} {
+		stream, err := mgr.OpenStream(p, network.DirInbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mem := streamMemory(stream); mem != 1024 {
+			t.Fatalf("expected memory limit of 1024 before the protocol is known, got %d", mem)
+		}
+		if err := stream.SetProtocol(c.proto); err != nil {
+			t.Fatal(err)
+		}
+		if mem := streamMemory(stream); mem != c.before {
+			t.Fatalf("expected memory limit of %d for %s, got %d", c.before, c.proto, mem)
+		}
+		if err := stream.SetService(c.svc); err != nil {
+			t.Fatal(err)
+		}
+		if mem := streamMemory(stream); mem != c.after {
+			t.Fatalf("expected memory limit of %d for %s, got %d", c.after, c.svc, mem)
+		}
+		stream.Done()
+	}
+
+	out, err := limiter.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.ProtocolStream) != 2 || len(out.ServiceStream) != 1 {
+		t.Fatalf("unexpected stream limits in config: %v %v", out.ProtocolStream, out.ServiceStream)
+	}
+
+	diags := Validate(BasicLimiterConfig{
+		ServiceStream: map[string]BasicLimitConfig{"svc": {Dynamic: true, MemoryFraction: 0.5}},
+	})
+	if !hasDiagnostic(diags, SeverityError, `$.ServiceStream["svc"].Dynamic`) {
+		t.Fatalf("missing dynamic limit error in %v", diags)
+	}
+}
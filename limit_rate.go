@@ -0,0 +1,194 @@
+package rcmgr
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+// RateLimit is a token bucket limit on the rate at which connections or streams are opened in a
+// scope: Burst openings are allowed at once, and the bucket is refilled at Rate openings per
+// second. A zero Rate means that openings are not rate limited.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0
+}
+
+func (l RateLimit) validate() error {
+	switch {
+	case l.Rate < 0 || math.IsNaN(l.Rate) || math.IsInf(l.Rate, 0):
+		return fmt.Errorf("invalid rate: %v", l.Rate)
+	case l.Burst < 0:
+		return fmt.Errorf("invalid burst: %d", l.Burst)
+	case l.Rate > 0 && l.Burst == 0:
+		return fmt.Errorf("rate limit without burst")
+	}
+	return nil
+}
+
+// rateLimit is implemented by limits with rate limits; BaseLimit implements it for the static and
+// dynamic limits.
+type rateLimit interface {
+	// GetConnRateLimit returns the rate limit for opening inbound or outbound connections.
+	GetConnRateLimit(network.Direction) RateLimit
+	// GetStreamRateLimit returns the rate limit for opening inbound or outbound streams.
+	GetStreamRateLimit(network.Direction) RateLimit
+}
+
+var _ rateLimit = (*StaticLimit)(nil)
+var _ rateLimit = (*DynamicLimit)(nil)
+
+func (l *BaseLimit) GetConnRateLimit(dir network.Direction) RateLimit {
+	if dir == network.DirInbound {
+		return l.ConnRateInbound
+	}
+	return l.ConnRateOutbound
+}
+
+func (l *BaseLimit) GetStreamRateLimit(dir network.Direction) RateLimit {
+	if dir == network.DirInbound {
+		return l.StreamRateInbound
+	}
+	return l.StreamRateOutbound
+}
+
+// tokenBucket is the state of a rate limit in a scope.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the last refill; a new bucket starts full.
+func (b *tokenBucket) refill(l RateLimit, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = float64(l.Burst)
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(l.Burst), b.tokens+elapsed.Seconds()*l.Rate)
+	}
+	b.last = now
+}
+
+// rateLimitedResources are the resources accounting for rate limited openings, by direction.
+type rateLimitedResources struct {
+	in, out tokenBucket
+}
+
+// take takes tokens for incount inbound and outcount outbound openings, if both buckets have
+// enough; blocked openings do not consume tokens.
+func (rr *rateLimitedResources) take(limIn, limOut RateLimit, incount, outcount int, resIn, resOut Resource, now time.Time) error {
+	if incount > 0 && limIn.enabled() {
+		rr.in.refill(limIn, now)
+		if rr.in.tokens < float64(incount) {
+			return &ErrLimitExceeded{
+				Resource:  resIn,
+				Current:   int64(rr.in.tokens),
+				Requested: int64(incount),
+				Limit:     int64(limIn.Burst),
+			}
+		}
+	}
+	if outcount > 0 && limOut.enabled() {
+		rr.out.refill(limOut, now)
+		if rr.out.tokens < float64(outcount) {
+			return &ErrLimitExceeded{
+				Resource:  resOut,
+				Current:   int64(rr.out.tokens),
+				Requested: int64(outcount),
+				Limit:     int64(limOut.Burst),
+			}
+		}
+	}
+
+	if incount > 0 && limIn.enabled() {
+		rr.in.tokens -= float64(incount)
+	}
+	if outcount > 0 && limOut.enabled() {
+		rr.out.tokens -= float64(outcount)
+	}
+	return nil
+}
+
+// refund returns the tokens taken for incount inbound and outcount outbound openings that are
+// rolled back.
+func (rr *rateLimitedResources) refund(limIn, limOut RateLimit, incount, outcount int) {
+	if incount > 0 && limIn.enabled() {
+		rr.in.tokens = math.Min(float64(limIn.Burst), rr.in.tokens+float64(incount))
+	}
+	if outcount > 0 && limOut.enabled() {
+		rr.out.tokens = math.Min(float64(limOut.Burst), rr.out.tokens+float64(outcount))
+	}
+}
+
+// checkStreamRate takes tokens for opening streams in the scope, if its limit has stream rate
+// limits.
+func (rc *resources) checkStreamRate(incount, outcount int) error {
+	l, ok := rc.limit.(rateLimit)
+	if !ok {
+		return nil
+	}
+	return rc.streamRate.take(l.GetStreamRateLimit(network.DirInbound), l.GetStreamRateLimit(network.DirOutbound),
+		incount, outcount, ResourceStreamRateInbound, ResourceStreamRateOutbound, rc.now())
+}
+
+// checkConnRate takes tokens for opening connections in the scope, if its limit has connection
+// rate limits.
+func (rc *resources) checkConnRate(incount, outcount int) error {
+	l, ok := rc.limit.(rateLimit)
+	if !ok {
+		return nil
+	}
+	return rc.connRate.take(l.GetConnRateLimit(network.DirInbound), l.GetConnRateLimit(network.DirOutbound),
+		incount, outcount, ResourceConnRateInbound, ResourceConnRateOutbound, rc.now())
+}
+
+// refundStreamRate returns the tokens taken for opening streams in the scope, when the openings
+// are rolled back because they were blocked in another scope.
+func (rc *resources) refundStreamRate(incount, outcount int) {
+	l, ok := rc.limit.(rateLimit)
+	if !ok {
+		return
+	}
+	rc.streamRate.refund(l.GetStreamRateLimit(network.DirInbound), l.GetStreamRateLimit(network.DirOutbound), incount, outcount)
+}
+
+// refundConnRate returns the tokens taken for opening connections in the scope, when the openings
+// are rolled back because they were blocked in another scope.
+func (rc *resources) refundConnRate(incount, outcount int) {
+	l, ok := rc.limit.(rateLimit)
+	if !ok {
+		return
+	}
+	rc.connRate.refund(l.GetConnRateLimit(network.DirInbound), l.GetConnRateLimit(network.DirOutbound), incount, outcount)
+}
+
+// now returns the current time for the rate limits of the scope.
+func (rc *resources) now() time.Time {
+	if rc.clock == nil {
+		return time.Now()
+	}
+	return rc.clock()
+}
+
+// withClock is a resource manager option that sets the time source of rate limits, which is the
+// wall clock by default; the trace simulator uses the time of the replayed events.
+func withClock(clock func() time.Time) Option {
+	return func(r *resourceManager) error {
+		r.clock = clock
+		return nil
+	}
+}
+
+// isRateLimit reports whether a resource is a rate limited resource.
+func isRateLimit(res Resource) bool {
+	switch res {
+	case ResourceConnRateInbound, ResourceConnRateOutbound, ResourceStreamRateInbound, ResourceStreamRateOutbound:
+		return true
+	}
+	return false
+}
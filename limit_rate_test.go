@@ -0,0 +1,237 @@
+package rcmgr
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+type rateReporter struct {
+	shadowReporter
+
+	rmx        sync.Mutex
+	rateBlocks []*ErrLimitExceeded
+}
+
+var _ RateMetricsReporter = (*rateReporter)(nil)
+
+func (r *rateReporter) BlockRate(err *ErrLimitExceeded) {
+	r.rmx.Lock()
+	defer r.rmx.Unlock()
+	r.rateBlocks = append(r.rateBlocks, err)
+}
+
+func TestTokenBucket(t *testing.T) {
+	var rr rateLimitedResources
+	limit := RateLimit{Rate: 2, Burst: 3}
+	start := time.Now()
+
+	take := func(in int, at time.Duration) error {
+		return rr.take(limit, RateLimit{}, in, 0, ResourceStreamRateInbound, ResourceStreamRateOutbound, start.Add(at))
+	}
+
+	// the bucket starts full
+	if err := take(3, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := take(1, 0); err == nil {
+		t.Fatal("expected rate limit error")
+	}
+	// it refills at the rate, up to the burst
+	if err := take(1, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := take(1, 500*time.Millisecond); err == nil {
+		t.Fatal("expected rate limit error")
+	}
+	if err := take(3, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocked openings do not consume tokens, and unlimited directions are not accounted
+	err := rr.take(limit, RateLimit{}, 4, 100, ResourceStreamRateInbound, ResourceStreamRateOutbound, start.Add(20*time.Second))
+	var lerr *ErrLimitExceeded
+	if !errors.As(err, &lerr) || lerr.Resource != ResourceStreamRateInbound || lerr.Current != 3 || lerr.Limit != 3 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := rr.take(limit, RateLimit{}, 3, 100, ResourceStreamRateInbound, ResourceStreamRateOutbound, start.Add(20*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	const cfg = `{
+  "PeerDefault": {"StreamRateInbound": {"Rate": 0.001, "Burst": 2}},
+  "ProtocolDefault": {"StreamRateInbound": {"Rate": 0.001, "Burst": 1}},
+  "System": {"ConnRateInbound": {"Rate": 0.001, "Burst": 1}}
+}`
+	limiter, err := NewLimiterFromJSONStrict(strings.NewReader(cfg), DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	reporter := &rateReporter{}
+	mgr, err := NewResourceManager(limiter, WithMetrics(reporter), WithTraceSink(NewWriterTraceSink(&buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectRateLimit := func(err error, res Resource, kind ScopeKind) {
+		t.Helper()
+		var lerr *ErrLimitExceeded
+		if !errors.As(err, &lerr) || lerr.Resource != res || lerr.Kind != kind {
+			t.Fatalf("expected %s rate limit in %s scope, got %v", res, kind, err)
+		}
+		if !errors.Is(err, network.ErrResourceLimitExceeded) {
+			t.Fatalf("expected resource limit error, got %v", err)
+		}
+	}
+
+	// connections are rate limited at the system scope, even after they are closed
+	conn, err := mgr.OpenConnection(network.DirInbound, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Done()
+	_, err = mgr.OpenConnection(network.DirInbound, true)
+	expectRateLimit(err, ResourceConnRateInbound, ScopeKindSystem)
+	if _, err := mgr.OpenConnection(network.DirOutbound, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// streams are rate limited at the peer scope when they are opened
+	p := peer.ID("A")
+	for i := 0; i < 2; i++ {
+		stream, err := mgr.OpenStream(p, network.DirInbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Done()
+	}
+	_, err = mgr.OpenStream(p, network.DirInbound)
+	expectRateLimit(err, ResourceStreamRateInbound, ScopeKindPeer)
+
+	// and at the protocol scope when their protocol is set
+	for i, q := range []peer.ID{"B", "C"} {
+		stream, err := mgr.OpenStream(q, network.DirInbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = stream.SetProtocol("/test")
+		if i == 0 && err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			expectRateLimit(err, ResourceStreamRateInbound, ScopeKindProtocol)
+		}
+		stream.Done()
+	}
+
+	if err := mgr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reporter.rmx.Lock()
+	if len(reporter.rateBlocks) != 3 {
+		t.Fatalf("expected 3 rate limit blocks, got %v", reporter.rateBlocks)
+	}
+	reporter.rmx.Unlock()
+
+	var blocks []string
+	for _, evt := range readTestTrace(t, bytes.NewReader(buf.Bytes())) {
+		if (evt.Type == TraceBlockAddStreamEvt || evt.Type == TraceBlockAddConnEvt) && evt.Edge == "" {
+			blocks = append(blocks, evt.Scope+":"+string(evt.Resource))
+		}
+	}
+	expected := []string{
+		"system:conn-rate-inbound",
+		"peer:" + p.String() + ":stream-rate-inbound",
+		"protocol:/test:stream-rate-inbound",
+	}
+	if strings.Join(blocks, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected rate blocks %v in the trace, got %v", expected, blocks)
+	}
+
+	// the analyzer counts the blocks by rate limited resource
+	r, err := NewTraceReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	analysis, err := AnalyzeTrace(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocked := analysis.Scopes["system"].Blocked; len(blocked) != 1 || blocked[ResourceConnRateInbound] != 1 {
+		t.Fatalf("unexpected system blocks %v", blocked)
+	}
+
+	// rate limits round trip through the limiter configuration and are validated
+	out, err := limiter.ToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := out.PeerDefault.StreamRateInbound; r == nil || *r != (RateLimit{Rate: 0.001, Burst: 2}) {
+		t.Fatalf("unexpected peer stream rate limit %v", r)
+	}
+	diags := Validate(BasicLimiterConfig{
+		System: &BasicLimitConfig{ConnRateOutbound: &RateLimit{Rate: 1}},
+	})
+	if !hasDiagnostic(diags, SeverityError, "$.System.ConnRateOutbound") {
+		t.Fatalf("missing rate limit error in %v", diags)
+	}
+}
+
+func TestRateLimitRefunds(t *testing.T) {
+	const cfg = `{
+  "PeerDefault": {"StreamRateInbound": {"Rate": 0.001, "Burst": 1}},
+  "System": {"StreamsInbound": 1}
+}`
+	limiter, err := NewLimiterFromJSONStrict(strings.NewReader(cfg), DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nmgr, err := NewResourceManager(limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	// saturate the system scope
+	stream, err := mgr.OpenStream(peer.ID("A"), network.DirInbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// streams blocked in the system scope do not consume the tokens of the peer scope
+	p := peer.ID("B")
+	for i := 0; i < 2; i++ {
+		var lerr *ErrLimitExceeded
+		if _, err := mgr.OpenStream(p, network.DirInbound); !errors.As(err, &lerr) || lerr.Kind != ScopeKindSystem {
+			t.Fatalf("expected stream to be blocked in the system scope, got %v", err)
+		}
+	}
+	mgr.mx.Lock()
+	ps := mgr.peer[p]
+	mgr.mx.Unlock()
+	ps.Lock()
+	tokens := ps.rc.streamRate.in.tokens
+	ps.Unlock()
+	if tokens != 1 {
+		t.Fatalf("expected the peer bucket to be full, got %v tokens", tokens)
+	}
+
+	stream.Done()
+	stream, err = mgr.OpenStream(p, network.DirInbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Done()
+}
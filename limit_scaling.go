@@ -0,0 +1,337 @@
+package rcmgr
+
+// BaseLimitIncrease is the increase of a limit for every GiB of memory the limits are scaled to.
+type BaseLimitIncrease struct {
+	Streams         int
+	StreamsInbound  int
+	StreamsOutbound int
+	Conns           int
+	ConnsInbound    int
+	ConnsOutbound   int
+	// Memory is the increase of the memory limit, in bytes per GiB of memory.
+	Memory int64
+	// FDFraction is the fraction of the file descriptor limit of the process allotted to the
+	// scope; the resulting limit is never lower than the base FD limit.
+	FDFraction float64
+}
+
+// ScalingLimit is a limit that scales with the memory and the file descriptors available.
+type ScalingLimit struct {
+	// BaseLimit and Memory are the limits with no memory to scale to.
+	BaseLimit
+	Memory int64
+	// Increase is the increase of the limits per GiB of memory.
+	Increase BaseLimitIncrease
+}
+
+// Scale computes the static limit for the given memory, in bytes, and file descriptor limit.
+// Memory is scaled in MiB increments; if numFD is not positive, the base FD limit is used.
+func (l *ScalingLimit) Scale(memory int64, numFD int) *StaticLimit {
+	var mib int64
+	if memory > 0 {
+		mib = memory >> 20
+	}
+
+	scale := func(base, inc int) int {
+		return base + int((int64(inc)*mib)>>10)
+	}
+
+	r := &StaticLimit{
+		Memory: l.Memory + (l.Increase.Memory*mib)>>10,
+		BaseLimit: BaseLimit{
+			Streams:         scale(l.Streams, l.Increase.Streams),
+			StreamsInbound:  scale(l.StreamsInbound, l.Increase.StreamsInbound),
+			StreamsOutbound: scale(l.StreamsOutbound, l.Increase.StreamsOutbound),
+			Conns:           scale(l.Conns, l.Increase.Conns),
+			ConnsInbound:    scale(l.ConnsInbound, l.Increase.ConnsInbound),
+			ConnsOutbound:   scale(l.ConnsOutbound, l.Increase.ConnsOutbound),
+			FD:              l.FD,
+		},
+	}
+
+	if l.Increase.FDFraction > 0 && numFD > 0 {
+		fd := int(l.Increase.FDFraction * float64(numFD))
+		if fd > r.FD {
+			r.FD = fd
+		}
+	}
+
+	return r
+}
+
+// ScalingLimitConfig is a struct for configuring limits that scale with the machine.
+type ScalingLimitConfig struct {
+	System               ScalingLimit
+	Transient            ScalingLimit
+	AllowlistedSystem    ScalingLimit
+	AllowlistedTransient ScalingLimit
+	Service              ScalingLimit
+	ServicePeer          ScalingLimit
+	Protocol             ScalingLimit
+	ProtocolPeer         ScalingLimit
+	Peer                 ScalingLimit
+	IP                   ScalingLimit
+	Subnet               ScalingLimit
+
+	// prefix lengths of the subnets accounted by the per-subnet scopes
+	IPv4SubnetPrefixLength int
+	IPv6SubnetPrefixLength int
+
+	Conn   ScalingLimit
+	Stream ScalingLimit
+}
+
+// AutoScale computes the limits for a machine with the given memory, in bytes, and file
+// descriptor limit, and creates a static limiter with them. If numFD is not positive, the base
+// FD limits are used.
+func (cfg *ScalingLimitConfig) AutoScale(memory int64, numFD int) *BasicLimiter {
+	return &BasicLimiter{
+		SystemLimits:               cfg.System.Scale(memory, numFD),
+		TransientLimits:            cfg.Transient.Scale(memory, numFD),
+		DefaultServiceLimits:       cfg.Service.Scale(memory, numFD),
+		DefaultServicePeerLimits:   cfg.ServicePeer.Scale(memory, numFD),
+		DefaultProtocolLimits:      cfg.Protocol.Scale(memory, numFD),
+		DefaultProtocolPeerLimits:  cfg.ProtocolPeer.Scale(memory, numFD),
+		DefaultPeerLimits:          cfg.Peer.Scale(memory, numFD),
+		ConnLimits:                 cfg.Conn.Scale(memory, numFD),
+		StreamLimits:               cfg.Stream.Scale(memory, numFD),
+		AllowlistedSystemLimits:    cfg.AllowlistedSystem.Scale(memory, numFD),
+		AllowlistedTransientLimits: cfg.AllowlistedTransient.Scale(memory, numFD),
+		DefaultIPLimits:            cfg.IP.Scale(memory, numFD),
+		DefaultSubnetLimits:        cfg.Subnet.Scale(memory, numFD),
+		IPv4SubnetPrefixLength:     cfg.IPv4SubnetPrefixLength,
+		IPv6SubnetPrefixLength:     cfg.IPv6SubnetPrefixLength,
+	}
+}
+
+// DefaultScalingLimits are the default scaling limits; the system scope is allotted 1/8 of the
+// memory it is scaled to.
+var DefaultScalingLimits = ScalingLimitConfig{
+	System: ScalingLimit{
+		BaseLimit: BaseLimit{
+			StreamsInbound:  4096,
+			StreamsOutbound: 16384,
+			Streams:         16384,
+			ConnsInbound:    64,
+			ConnsOutbound:   128,
+			Conns:           128,
+			FD:              256,
+		},
+		Memory: 128 << 20,
+		Increase: BaseLimitIncrease{
+			StreamsInbound:  2048,
+			StreamsOutbound: 8192,
+			Streams:         8192,
+			ConnsInbound:    64,
+			ConnsOutbound:   128,
+			Conns:           128,
+			Memory:          128 << 20,
+			FDFraction:      0.9,
+		},
+	},
+
+	Transient: ScalingLimit{
+		BaseLimit: BaseLimit{
+			StreamsInbound:  128,
+			StreamsOutbound: 512,
+			Streams:         512,
+			ConnsInbound:    16,
+			ConnsOutbound:   32,
+			Conns:           32,
+			FD:              64,
+		},
+		Memory: 32 << 20,
+		Increase: BaseLimitIncrease{
+			StreamsInbound:  64,
+			StreamsOutbound: 256,
+			Streams:         256,
+			ConnsInbound:    8,
+			ConnsOutbound:   16,
+			Conns:           16,
+			Memory:          16 << 20,
+			FDFraction:      0.25,
+		},
+	},
+
+	AllowlistedSystem: ScalingLimit{
+		BaseLimit: BaseLimit{
+			StreamsInbound:  4096,
+			StreamsOutbound: 16384,
+			Streams:         16384,
+			ConnsInbound:    64,
+			ConnsOutbound:   128,
+			Conns:           128,
+			FD:              256,
+		},
+		Memory: 128 << 20,
+		Increase: BaseLimitIncrease{
+			StreamsInbound:  2048,
+			StreamsOutbound: 8192,
+			Streams:         8192,
+			ConnsInbound:    64,
+			ConnsOutbound:   128,
+			Conns:           128,
+			Memory:          128 << 20,
+			FDFraction:      0.9,
+		},
+	},
+
+	AllowlistedTransient: ScalingLimit{
+		BaseLimit: BaseLimit{
+			StreamsInbound:  128,
+			StreamsOutbound: 512,
+			Streams:         512,
+			ConnsInbound:    16,
+			ConnsOutbound:   32,
+			Conns:           32,
+			FD:              64,
+		},
+		Memory: 32 << 20,
+		Increase: BaseLimitIncrease{
+			StreamsInbound:  64,
+			StreamsOutbound: 256,
+			Streams:         256,
+			ConnsInbound:    8,
+			ConnsOutbound:   16,
+			Conns:           16,
+			Memory:          16 << 20,
+			FDFraction:      0.25,
+		},
+	},
+
+	Service: ScalingLimit{
+		BaseLimit: BaseLimit{
+			StreamsInbound:  1024,
+			StreamsOutbound: 4096,
+			Streams:         4096,
+		},
+		Memory: 32 << 20,
+		Increase: BaseLimitIncrease{
+			StreamsInbound:  512,
+			StreamsOutbound: 2048,
+			Streams:         2048,
+			Memory:          16 << 20,
+		},
+	},
+
+	ServicePeer: ScalingLimit{
+		BaseLimit: BaseLimit{
+			StreamsInbound:  128,
+			StreamsOutbound: 256,
+			Streams:         256,
+		},
+		Memory: 8 << 20,
+		Increase: BaseLimitIncrease{
+			StreamsInbound:  16,
+			StreamsOutbound: 32,
+			Streams:         32,
+			Memory:          2 << 20,
+		},
+	},
+
+	Protocol: ScalingLimit{
+		BaseLimit: BaseLimit{
+			StreamsInbound:  512,
+			StreamsOutbound: 2048,
+			Streams:         2048,
+		},
+		Memory: 32 << 20,
+		Increase: BaseLimitIncrease{
+			StreamsInbound:  256,
+			StreamsOutbound: 1024,
+			Streams:         1024,
+			Memory:          8 << 20,
+		},
+	},
+
+	ProtocolPeer: ScalingLimit{
+		BaseLimit: BaseLimit{
+			StreamsInbound:  64,
+			StreamsOutbound: 128,
+			Streams:         256,
+		},
+		Memory: 8 << 20,
+		Increase: BaseLimitIncrease{
+			StreamsInbound:  8,
+			StreamsOutbound: 16,
+			Streams:         32,
+			Memory:          2 << 20,
+		},
+	},
+
+	Peer: ScalingLimit{
+		BaseLimit: BaseLimit{
+			StreamsInbound:  256,
+			StreamsOutbound: 512,
+			Streams:         512,
+			ConnsInbound:    8,
+			ConnsOutbound:   16,
+			Conns:           16,
+			FD:              8,
+		},
+		Memory: 32 << 20,
+		Increase: BaseLimitIncrease{
+			StreamsInbound:  32,
+			StreamsOutbound: 64,
+			Streams:         64,
+			Memory:          8 << 20,
+			FDFraction:      1.0 / 64,
+		},
+	},
+
+	IP: ScalingLimit{
+		BaseLimit: BaseLimit{
+			ConnsInbound:  8,
+			ConnsOutbound: 16,
+			Conns:         16,
+			FD:            8,
+		},
+		Memory: 32 << 20,
+		Increase: BaseLimitIncrease{
+			ConnsInbound:  1,
+			ConnsOutbound: 2,
+			Conns:         2,
+			Memory:        4 << 20,
+			FDFraction:    1.0 / 64,
+		},
+	},
+
+	Subnet: ScalingLimit{
+		BaseLimit: BaseLimit{
+			ConnsInbound:  32,
+			ConnsOutbound: 64,
+			Conns:         64,
+			FD:            32,
+		},
+		Memory: 64 << 20,
+		Increase: BaseLimitIncrease{
+			ConnsInbound:  4,
+			ConnsOutbound: 8,
+			Conns:         8,
+			Memory:        8 << 20,
+			FDFraction:    1.0 / 16,
+		},
+	},
+
+	IPv4SubnetPrefixLength: 24,
+	IPv6SubnetPrefixLength: 64,
+
+	Conn: ScalingLimit{
+		BaseLimit: BaseLimit{
+			ConnsInbound:  1,
+			ConnsOutbound: 1,
+			Conns:         1,
+			FD:            1,
+		},
+		Memory: 32 << 20,
+	},
+
+	Stream: ScalingLimit{
+		BaseLimit: BaseLimit{
+			StreamsInbound:  1,
+			StreamsOutbound: 1,
+			Streams:         1,
+		},
+		Memory: 16 << 20,
+	},
+}
@@ -0,0 +1,83 @@
+package rcmgr
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+func TestScalingLimit(t *testing.T) {
+	l := ScalingLimit{
+		BaseLimit: BaseLimit{StreamsInbound: 10, Conns: 4, FD: 16},
+		Memory:    1 << 20,
+		Increase: BaseLimitIncrease{
+			StreamsInbound: 5,
+			Conns:          2,
+			Memory:         1 << 20,
+			FDFraction:     0.5,
+		},
+	}
+
+	base := l.Scale(0, 0)
+	if base.StreamsInbound != 10 || base.Conns != 4 || base.FD != 16 || base.Memory != 1<<20 {
+		t.Fatalf("unexpected base limit: %+v", base)
+	}
+
+	// 2.5GiB scale the increments proportionally
+	scaled := l.Scale(5<<29, 1000)
+	if scaled.StreamsInbound != 22 {
+		t.Fatalf("expected 22 inbound streams, got %d", scaled.StreamsInbound)
+	}
+	if scaled.Conns != 9 {
+		t.Fatalf("expected 9 conns, got %d", scaled.Conns)
+	}
+	if scaled.Memory != 1<<20+5<<19 {
+		t.Fatalf("unexpected memory limit: %d", scaled.Memory)
+	}
+	if scaled.FD != 500 {
+		t.Fatalf("expected 500 FDs, got %d", scaled.FD)
+	}
+
+	// the FD fraction never lowers the base FD limit
+	if fd := l.Scale(1<<30, 10).FD; fd != 16 {
+		t.Fatalf("expected 16 FDs, got %d", fd)
+	}
+}
+
+func TestAutoScale(t *testing.T) {
+	small := DefaultScalingLimits.AutoScale(1<<30, 1024)
+	large := DefaultScalingLimits.AutoScale(128<<30, 65536)
+
+	if small.IPv4SubnetPrefixLength != 24 || small.IPv6SubnetPrefixLength != 64 {
+		t.Fatalf("unexpected subnet prefix lengths: %d %d", small.IPv4SubnetPrefixLength, small.IPv6SubnetPrefixLength)
+	}
+
+	for _, l := range []struct {
+		name         string
+		small, large Limit
+	}{
+		{"system", small.SystemLimits, large.SystemLimits},
+		{"transient", small.TransientLimits, large.TransientLimits},
+		{"peer", small.DefaultPeerLimits, large.DefaultPeerLimits},
+	} {
+		if l.small.GetMemoryLimit() >= l.large.GetMemoryLimit() {
+			t.Fatalf("%s memory limit does not scale: %d >= %d", l.name, l.small.GetMemoryLimit(), l.large.GetMemoryLimit())
+		}
+		if l.small.GetStreamLimit(network.DirInbound) >= l.large.GetStreamLimit(network.DirInbound) {
+			t.Fatalf("%s stream limit does not scale", l.name)
+		}
+		if l.small.GetFDLimit() >= l.large.GetFDLimit() {
+			t.Fatalf("%s FD limit does not scale", l.name)
+		}
+	}
+
+	if fd := large.SystemLimits.GetFDLimit(); fd != 58982 {
+		t.Fatalf("expected the system scope to get 90%% of FDs, got %d", fd)
+	}
+	if conns := large.SystemLimits.GetConnTotalLimit(); conns != 128+128*128 {
+		t.Fatalf("unexpected system conn limit: %d", conns)
+	}
+	if mem := large.SystemLimits.GetMemoryLimit(); mem != 128<<20+16<<30 {
+		t.Fatalf("unexpected system memory limit: %d", mem)
+	}
+}
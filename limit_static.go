@@ -1,7 +1,7 @@
 package rcmgr
 
 import (
-	"github.com/pbnjay/memory"
+	"math"
 )
 
 // StaticLimit is a limit with static values.
@@ -20,7 +20,10 @@ func (l *StaticLimit) WithMemoryLimit(memFraction float64, minMemory, maxMemory
 	r := new(StaticLimit)
 	*r = *l
 
-	r.Memory = int64(memFraction * float64(r.Memory))
+	// an unlimited memory limit stays unlimited, bounded by maxMemory
+	if r.Memory != math.MaxInt64 {
+		r.Memory = int64(memFraction * float64(r.Memory))
+	}
 	if r.Memory < minMemory {
 		r.Memory = minMemory
 	} else if r.Memory > maxMemory {
@@ -83,7 +86,7 @@ func NewDefaultLimiter() *BasicLimiter {
 // limit config.
 func NewStaticLimiter(cfg DefaultLimitConfig) *BasicLimiter {
 	memoryCap := memoryLimit(
-		int64(memory.TotalMemory()),
+		DefaultMemorySource.TotalMemory(),
 		cfg.SystemMemory.MemoryFraction,
 		cfg.SystemMemory.MinMemory,
 		cfg.SystemMemory.MaxMemory)
@@ -95,6 +98,19 @@ func NewStaticLimiter(cfg DefaultLimitConfig) *BasicLimiter {
 		Memory:    cfg.TransientMemory.GetMemory(memoryCap),
 		BaseLimit: cfg.TransientBaseLimit,
 	}
+	allowlistedMemoryCap := memoryLimit(
+		DefaultMemorySource.TotalMemory(),
+		cfg.AllowlistedSystemMemory.MemoryFraction,
+		cfg.AllowlistedSystemMemory.MinMemory,
+		cfg.AllowlistedSystemMemory.MaxMemory)
+	allowlistedSystem := &StaticLimit{
+		Memory:    allowlistedMemoryCap,
+		BaseLimit: cfg.AllowlistedSystemBaseLimit,
+	}
+	allowlistedTransient := &StaticLimit{
+		Memory:    cfg.AllowlistedTransientMemory.GetMemory(allowlistedMemoryCap),
+		BaseLimit: cfg.AllowlistedTransientBaseLimit,
+	}
 	svc := &StaticLimit{
 		Memory:    cfg.ServiceMemory.GetMemory(memoryCap),
 		BaseLimit: cfg.ServiceBaseLimit,
@@ -115,6 +131,14 @@ func NewStaticLimiter(cfg DefaultLimitConfig) *BasicLimiter {
 		Memory:    cfg.PeerMemory.GetMemory(memoryCap),
 		BaseLimit: cfg.PeerBaseLimit,
 	}
+	ip := &StaticLimit{
+		Memory:    cfg.IPMemory.GetMemory(memoryCap),
+		BaseLimit: cfg.IPBaseLimit,
+	}
+	subnet := &StaticLimit{
+		Memory:    cfg.SubnetMemory.GetMemory(memoryCap),
+		BaseLimit: cfg.SubnetBaseLimit,
+	}
 	conn := &StaticLimit{
 		Memory:    cfg.ConnMemory,
 		BaseLimit: cfg.ConnBaseLimit,
@@ -125,14 +149,20 @@ func NewStaticLimiter(cfg DefaultLimitConfig) *BasicLimiter {
 	}
 
 	return &BasicLimiter{
-		SystemLimits:              system,
-		TransientLimits:           transient,
-		DefaultServiceLimits:      svc,
-		DefaultServicePeerLimits:  svcPeer,
-		DefaultProtocolLimits:     proto,
-		DefaultProtocolPeerLimits: protoPeer,
-		DefaultPeerLimits:         peer,
-		ConnLimits:                conn,
-		StreamLimits:              stream,
+		SystemLimits:               system,
+		TransientLimits:            transient,
+		DefaultServiceLimits:       svc,
+		DefaultServicePeerLimits:   svcPeer,
+		DefaultProtocolLimits:      proto,
+		DefaultProtocolPeerLimits:  protoPeer,
+		DefaultPeerLimits:          peer,
+		ConnLimits:                 conn,
+		StreamLimits:               stream,
+		AllowlistedSystemLimits:    allowlistedSystem,
+		AllowlistedTransientLimits: allowlistedTransient,
+		DefaultIPLimits:            ip,
+		DefaultSubnetLimits:        subnet,
+		IPv4SubnetPrefixLength:     cfg.IPv4SubnetPrefixLength,
+		IPv6SubnetPrefixLength:     cfg.IPv6SubnetPrefixLength,
 	}
 }
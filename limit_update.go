@@ -0,0 +1,232 @@
+package rcmgr
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// LimitUpdate is the outcome of updating the limiter of a running resource manager.
+type LimitUpdate struct {
+	// Updated lists the scopes whose limit changed.
+	Updated []string
+	// OverLimit lists the scopes whose current usage exceeds their new limit.
+	OverLimit []string
+}
+
+type limitWatch struct {
+	path     string
+	defaults DefaultLimitConfig
+	interval time.Duration
+
+	modTime time.Time
+	size    int64
+}
+
+// WithLimitConfigWatch is a resource manager option that watches a JSON limiter configuration
+// file and applies it to the resource manager whenever it changes. The file is polled at the
+// specified interval; limits missing from the configuration fall back to defaults.
+func WithLimitConfigWatch(path string, defaults DefaultLimitConfig, interval time.Duration) Option {
+	return func(r *resourceManager) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid limit config watch interval: %s", interval)
+		}
+		r.watch = &limitWatch{path: path, defaults: defaults, interval: interval}
+		if fi, err := os.Stat(path); err == nil {
+			r.watch.modTime, r.watch.size = fi.ModTime(), fi.Size()
+		}
+		return nil
+	}
+}
+
+// UpdateLimiter replaces the limiter of the resource manager and applies the new limits to all
+// live scopes. If the new limiter carries an allowlist, it replaces the current allowlist. New
+// scopes are created with the new limiter once the update has started, but live scopes are updated
+// one at a time, each under its own lock, while reservations continue; until the update returns,
+// callers may observe a mix of old and new limits across scopes.
+// IP and subnet scopes that the new limiter does not limit are no longer constrained.
+// Scopes that already use more resources than their new limit allows keep their reservations;
+// they are reported in the result and further reservations in them will be blocked until
+// enough resources have been released.
+func (r *resourceManager) UpdateLimiter(limits Limiter) LimitUpdate {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.limitsMx.Lock()
+	r.limits = limits
+	r.limitsMx.Unlock()
+
+	var assignments map[peer.ID]string
+	if cl, ok := limits.(peerClassLimiter); ok {
+		assignments = cl.GetPeerClassAssignments()
+	}
+	r.setLimiterPeerClasses(assignments)
+
+	var result LimitUpdate
+	update := result.update
+
+	update(r.system.resourceScope, limits.GetSystemLimits())
+	update(r.transient.resourceScope, limits.GetTransientLimits())
+	update(r.allowlistedSystem.resourceScope, allowlistedSystemLimits(limits))
+	update(r.allowlistedTransient.resourceScope, allowlistedTransientLimits(limits))
+
+	if al, ok := limits.(allowlistLimiter); ok && al.GetAllowlist() != nil {
+		r.allowlist.replace(al.GetAllowlist())
+	}
+
+	for name, s := range r.svc {
+		update(s.resourceScope, limits.GetServiceLimits(name))
+
+		s.Lock()
+		for p, ps := range s.peers {
+			update(ps, r.servicePeerLimits(limits, name, p))
+		}
+		s.Unlock()
+	}
+
+	for proto, s := range r.proto {
+		update(s.resourceScope, limits.GetProtocolLimits(proto))
+
+		s.Lock()
+		for p, ps := range s.peers {
+			update(ps, r.protocolPeerLimits(limits, proto, p))
+		}
+		s.Unlock()
+	}
+
+	for p, s := range r.peer {
+		update(s.resourceScope, r.peerLimits(limits, p))
+	}
+
+	for _, s := range r.ip {
+		update(s.resourceScope, s.updateLimit(limits))
+	}
+
+	for _, s := range r.subnet {
+		update(s.resourceScope, s.updateLimit(limits))
+	}
+
+	if len(result.Updated) > 0 {
+		log.Infow("updated resource manager limits", "updated", len(result.Updated), "overlimit", len(result.OverLimit))
+	}
+	for _, name := range result.OverLimit {
+		log.Warnw("scope exceeds its limit after limit update", "scope", name)
+	}
+
+	return result
+}
+
+// unlimitedAddrLimit is the limit of the live IP and subnet scopes that a new limiter no longer
+// limits; the scopes are not created with such a limiter, so they no longer constrain connections.
+var unlimitedAddrLimit = &StaticLimit{
+	Memory: math.MaxInt64,
+	BaseLimit: BaseLimit{
+		Streams:         math.MaxInt,
+		StreamsInbound:  math.MaxInt,
+		StreamsOutbound: math.MaxInt,
+		Conns:           math.MaxInt,
+		ConnsInbound:    math.MaxInt,
+		ConnsOutbound:   math.MaxInt,
+		FD:              math.MaxInt,
+	},
+}
+
+// updateLimit returns the limit of a live IP or subnet scope from a new limiter.
+func (s *ipScope) updateLimit(limits Limiter) Limit {
+	if l := s.limit(limits); l != nil {
+		return l
+	}
+	return unlimitedAddrLimit
+}
+
+// update applies a limit to a scope and records the outcome.
+func (u *LimitUpdate) update(s *resourceScope, limit Limit) {
+	if s.updateLimit(limit) {
+		u.Updated = append(u.Updated, s.name)
+	}
+	if s.isOverLimit() {
+		u.OverLimit = append(u.OverLimit, s.name)
+	}
+}
+
+// updateLimit sets the scope limit if it differs from the current one and reports whether the
+// limit has changed.
+func (s *resourceScope) updateLimit(limit Limit) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if reflect.DeepEqual(s.rc.limit, limit) {
+		return false
+	}
+
+	s.rc.limit = limit
+	s.notifyWaiters()
+	return true
+}
+
+// isOverLimit reports whether the resources in use by the scope exceed its limit.
+func (s *resourceScope) isOverLimit() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.rc.isOverLimit()
+}
+
+func (rc *resources) isOverLimit() bool {
+	return rc.memory > rc.limit.GetMemoryLimit() ||
+		rc.nstreamsIn > rc.limit.GetStreamLimit(network.DirInbound) ||
+		rc.nstreamsOut > rc.limit.GetStreamLimit(network.DirOutbound) ||
+		rc.nstreamsIn+rc.nstreamsOut > rc.limit.GetStreamTotalLimit() ||
+		rc.nconnsIn > rc.limit.GetConnLimit(network.DirInbound) ||
+		rc.nconnsOut > rc.limit.GetConnLimit(network.DirOutbound) ||
+		rc.nconnsIn+rc.nconnsOut > rc.limit.GetConnTotalLimit() ||
+		rc.nfd > rc.limit.GetFDLimit()
+}
+
+func (r *resourceManager) watchLimitConfig() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.watch.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fi, err := os.Stat(r.watch.path)
+			if err != nil {
+				log.Debugf("error checking limit config %s: %s", r.watch.path, err)
+				continue
+			}
+			if fi.ModTime().Equal(r.watch.modTime) && fi.Size() == r.watch.size {
+				continue
+			}
+			r.watch.modTime, r.watch.size = fi.ModTime(), fi.Size()
+
+			limits, err := r.loadLimitConfig()
+			if err != nil {
+				log.Warnf("error loading limit config %s: %s", r.watch.path, err)
+				continue
+			}
+
+			r.UpdateLimiter(limits)
+
+		case <-r.cancelCtx.Done():
+			return
+		}
+	}
+}
+
+func (r *resourceManager) loadLimitConfig() (*BasicLimiter, error) {
+	in, err := os.Open(r.watch.path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	return NewLimiterFromJSON(in, r.watch.defaults)
+}
@@ -0,0 +1,148 @@
+package rcmgr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+func newTestUpdateLimiter(memory int64, streams int) *BasicLimiter {
+	limit := &StaticLimit{
+		Memory: memory,
+		BaseLimit: BaseLimit{
+			StreamsInbound:  streams,
+			StreamsOutbound: streams,
+			Streams:         streams,
+			ConnsInbound:    streams,
+			ConnsOutbound:   streams,
+			Conns:           streams,
+			FD:              streams,
+		},
+	}
+	return &BasicLimiter{
+		SystemLimits:              limit,
+		TransientLimits:           limit,
+		DefaultServiceLimits:      limit,
+		DefaultServicePeerLimits:  limit,
+		DefaultProtocolLimits:     limit,
+		DefaultProtocolPeerLimits: limit,
+		DefaultPeerLimits:         limit,
+		ConnLimits:                limit,
+		StreamLimits:              limit,
+	}
+}
+
+func TestUpdateLimiter(t *testing.T) {
+	peerA := peer.ID("A")
+	protoA := protocol.ID("/A")
+
+	nmgr, err := NewResourceManager(newTestUpdateLimiter(16384, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	stream, err := mgr.OpenStream(peerA, network.DirInbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Done()
+	if err := stream.SetProtocol(protoA); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.ReserveMemory(4096, network.ReservationPriorityAlways); err != nil {
+		t.Fatal(err)
+	}
+
+	// an identical limiter changes nothing
+	result := mgr.UpdateLimiter(newTestUpdateLimiter(16384, 4))
+	if len(result.Updated) != 0 || len(result.OverLimit) != 0 {
+		t.Fatalf("unexpected update result: %+v", result)
+	}
+
+	// shrinking memory below current usage puts the scopes in the stream's edges over limit
+	result = mgr.UpdateLimiter(newTestUpdateLimiter(2048, 4))
+	expectUpdated := map[string]bool{
+		"system":                             true,
+		"transient":                          true,
+		"allowlisted-system":                 true,
+		"allowlisted-transient":              true,
+		"protocol:/A":                        true,
+		"protocol:/A.peer:" + peerA.String(): true,
+		"peer:" + peerA.String():             true,
+	}
+	expectOverLimit := map[string]bool{
+		"system":                             true,
+		"protocol:/A":                        true,
+		"protocol:/A.peer:" + peerA.String(): true,
+		"peer:" + peerA.String():             true,
+	}
+	if len(result.Updated) != len(expectUpdated) {
+		t.Fatalf("expected %d updated scopes, got %v", len(expectUpdated), result.Updated)
+	}
+	for _, name := range result.Updated {
+		if !expectUpdated[name] {
+			t.Fatalf("unexpected updated scope %s", name)
+		}
+	}
+	if len(result.OverLimit) != len(expectOverLimit) {
+		t.Fatalf("expected %d over limit scopes, got %v", len(expectOverLimit), result.OverLimit)
+	}
+	for _, name := range result.OverLimit {
+		if !expectOverLimit[name] {
+			t.Fatalf("unexpected over limit scope %s", name)
+		}
+	}
+
+	if err := stream.ReserveMemory(1024, network.ReservationPriorityAlways); err == nil {
+		t.Fatal("expected memory reservation to fail")
+	}
+
+	// new scopes use the new limiter
+	if err := mgr.ViewService("A.svc", func(s network.ServiceScope) error {
+		if l := s.(*serviceScope).Limit().GetMemoryLimit(); l != 2048 {
+			t.Fatalf("expected memory limit of 2048, got %d", l)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitConfigWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	if err := os.WriteFile(path, []byte(`{"System": {"Memory": 16384}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	nmgr, err := NewResourceManager(newTestUpdateLimiter(16384, 4),
+		WithLimitConfigWatch(path, DefaultLimits, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	// make sure the modification time changes even on filesystems with coarse timestamps
+	mtime := time.Now().Add(time.Second)
+	if err := os.WriteFile(path, []byte(`{"System": {"Memory": 8192}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for mgr.system.Limit().GetMemoryLimit() != 8192 {
+		if time.Now().After(deadline) {
+			t.Fatal("limit config was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
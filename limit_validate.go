@@ -0,0 +1,523 @@
+package rcmgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Severity is the severity of a limiter configuration diagnostic.
+type Severity int
+
+const (
+	// SeverityWarning marks configurations that are accepted, but are likely mistakes, such as
+	// limits that can never be reached.
+	SeverityWarning Severity = iota
+	// SeverityError marks configurations that are rejected or silently ignored.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Diagnostic is a problem found in a limiter configuration.
+type Diagnostic struct {
+	Severity Severity
+	// Path is the JSON path of the offending value, e.g. `$.Protocol["/ipfs/id/1.0.0"].Streams`.
+	Path    string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Path, d.Message)
+}
+
+// ConfigError is the error returned for limiter configurations rejected by strict validation.
+type ConfigError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, 0, len(e.Diagnostics))
+	for _, d := range e.Diagnostics {
+		msgs = append(msgs, d.String())
+	}
+	return "invalid limiter configuration: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks a limiter configuration, reporting invalid values and inconsistent limits,
+// such as scope limits that exceed the system limits or directional limits that can never reach
+// the total limit. Limits are checked as configured, without the defaults that complete them.
+func Validate(cfg BasicLimiterConfig) []Diagnostic {
+	var v validator
+
+	v.limit("$.System", cfg.System, nil, false)
+	v.limit("$.Transient", cfg.Transient, cfg.System, false)
+	v.limit("$.AllowlistedSystem", cfg.AllowlistedSystem, nil, false)
+	v.limit("$.AllowlistedTransient", cfg.AllowlistedTransient, cfg.AllowlistedSystem, false)
+	v.allowlist("$.Allowlist", cfg.Allowlist)
+
+	v.limit("$.ServiceDefault", cfg.ServiceDefault, cfg.System, false)
+	v.limit("$.ServicePeerDefault", cfg.ServicePeerDefault, cfg.System, false)
+	v.limits("$.Service", cfg.Service, cfg.System, nil)
+	v.limits("$.ServicePeer", cfg.ServicePeer, cfg.System, nil)
+
+	v.limit("$.ProtocolDefault", cfg.ProtocolDefault, cfg.System, false)
+	v.limit("$.ProtocolPeerDefault", cfg.ProtocolPeerDefault, cfg.System, false)
+	v.limits("$.Protocol", cfg.Protocol, cfg.System, nil)
+	v.limits("$.ProtocolPeer", cfg.ProtocolPeer, cfg.System, nil)
+
+	v.limit("$.PeerDefault", cfg.PeerDefault, cfg.System, false)
+	v.limits("$.Peer", cfg.Peer, cfg.System, func(s string) error {
+		_, err := peer.Decode(s)
+		return err
+	})
+	v.peerClasses("$.PeerClass", cfg.PeerClass, cfg.System)
+	v.peerOverrides("$.ServicePeerOverride", cfg.ServicePeerOverride, cfg.System)
+	v.peerOverrides("$.ProtocolPeerOverride", cfg.ProtocolPeerOverride, cfg.System)
+
+	v.limit("$.IPDefault", cfg.IPDefault, cfg.System, false)
+	v.limits("$.IP", cfg.IP, cfg.System, func(s string) error {
+		if net.ParseIP(s) == nil {
+			return fmt.Errorf("invalid IP address")
+		}
+		return nil
+	})
+	v.limit("$.SubnetDefault", cfg.SubnetDefault, cfg.System, false)
+	v.limits("$.Subnet", cfg.Subnet, cfg.System, func(s string) error {
+		_, _, err := net.ParseCIDR(s)
+		return err
+	})
+
+	if cfg.IPv4SubnetPrefixLength < 0 || cfg.IPv4SubnetPrefixLength > 8*net.IPv4len {
+		v.errorf("$.IPv4SubnetPrefixLength", "invalid prefix length %d", cfg.IPv4SubnetPrefixLength)
+	}
+	if cfg.IPv6SubnetPrefixLength < 0 || cfg.IPv6SubnetPrefixLength > 8*net.IPv6len {
+		v.errorf("$.IPv6SubnetPrefixLength", "invalid prefix length %d", cfg.IPv6SubnetPrefixLength)
+	}
+
+	v.limit("$.Conn", cfg.Conn, cfg.System, true)
+	v.limit("$.Stream", cfg.Stream, cfg.System, true)
+	v.fixedLimits("$.ProtocolStream", cfg.ProtocolStream, cfg.System)
+	v.fixedLimits("$.ServiceStream", cfg.ServiceStream, cfg.System)
+
+	return v.diags
+}
+
+// ValidateJSON decodes a JSON limiter configuration and validates it. In addition to the
+// problems reported by Validate, it reports unknown fields, which are otherwise ignored.
+func ValidateJSON(in io.Reader) ([]Diagnostic, error) {
+	_, diags, err := decodeLimiterConfig(in)
+	return diags, err
+}
+
+// NewLimiterFromJSONStrict creates a new limiter by parsing a json configuration, like
+// NewLimiterFromJSON, but rejects configurations with any validation diagnostics, including unknown
+// fields; warnings, such as scope limits that exceed the system limits or total limits that can
+// never be reached, are promoted to errors. The error is a *ConfigError.
+func NewLimiterFromJSONStrict(in io.Reader, defaults DefaultLimitConfig) (*BasicLimiter, error) {
+	cfg, diags, err := decodeLimiterConfig(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(diags) > 0 {
+		for i := range diags {
+			diags[i].Severity = SeverityError
+		}
+		return nil, &ConfigError{Diagnostics: diags}
+	}
+
+	return NewLimiter(cfg, defaults)
+}
+
+func decodeLimiterConfig(in io.Reader) (BasicLimiterConfig, []Diagnostic, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return BasicLimiterConfig{}, nil, err
+	}
+	return parseLimiterConfig(data)
+}
+
+// parseLimiterConfig parses a JSON limiter configuration and validates it.
+func parseLimiterConfig(data []byte) (BasicLimiterConfig, []Diagnostic, error) {
+	var cfg BasicLimiterConfig
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, nil, err
+	}
+
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return cfg, nil, err
+	}
+
+	var v validator
+	v.unknownFields("$", raw, reflect.TypeOf(cfg))
+	v.diags = append(v.diags, Validate(cfg)...)
+
+	return cfg, v.diags, nil
+}
+
+type validator struct {
+	diags []Diagnostic
+}
+
+func (v *validator) errorf(path, format string, args ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{Severity: SeverityError, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(path, format string, args ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{Severity: SeverityWarning, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func jsonKey(path, key string) string {
+	return fmt.Sprintf("%s[%q]", path, key)
+}
+
+func (v *validator) limits(path string, cfgs map[string]BasicLimitConfig, system *BasicLimitConfig, checkKey func(string) error) {
+	keys := make([]string, 0, len(cfgs))
+	for k := range cfgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := jsonKey(path, k)
+		if checkKey != nil {
+			if err := checkKey(k); err != nil {
+				v.errorf(p, "invalid key: %s", err)
+			}
+		}
+		cfg := cfgs[k]
+		v.limit(p, &cfg, system, false)
+	}
+}
+
+func (v *validator) fixedLimits(path string, cfgs map[string]BasicLimitConfig, system *BasicLimitConfig) {
+	keys := make([]string, 0, len(cfgs))
+	for k := range cfgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		cfg := cfgs[k]
+		v.limit(jsonKey(path, k), &cfg, system, true)
+	}
+}
+
+// limit checks a single limit; parent is the limit it is constrained by, if any, and fixed is
+// true for limits that do not support memory ranges.
+func (v *validator) limit(path string, cfg, parent *BasicLimitConfig, fixed bool) {
+	if cfg == nil {
+		return
+	}
+
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"Memory", int64(cfg.Memory)},
+		{"StreamsInbound", int64(cfg.StreamsInbound)},
+		{"StreamsOutbound", int64(cfg.StreamsOutbound)},
+		{"Streams", int64(cfg.Streams)},
+		{"ConnsInbound", int64(cfg.ConnsInbound)},
+		{"ConnsOutbound", int64(cfg.ConnsOutbound)},
+		{"Conns", int64(cfg.Conns)},
+		{"FD", int64(cfg.FD)},
+	} {
+		if f.value < int64(BlockAllLimit) {
+			v.errorf(path+"."+f.name, "invalid value %d", f.value)
+		}
+	}
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"MinMemory", cfg.MinMemory},
+		{"MaxMemory", cfg.MaxMemory},
+	} {
+		if f.value < 0 {
+			v.errorf(path+"."+f.name, "negative value %d", f.value)
+		}
+	}
+
+	hasRange := cfg.MemoryFraction != 0 || cfg.MinMemory != 0 || cfg.MaxMemory != 0
+	switch {
+	case fixed && cfg.Dynamic:
+		v.errorf(path+".Dynamic", "dynamic limits are not supported for this scope")
+	case fixed && hasRange:
+		v.errorf(path, "memory ranges are not supported for this scope; set Memory instead")
+	case cfg.Memory != DefaultLimit64 && (hasRange || cfg.Dynamic):
+		v.warnf(path+".Memory", "fixed memory limit overrides the memory range")
+	}
+
+	if cfg.MemoryFraction < 0 {
+		v.errorf(path+".MemoryFraction", "negative memory fraction %g", cfg.MemoryFraction)
+	} else if cfg.MemoryFraction > 1 {
+		v.warnf(path+".MemoryFraction", "memory fraction %g exceeds the total memory", cfg.MemoryFraction)
+	}
+	if cfg.MinMemory > 0 && cfg.MaxMemory > 0 && cfg.MinMemory > cfg.MaxMemory {
+		v.errorf(path+".MinMemory", "minimum memory %d exceeds maximum memory %d", cfg.MinMemory, cfg.MaxMemory)
+	}
+
+	v.total(path, "Streams", cfg.StreamsInbound, cfg.StreamsOutbound, cfg.Streams)
+	v.total(path, "Conns", cfg.ConnsInbound, cfg.ConnsOutbound, cfg.Conns)
+
+	for _, r := range []struct {
+		name string
+		rate *RateLimit
+	}{
+		{"ConnRateInbound", cfg.ConnRateInbound},
+		{"ConnRateOutbound", cfg.ConnRateOutbound},
+		{"StreamRateInbound", cfg.StreamRateInbound},
+		{"StreamRateOutbound", cfg.StreamRateOutbound},
+	} {
+		if r.rate == nil {
+			continue
+		}
+		if err := r.rate.validate(); err != nil {
+			v.errorf(path+"."+r.name, "%s", err)
+		}
+	}
+
+	if parent == nil {
+		return
+	}
+	for _, f := range []struct {
+		name          string
+		value, parent int64
+	}{
+		{"Memory", int64(cfg.Memory), int64(parent.Memory)},
+		{"StreamsInbound", int64(cfg.StreamsInbound), int64(parent.StreamsInbound)},
+		{"StreamsOutbound", int64(cfg.StreamsOutbound), int64(parent.StreamsOutbound)},
+		{"Streams", int64(cfg.Streams), int64(parent.Streams)},
+		{"ConnsInbound", int64(cfg.ConnsInbound), int64(parent.ConnsInbound)},
+		{"ConnsOutbound", int64(cfg.ConnsOutbound), int64(parent.ConnsOutbound)},
+		{"Conns", int64(cfg.Conns), int64(parent.Conns)},
+		{"FD", int64(cfg.FD), int64(parent.FD)},
+	} {
+		value, ok := configuredLimit(f.value)
+		if !ok {
+			continue
+		}
+		plimit, ok := configuredLimit(f.parent)
+		if ok && value > plimit {
+			v.warnf(path+"."+f.name, "limit %s exceeds the system limit %s", fmtLimit(value), fmtLimit(plimit))
+		}
+	}
+}
+
+// total checks the directional limits of a resource against its total limit.
+func (v *validator) total(path, name string, inv, outv, totalv LimitVal) {
+	total, ok := configuredLimit(int64(totalv))
+	if !ok || total == math.MaxInt64 {
+		return
+	}
+	in, inok := configuredLimit(int64(inv))
+	out, outok := configuredLimit(int64(outv))
+
+	if inok && in > total {
+		v.warnf(path+"."+name+"Inbound", "limit %s exceeds the total limit %d", fmtLimit(in), total)
+	}
+	if outok && out > total {
+		v.warnf(path+"."+name+"Outbound", "limit %s exceeds the total limit %d", fmtLimit(out), total)
+	}
+	if inok && outok && in < total && out < total && in+out < total {
+		v.warnf(path+"."+name, "total limit %d can never be reached with inbound limit %d and outbound limit %d", total, in, out)
+	}
+}
+
+// configuredLimit returns the concrete value of a configured limit, with math.MaxInt64 for
+// unlimited; it returns false for the default and invalid values.
+func configuredLimit(v int64) (int64, bool) {
+	switch {
+	case v == int64(DefaultLimit) || v < int64(BlockAllLimit):
+		return 0, false
+	case v == int64(Unlimited):
+		return math.MaxInt64, true
+	case v == int64(BlockAllLimit):
+		return 0, true
+	default:
+		return v, true
+	}
+}
+
+func fmtLimit(v int64) string {
+	if v == math.MaxInt64 {
+		return "unlimited"
+	}
+	return fmt.Sprint(v)
+}
+
+func (v *validator) peerClasses(path string, cfgs map[string]PeerClassConfig, system *BasicLimitConfig) {
+	names := make([]string, 0, len(cfgs))
+	for name := range cfgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	assigned := make(map[string]string)
+	for _, name := range names {
+		p := jsonKey(path, name)
+		if name == "" {
+			v.errorf(p, "empty peer class name")
+		}
+
+		cfg := cfgs[name]
+		for i, s := range cfg.Peers {
+			pp := fmt.Sprintf("%s.Peers[%d]", p, i)
+			pid, err := peer.Decode(s)
+			if err != nil {
+				v.errorf(pp, "invalid peer ID: %s", err)
+				continue
+			}
+			if other, ok := assigned[pid.String()]; ok && other != name {
+				v.errorf(pp, "peer is already assigned to peer class %q", other)
+				continue
+			}
+			assigned[pid.String()] = name
+		}
+
+		v.limit(p+".Peer", cfg.Peer, system, false)
+		v.limit(p+".ServicePeerDefault", cfg.ServicePeerDefault, system, false)
+		v.limits(p+".ServicePeer", cfg.ServicePeer, system, nil)
+		v.limit(p+".ProtocolPeerDefault", cfg.ProtocolPeerDefault, system, false)
+		v.limits(p+".ProtocolPeer", cfg.ProtocolPeer, system, nil)
+	}
+}
+
+func (v *validator) peerOverrides(path string, cfgs map[string]map[string]BasicLimitConfig, system *BasicLimitConfig) {
+	peers := make([]string, 0, len(cfgs))
+	for p := range cfgs {
+		peers = append(peers, p)
+	}
+	sort.Strings(peers)
+
+	for _, p := range peers {
+		pp := jsonKey(path, p)
+		if _, err := peer.Decode(p); err != nil {
+			v.errorf(pp, "invalid key: %s", err)
+		}
+		v.limits(pp, cfgs[p], system, nil)
+	}
+}
+
+func (v *validator) allowlist(path string, cfg *AllowlistConfig) {
+	if cfg == nil {
+		return
+	}
+
+	for i, s := range cfg.Peers {
+		if _, err := peer.Decode(s); err != nil {
+			v.errorf(fmt.Sprintf("%s.Peers[%d]", path, i), "invalid peer ID: %s", err)
+		}
+	}
+	for i, s := range cfg.Addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err == nil {
+			err = NewAllowlist().Add(addr)
+		}
+		if err != nil {
+			v.errorf(fmt.Sprintf("%s.Addrs[%d]", path, i), "invalid multiaddr: %s", err)
+		}
+	}
+	for i, s := range cfg.CIDRs {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			v.errorf(fmt.Sprintf("%s.CIDRs[%d]", path, i), "invalid CIDR: %s", err)
+		}
+	}
+}
+
+// unknownFields reports the fields of a decoded JSON value that do not match any field of the
+// type it is decoded into. As with encoding/json, field names are matched case-insensitively.
+func (v *validator) unknownFields(path string, raw interface{}, typ reflect.Type) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		obj, ok := raw.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, k := range sortedJSONKeys(obj) {
+			field, ok := jsonField(typ, k)
+			if !ok {
+				v.errorf(path+"."+k, "unknown field")
+				continue
+			}
+			v.unknownFields(path+"."+k, obj[k], field.Type)
+		}
+
+	case reflect.Map:
+		obj, ok := raw.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, k := range sortedJSONKeys(obj) {
+			v.unknownFields(jsonKey(path, k), obj[k], typ.Elem())
+		}
+
+	case reflect.Slice:
+		arr, ok := raw.([]interface{})
+		if !ok {
+			return
+		}
+		for i, value := range arr {
+			v.unknownFields(fmt.Sprintf("%s[%d]", path, i), value, typ.Elem())
+		}
+	}
+}
+
+func jsonField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Name
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if tag != "" {
+			name = tag
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func sortedJSONKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,144 @@
+package rcmgr
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func hasDiagnostic(diags []Diagnostic, sev Severity, path string) bool {
+	for _, d := range diags {
+		if d.Severity == sev && d.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidate(t *testing.T) {
+	cfg := BasicLimiterConfig{
+		System: &BasicLimitConfig{StreamsInbound: 100, FD: 100},
+		Protocol: map[string]BasicLimitConfig{
+			"/test": {StreamsInbound: 200},
+		},
+		PeerDefault: &BasicLimitConfig{
+			StreamsInbound:  10,
+			StreamsOutbound: 10,
+			Streams:         30,
+			FD:              200,
+		},
+		ServiceDefault: &BasicLimitConfig{Dynamic: true, MinMemory: 2048, MaxMemory: 1024},
+		Peer: map[string]BasicLimitConfig{
+			"not a peer": {},
+		},
+		Stream:                 &BasicLimitConfig{MemoryFraction: 0.5},
+		IPv4SubnetPrefixLength: 33,
+	}
+
+	diags := Validate(cfg)
+	for _, c := range []struct {
+		sev  Severity
+		path string
+	}{
+		{SeverityWarning, `$.Protocol["/test"].StreamsInbound`},
+		{SeverityWarning, "$.PeerDefault.Streams"},
+		{SeverityWarning, "$.PeerDefault.FD"},
+		{SeverityError, "$.ServiceDefault.MinMemory"},
+		{SeverityError, `$.Peer["not a peer"]`},
+		{SeverityError, "$.Stream"},
+		{SeverityError, "$.IPv4SubnetPrefixLength"},
+	} {
+		if !hasDiagnostic(diags, c.sev, c.path) {
+			t.Errorf("missing %s at %s in %v", c.sev, c.path, diags)
+		}
+	}
+	if len(diags) != 7 {
+		t.Fatalf("expected 7 diagnostics, got %v", diags)
+	}
+
+	if diags := Validate(BasicLimiterConfig{}); len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics for empty config: %v", diags)
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	in, err := os.Open("limit_config_test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	diags, err := ValidateJSON(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			t.Fatalf("unexpected error in test config: %s", d)
+		}
+	}
+
+	const cfg = `{
+  "system": {"StreamsInbound": 10, "MaxStreams": 20},
+  "Protocol": {"/test": {"Stream": 1}},
+  "Allowlist": {"Peers": [], "Nodes": []}
+}`
+	diags, err = ValidateJSON(strings.NewReader(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		"$.system.MaxStreams",
+		`$.Protocol["/test"].Stream`,
+		"$.Allowlist.Nodes",
+	} {
+		if !hasDiagnostic(diags, SeverityError, path) {
+			t.Errorf("missing unknown field at %s in %v", path, diags)
+		}
+	}
+
+	// the lenient parser ignores unknown fields, while strict mode rejects them
+	if _, err := NewLimiterFromJSON(strings.NewReader(cfg), DefaultLimits); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewLimiterFromJSONStrict(strings.NewReader(cfg), DefaultLimits)
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) || len(cerr.Diagnostics) != 3 {
+		t.Fatalf("expected config error with 3 diagnostics, got %v", err)
+	}
+
+	if _, err := NewLimiterFromJSONStrict(strings.NewReader(`{"System": {"Streams": 10}}`), DefaultLimits); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStrictRejectsInconsistentLimits(t *testing.T) {
+	for _, c := range []struct {
+		cfg  string
+		path string
+	}{
+		// a protocol limit exceeding the system limit
+		{`{"System": {"StreamsInbound": 100}, "Protocol": {"/test": {"StreamsInbound": 200}}}`, `$.Protocol["/test"].StreamsInbound`},
+		// a total limit that can never be reached
+		{`{"PeerDefault": {"StreamsInbound": 10, "StreamsOutbound": 10, "Streams": 30}}`, "$.PeerDefault.Streams"},
+		// a directional limit exceeding the total limit
+		{`{"PeerDefault": {"ConnsInbound": 10, "Conns": 5}}`, "$.PeerDefault.ConnsInbound"},
+		// a peer limit allowing more FDs than the system
+		{`{"System": {"FD": 100}, "PeerDefault": {"FD": 200}}`, "$.PeerDefault.FD"},
+	} {
+		// the lenient parser accepts the configuration
+		if _, err := NewLimiterFromJSON(strings.NewReader(c.cfg), DefaultLimits); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := NewLimiterFromJSONStrict(strings.NewReader(c.cfg), DefaultLimits)
+		var cerr *ConfigError
+		if !errors.As(err, &cerr) {
+			t.Fatalf("expected config error for %s, got %v", c.cfg, err)
+		}
+		if !hasDiagnostic(cerr.Diagnostics, SeverityError, c.path) {
+			t.Fatalf("missing error at %s in %v", c.path, cerr.Diagnostics)
+		}
+	}
+}
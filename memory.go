@@ -0,0 +1,184 @@
+package rcmgr
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/memory"
+)
+
+// MemorySource provides the amount of memory available to the process; it is used to compute
+// memory limits specified as a fraction of system memory.
+type MemorySource interface {
+	// TotalMemory returns the total memory available to the process, in bytes.
+	TotalMemory() int64
+	// FreeMemory returns the memory that is currently free for use by the process, in bytes.
+	FreeMemory() int64
+}
+
+// DefaultCgroupRoot is the default mount point of the cgroup filesystem.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// DefaultCgroupCacheTTL is the time for which NewCgroupMemorySource caches the cgroup memory
+// limit and usage.
+const DefaultCgroupCacheTTL = 100 * time.Millisecond
+
+// DefaultMemorySource is the memory source used by the limiter constructors and dynamic limits.
+// It honors cgroup memory limits, falling back to host memory when there is no limit in place.
+var DefaultMemorySource MemorySource = NewCgroupMemorySource(DefaultCgroupRoot)
+
+// HostMemorySource is a memory source that reports the memory of the host, ignoring any
+// cgroup limits.
+type HostMemorySource struct{}
+
+var _ MemorySource = HostMemorySource{}
+
+func (HostMemorySource) TotalMemory() int64 {
+	return int64(memory.TotalMemory())
+}
+
+func (HostMemorySource) FreeMemory() int64 {
+	return int64(memory.FreeMemory())
+}
+
+// CgroupMemorySource is a memory source that honors the memory limit of the cgroup the process
+// runs in. Both cgroup v2 (memory.max, memory.current) and cgroup v1 (memory.limit_in_bytes,
+// memory.usage_in_bytes) hierarchies are supported. If there is no cgroup memory limit, or the
+// limit exceeds the memory of the host, the host memory is reported instead.
+type CgroupMemorySource struct {
+	// Root is the path of the cgroup of the process; in a container this is normally the
+	// mount point of the cgroup filesystem.
+	Root string
+	// Host is the memory source used when there is no cgroup limit.
+	Host MemorySource
+	// CacheTTL is the time for which the cgroup memory limit and usage are cached, so that
+	// dynamic limits do not read the cgroup files on every check; if zero, they are read on
+	// every call.
+	CacheTTL time.Duration
+
+	mx      sync.Mutex
+	readAt  time.Time
+	cgLimit int64
+	limitOk bool
+	cgUsage int64
+	usageOk bool
+}
+
+var _ MemorySource = (*CgroupMemorySource)(nil)
+
+// NewCgroupMemorySource creates a new cgroup memory source for the cgroup at root, using host
+// memory as the fallback.
+func NewCgroupMemorySource(root string) *CgroupMemorySource {
+	return &CgroupMemorySource{
+		Root:     root,
+		Host:     HostMemorySource{},
+		CacheTTL: DefaultCgroupCacheTTL,
+	}
+}
+
+func (c *CgroupMemorySource) TotalMemory() int64 {
+	total := c.Host.TotalMemory()
+
+	limit, ok, _, _ := c.read()
+	if !ok || limit > total {
+		return total
+	}
+
+	return limit
+}
+
+func (c *CgroupMemorySource) FreeMemory() int64 {
+	free := c.Host.FreeMemory()
+
+	limit, ok, usage, usageOk := c.read()
+	if !ok || !usageOk {
+		return free
+	}
+
+	cgfree := limit - usage
+	if cgfree < 0 {
+		cgfree = 0
+	}
+	if cgfree < free {
+		return cgfree
+	}
+
+	return free
+}
+
+// read returns the cgroup memory limit and usage, reading them from the cgroup files if the
+// cached values are older than the cache TTL.
+func (c *CgroupMemorySource) read() (limit int64, limitOk bool, usage int64, usageOk bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	now := time.Now()
+	if c.CacheTTL <= 0 || c.readAt.IsZero() || now.Sub(c.readAt) >= c.CacheTTL {
+		c.cgLimit, c.limitOk = c.limit()
+		c.cgUsage, c.usageOk = c.usage()
+		c.readAt = now
+	}
+
+	return c.cgLimit, c.limitOk, c.cgUsage, c.usageOk
+}
+
+// limit returns the cgroup memory limit, if there is one.
+func (c *CgroupMemorySource) limit() (int64, bool) {
+	// cgroup v2
+	limit, err := readCgroupValue(filepath.Join(c.Root, "memory.max"))
+	if err == nil {
+		return limit, limit >= 0
+	}
+
+	// cgroup v1, either the memory controller hierarchy or the root of the cgroup filesystem
+	for _, path := range []string{
+		filepath.Join(c.Root, "memory.limit_in_bytes"),
+		filepath.Join(c.Root, "memory", "memory.limit_in_bytes"),
+	} {
+		limit, err := readCgroupValue(path)
+		if err == nil {
+			return limit, limit >= 0
+		}
+	}
+
+	return 0, false
+}
+
+// usage returns the current memory usage of the cgroup.
+func (c *CgroupMemorySource) usage() (int64, bool) {
+	for _, path := range []string{
+		filepath.Join(c.Root, "memory.current"),
+		filepath.Join(c.Root, "memory.usage_in_bytes"),
+		filepath.Join(c.Root, "memory", "memory.usage_in_bytes"),
+	} {
+		usage, err := readCgroupValue(path)
+		if err == nil {
+			return usage, usage >= 0
+		}
+	}
+
+	return 0, false
+}
+
+// readCgroupValue reads a single value cgroup file; the value "max" is reported as -1.
+func readCgroupValue(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return -1, nil
+	}
+	if value == "" {
+		return 0, errors.New("empty cgroup value")
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
@@ -0,0 +1,164 @@
+package rcmgr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeHostMemory struct {
+	total, free int64
+}
+
+func (m fakeHostMemory) TotalMemory() int64 { return m.total }
+func (m fakeHostMemory) FreeMemory() int64  { return m.free }
+
+func writeCgroupFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCgroupMemorySource(t *testing.T) {
+	host := fakeHostMemory{total: 256 << 30, free: 128 << 30}
+
+	testCases := []struct {
+		name          string
+		files         map[string]string
+		expectedTotal int64
+		expectedFree  int64
+	}{
+		{
+			name:          "no cgroup",
+			expectedTotal: 256 << 30,
+			expectedFree:  128 << 30,
+		},
+		{
+			name: "v2 limit",
+			files: map[string]string{
+				"memory.max":     "2147483648\n",
+				"memory.current": "536870912\n",
+			},
+			expectedTotal: 2 << 30,
+			expectedFree:  1536 << 20,
+		},
+		{
+			name: "v2 unlimited",
+			files: map[string]string{
+				"memory.max":     "max\n",
+				"memory.current": "536870912\n",
+			},
+			expectedTotal: 256 << 30,
+			expectedFree:  128 << 30,
+		},
+		{
+			name: "v2 usage above limit",
+			files: map[string]string{
+				"memory.max":     "1073741824\n",
+				"memory.current": "2147483648\n",
+			},
+			expectedTotal: 1 << 30,
+			expectedFree:  0,
+		},
+		{
+			name: "v1 limit",
+			files: map[string]string{
+				"memory/memory.limit_in_bytes": "2147483648\n",
+				"memory/memory.usage_in_bytes": "1073741824\n",
+			},
+			expectedTotal: 2 << 30,
+			expectedFree:  1 << 30,
+		},
+		{
+			name: "v1 memory controller root",
+			files: map[string]string{
+				"memory.limit_in_bytes": "4294967296\n",
+				"memory.usage_in_bytes": "1073741824\n",
+			},
+			expectedTotal: 4 << 30,
+			expectedFree:  3 << 30,
+		},
+		{
+			name: "v1 unlimited",
+			files: map[string]string{
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+				"memory/memory.usage_in_bytes": "1073741824\n",
+			},
+			expectedTotal: 256 << 30,
+			expectedFree:  128 << 30,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeCgroupFiles(t, root, tc.files)
+
+			src := &CgroupMemorySource{Root: root, Host: host}
+			if total := src.TotalMemory(); total != tc.expectedTotal {
+				t.Fatalf("expected total memory %d, got %d", tc.expectedTotal, total)
+			}
+			if free := src.FreeMemory(); free != tc.expectedFree {
+				t.Fatalf("expected free memory %d, got %d", tc.expectedFree, free)
+			}
+		})
+	}
+}
+
+func TestCgroupMemorySourceCache(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFiles(t, root, map[string]string{
+		"memory.max":     "2147483648\n",
+		"memory.current": "536870912\n",
+	})
+
+	src := &CgroupMemorySource{Root: root, Host: fakeHostMemory{total: 256 << 30, free: 128 << 30}, CacheTTL: 50 * time.Millisecond}
+	if free := src.FreeMemory(); free != 1536<<20 {
+		t.Fatalf("expected free memory %d, got %d", 1536<<20, free)
+	}
+
+	// the cached usage is reported until the TTL expires
+	writeCgroupFiles(t, root, map[string]string{
+		"memory.current": "1073741824\n",
+	})
+	if free := src.FreeMemory(); free != 1536<<20 {
+		t.Fatalf("expected cached free memory %d, got %d", 1536<<20, free)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if free := src.FreeMemory(); free != 1<<30 {
+		t.Fatalf("expected free memory %d, got %d", 1<<30, free)
+	}
+}
+
+func TestLimitersUseMemorySource(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFiles(t, root, map[string]string{
+		"memory.max":     "2147483648\n",
+		"memory.current": "1073741824\n",
+	})
+
+	saved := DefaultMemorySource
+	DefaultMemorySource = &CgroupMemorySource{Root: root, Host: fakeHostMemory{total: 256 << 30, free: 128 << 30}}
+	defer func() { DefaultMemorySource = saved }()
+
+	static := NewDefaultStaticLimiter(0.125, 1<<20, 1<<40)
+	if mem := static.SystemLimits.GetMemoryLimit(); mem != 256<<20 {
+		t.Fatalf("expected static system memory limit of %d, got %d", 256<<20, mem)
+	}
+
+	dynamic := NewDefaultDynamicLimiter(0.5, 1<<20, 1<<40)
+	// the runtime may add some memory held in its heap, but not more than a fraction of the host memory
+	if mem := dynamic.SystemLimits.GetMemoryLimit(); mem < 512<<20 || mem > 1<<30 {
+		t.Fatalf("expected dynamic system memory limit of about %d, got %d", 512<<20, mem)
+	}
+}
@@ -43,6 +43,26 @@ type MetricsReporter interface {
 	BlockMemory(size int)
 }
 
+// ShadowMetricsReporter is an optional extension of MetricsReporter, for collecting would-be blocks
+// from scopes in shadow mode.
+type ShadowMetricsReporter interface {
+	MetricsReporter
+
+	// ShadowBlock is invoked when a reservation would have been blocked, but was allowed to
+	// proceed because the blocking scope is in shadow mode
+	ShadowBlock(err *ErrLimitExceeded)
+}
+
+// RateMetricsReporter is an optional extension of MetricsReporter, for collecting openings of
+// connections and streams blocked by rate limits.
+type RateMetricsReporter interface {
+	MetricsReporter
+
+	// BlockRate is invoked when opening a connection or stream is blocked by the rate limit of a
+	// scope
+	BlockRate(err *ErrLimitExceeded)
+}
+
 type metrics struct {
 	reporter MetricsReporter
 }
@@ -166,3 +186,23 @@ func (m *metrics) BlockMemory(size int) {
 
 	m.reporter.BlockMemory(size)
 }
+
+func (m *metrics) ShadowBlock(err *ErrLimitExceeded) {
+	if m == nil {
+		return
+	}
+
+	if reporter, ok := m.reporter.(ShadowMetricsReporter); ok {
+		reporter.ShadowBlock(err)
+	}
+}
+
+func (m *metrics) BlockRate(err *ErrLimitExceeded) {
+	if m == nil {
+		return
+	}
+
+	if reporter, ok := m.reporter.(RateMetricsReporter); ok {
+		reporter.BlockRate(err)
+	}
+}
@@ -0,0 +1,143 @@
+package obs
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMetricsReporter(t *testing.T) {
+	reporter := NewMetricsReporter()
+	mgr, err := rcmgr.NewResourceManager(rcmgr.NewDefaultLimiter(), rcmgr.WithMetrics(reporter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	conn, err := mgr.OpenConnection(network.DirInbound, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Done()
+
+	stream, err := mgr.OpenStream(peer.ID("A"), network.DirOutbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Done()
+
+	if err := stream.SetProtocol("/test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := testutil.ToFloat64(reporter.conns.WithLabelValues("inbound", "true", resultAllowed)); v != 1 {
+		t.Fatalf("expected 1 allowed conn, got %f", v)
+	}
+	if v := testutil.ToFloat64(reporter.streams.WithLabelValues("outbound", resultAllowed)); v != 1 {
+		t.Fatalf("expected 1 allowed stream, got %f", v)
+	}
+	if v := testutil.ToFloat64(reporter.protocols.WithLabelValues("/test", resultAllowed)); v != 1 {
+		t.Fatalf("expected 1 allowed protocol, got %f", v)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(reporter); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gauge(t *testing.T, families []*dto.MetricFamily, name string, labels map[string]string) (float64, bool) {
+	t.Helper()
+
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v != l.GetValue() {
+					continue metrics
+				}
+			}
+			return m.GetGauge().GetValue(), true
+		}
+	}
+
+	return 0, false
+}
+
+func TestStatCollector(t *testing.T) {
+	mgr, err := rcmgr.NewResourceManager(rcmgr.NewDefaultLimiter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	var streams []network.StreamManagementScope
+	for i, p := range []peer.ID{"A", "B", "C"} {
+		stream, err := mgr.OpenStream(p, network.DirInbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Done()
+		if err := stream.ReserveMemory(1024*(i+1), network.ReservationPriorityAlways); err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, stream)
+	}
+	if err := streams[0].SetProtocol("/test"); err != nil {
+		t.Fatal(err)
+	}
+
+	collector, err := NewStatCollector(mgr, WithTopPeers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		value  float64
+	}{
+		{"libp2p_rcmgr_usage", map[string]string{"scope": "system", "resource": "streams-inbound"}, 3},
+		{"libp2p_rcmgr_usage", map[string]string{"scope": "system", "resource": "memory"}, 6144},
+		{"libp2p_rcmgr_usage", map[string]string{"scope": "protocol", "name": "/test", "resource": "streams"}, 1},
+		{"libp2p_rcmgr_usage", map[string]string{"scope": "peer", "name": peer.ID("C").String(), "resource": "memory"}, 3072},
+		{"libp2p_rcmgr_usage", map[string]string{"scope": "peer", "name": otherPeers, "resource": "memory"}, 3072},
+		{"libp2p_rcmgr_usage", map[string]string{"scope": "peer", "name": otherPeers, "resource": "streams-inbound"}, 2},
+		{"libp2p_rcmgr_peers", nil, 3},
+		{"libp2p_rcmgr_limit", map[string]string{"scope": "system", "resource": "conns-inbound"}, float64(rcmgr.DefaultLimits.SystemBaseLimit.ConnsInbound)},
+		{"libp2p_rcmgr_limit", map[string]string{"scope": "protocol", "name": "/test", "resource": "streams"}, float64(rcmgr.DefaultLimits.ProtocolBaseLimit.Streams)},
+	} {
+		v, ok := gauge(t, families, tc.name, tc.labels)
+		if !ok {
+			t.Fatalf("missing %s %v", tc.name, tc.labels)
+		}
+		if v != tc.value {
+			t.Fatalf("expected %s %v to be %f, got %f", tc.name, tc.labels, tc.value, v)
+		}
+	}
+
+	if _, ok := gauge(t, families, "libp2p_rcmgr_usage", map[string]string{"scope": "peer", "name": peer.ID("A").String()}); ok {
+		t.Fatal("expected peer A to be aggregated")
+	}
+}
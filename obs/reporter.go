@@ -0,0 +1,180 @@
+// Package obs implements metrics collection for the resource manager with Prometheus.
+package obs
+
+import (
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "libp2p"
+	subsystem = "rcmgr"
+)
+
+const (
+	resultAllowed = "allowed"
+	resultBlocked = "blocked"
+)
+
+// MetricsReporter is a resource manager metrics reporter that counts allowed and blocked
+// reservations with Prometheus counters. It is a prometheus.Collector, and must be registered
+// with a prometheus registry for the counters to be exported.
+//
+// Counters are never labeled by peer, as the number of peers is unbounded; per peer usage is
+// exported by the StatCollector instead.
+type MetricsReporter struct {
+	conns         *prometheus.CounterVec
+	streams       *prometheus.CounterVec
+	peers         *prometheus.CounterVec
+	protocols     *prometheus.CounterVec
+	protocolPeers *prometheus.CounterVec
+	services      *prometheus.CounterVec
+	servicePeers  *prometheus.CounterVec
+	memory        *prometheus.CounterVec
+	shadowBlocks  *prometheus.CounterVec
+	rateBlocks    *prometheus.CounterVec
+}
+
+var _ rcmgr.MetricsReporter = (*MetricsReporter)(nil)
+var _ rcmgr.ShadowMetricsReporter = (*MetricsReporter)(nil)
+var _ rcmgr.RateMetricsReporter = (*MetricsReporter)(nil)
+var _ prometheus.Collector = (*MetricsReporter)(nil)
+
+// NewMetricsReporter creates a new metrics reporter.
+func NewMetricsReporter() *MetricsReporter {
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+		}, labels)
+	}
+
+	return &MetricsReporter{
+		conns:         counter("conns_total", "Number of allowed and blocked connections.", "dir", "fd", "result"),
+		streams:       counter("streams_total", "Number of allowed and blocked streams.", "dir", "result"),
+		peers:         counter("peers_total", "Number of allowed and blocked attachments of connections to peers.", "result"),
+		protocols:     counter("protocols_total", "Number of allowed and blocked attachments of streams to protocols.", "protocol", "result"),
+		protocolPeers: counter("protocol_peer_blocks_total", "Number of attachments of streams to protocols blocked at the protocol peer scope.", "protocol"),
+		services:      counter("services_total", "Number of allowed and blocked attachments of streams to services.", "service", "result"),
+		servicePeers:  counter("service_peer_blocks_total", "Number of attachments of streams to services blocked at the service peer scope.", "service"),
+		memory:        counter("memory_reservations_total", "Number of allowed and blocked memory reservations.", "result"),
+		shadowBlocks:  counter("shadow_blocks_total", "Number of reservations that would have been blocked by scopes in shadow mode.", "scope", "resource"),
+		rateBlocks:    counter("rate_blocks_total", "Number of connection and stream openings blocked by rate limits.", "scope", "resource"),
+	}
+}
+
+func (r *MetricsReporter) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		r.conns,
+		r.streams,
+		r.peers,
+		r.protocols,
+		r.protocolPeers,
+		r.services,
+		r.servicePeers,
+		r.memory,
+		r.shadowBlocks,
+		r.rateBlocks,
+	}
+}
+
+func (r *MetricsReporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range r.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (r *MetricsReporter) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range r.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func dirLabel(dir network.Direction) string {
+	switch dir {
+	case network.DirInbound:
+		return "inbound"
+	case network.DirOutbound:
+		return "outbound"
+	default:
+		return "unknown"
+	}
+}
+
+func fdLabel(usefd bool) string {
+	if usefd {
+		return "true"
+	}
+	return "false"
+}
+
+func (r *MetricsReporter) AllowConn(dir network.Direction, usefd bool) {
+	r.conns.WithLabelValues(dirLabel(dir), fdLabel(usefd), resultAllowed).Inc()
+}
+
+func (r *MetricsReporter) BlockConn(dir network.Direction, usefd bool) {
+	r.conns.WithLabelValues(dirLabel(dir), fdLabel(usefd), resultBlocked).Inc()
+}
+
+func (r *MetricsReporter) AllowStream(p peer.ID, dir network.Direction) {
+	r.streams.WithLabelValues(dirLabel(dir), resultAllowed).Inc()
+}
+
+func (r *MetricsReporter) BlockStream(p peer.ID, dir network.Direction) {
+	r.streams.WithLabelValues(dirLabel(dir), resultBlocked).Inc()
+}
+
+func (r *MetricsReporter) AllowPeer(p peer.ID) {
+	r.peers.WithLabelValues(resultAllowed).Inc()
+}
+
+func (r *MetricsReporter) BlockPeer(p peer.ID) {
+	r.peers.WithLabelValues(resultBlocked).Inc()
+}
+
+func (r *MetricsReporter) AllowProtocol(proto protocol.ID) {
+	r.protocols.WithLabelValues(string(proto), resultAllowed).Inc()
+}
+
+func (r *MetricsReporter) BlockProtocol(proto protocol.ID) {
+	r.protocols.WithLabelValues(string(proto), resultBlocked).Inc()
+}
+
+func (r *MetricsReporter) BlockProtocolPeer(proto protocol.ID, p peer.ID) {
+	r.protocolPeers.WithLabelValues(string(proto)).Inc()
+}
+
+func (r *MetricsReporter) AllowService(svc string) {
+	r.services.WithLabelValues(svc, resultAllowed).Inc()
+}
+
+func (r *MetricsReporter) BlockService(svc string) {
+	r.services.WithLabelValues(svc, resultBlocked).Inc()
+}
+
+func (r *MetricsReporter) BlockServicePeer(svc string, p peer.ID) {
+	r.servicePeers.WithLabelValues(svc).Inc()
+}
+
+func (r *MetricsReporter) AllowMemory(size int) {
+	r.memory.WithLabelValues(resultAllowed).Inc()
+}
+
+func (r *MetricsReporter) BlockMemory(size int) {
+	r.memory.WithLabelValues(resultBlocked).Inc()
+}
+
+func (r *MetricsReporter) ShadowBlock(err *rcmgr.ErrLimitExceeded) {
+	r.shadowBlocks.WithLabelValues(string(err.Kind), string(err.Resource)).Inc()
+}
+
+func (r *MetricsReporter) BlockRate(err *rcmgr.ErrLimitExceeded) {
+	r.rateBlocks.WithLabelValues(string(err.Kind), string(err.Resource)).Inc()
+}
@@ -0,0 +1,240 @@
+package obs
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	rcmgr "github.com/libp2p/go-libp2p-resource-manager"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// otherPeers is the name label of the aggregate usage of peers not reported individually.
+const otherPeers = "other"
+
+// StatCollector is a prometheus.Collector that exports the current resource usage and the
+// configured limits of the resource manager scopes as gauges. The resource manager state is
+// polled every time the collector is scraped.
+//
+// Usage is exported for the system, transient, allowlisted, service, protocol and peer scopes;
+// limits are exported for all but the peer scopes. As there can be a very large number of peers,
+// only the top peers by resource usage are reported individually; the usage of the rest is
+// aggregated with the name label "other".
+type StatCollector struct {
+	mgr    network.ResourceManager
+	state  rcmgr.ResourceManagerState
+	lookup rcmgr.ResourceManagerLookup
+
+	topPeers int
+
+	usage *prometheus.Desc
+	limit *prometheus.Desc
+	peers *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*StatCollector)(nil)
+
+// Option is a StatCollector option.
+type Option func(*StatCollector) error
+
+// WithTopPeers is a StatCollector option that sets the number of peers with the highest resource
+// usage that are reported individually. Peers are ranked by memory, and then by number of streams
+// and connections. The default is 0, which only reports the aggregate usage of all peers.
+func WithTopPeers(n int) Option {
+	return func(c *StatCollector) error {
+		if n < 0 {
+			return fmt.Errorf("invalid number of top peers: %d", n)
+		}
+		c.topPeers = n
+		return nil
+	}
+}
+
+// NewStatCollector creates a new collector for the state of a resource manager; the resource
+// manager must implement the rcmgr.ResourceManagerState and rcmgr.ResourceManagerLookup traits.
+func NewStatCollector(mgr network.ResourceManager, opts ...Option) (*StatCollector, error) {
+	state, ok := mgr.(rcmgr.ResourceManagerState)
+	if !ok {
+		return nil, fmt.Errorf("resource manager does not implement ResourceManagerState")
+	}
+	lookup, ok := mgr.(rcmgr.ResourceManagerLookup)
+	if !ok {
+		return nil, fmt.Errorf("resource manager does not implement ResourceManagerLookup")
+	}
+
+	c := &StatCollector{
+		mgr:    mgr,
+		state:  state,
+		lookup: lookup,
+		usage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "usage"),
+			"Current resource usage of a scope.",
+			[]string{"scope", "name", "resource"}, nil),
+		limit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "limit"),
+			"Configured resource limit of a scope.",
+			[]string{"scope", "name", "resource"}, nil),
+		peers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "peers"),
+			"Number of peer scopes.",
+			nil, nil),
+	}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *StatCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.usage
+	ch <- c.limit
+	ch <- c.peers
+}
+
+func (c *StatCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.state.Stat()
+
+	c.collectUsage(ch, rcmgr.ScopeKindSystem, "", stat.System)
+	c.collectUsage(ch, rcmgr.ScopeKindTransient, "", stat.Transient)
+	c.collectUsage(ch, rcmgr.ScopeKindAllowlistedSystem, "", stat.AllowlistedSystem)
+	c.collectUsage(ch, rcmgr.ScopeKindAllowlistedTransient, "", stat.AllowlistedTransient)
+	for svc, st := range stat.Services {
+		c.collectUsage(ch, rcmgr.ScopeKindService, svc, st)
+	}
+	for proto, st := range stat.Protocols {
+		c.collectUsage(ch, rcmgr.ScopeKindProtocol, string(proto), st)
+	}
+	c.collectPeers(ch, stat.Peers)
+
+	c.viewLimit(ch, rcmgr.ScopeKindSystem, "", c.mgr.ViewSystem)
+	c.viewLimit(ch, rcmgr.ScopeKindTransient, "", c.mgr.ViewTransient)
+	if al, ok := c.mgr.(rcmgr.ResourceManagerAllowlist); ok {
+		c.viewLimit(ch, rcmgr.ScopeKindAllowlistedSystem, "", al.ViewAllowlistedSystem)
+		c.viewLimit(ch, rcmgr.ScopeKindAllowlistedTransient, "", al.ViewAllowlistedTransient)
+	}
+	// the scopes may have been garbage collected since the stat was taken; they must not be
+	// recreated by viewing them
+	for svc := range stat.Services {
+		_, _ = c.lookup.LookupService(svc, func(s network.ServiceScope) error {
+			c.collectLimit(ch, rcmgr.ScopeKindService, svc, s)
+			return nil
+		})
+	}
+	for proto := range stat.Protocols {
+		_, _ = c.lookup.LookupProtocol(proto, func(s network.ProtocolScope) error {
+			c.collectLimit(ch, rcmgr.ScopeKindProtocol, string(proto), s)
+			return nil
+		})
+	}
+}
+
+func (c *StatCollector) collectPeers(ch chan<- prometheus.Metric, peers map[peer.ID]network.ScopeStat) {
+	ch <- prometheus.MustNewConstMetric(c.peers, prometheus.GaugeValue, float64(len(peers)))
+
+	ranked := make([]peer.ID, 0, len(peers))
+	for p := range peers {
+		ranked = append(ranked, p)
+	}
+
+	top := c.topPeers
+	if top > len(ranked) {
+		top = len(ranked)
+	}
+	if top > 0 {
+		sort.Slice(ranked, func(i, j int) bool {
+			si, sj := peers[ranked[i]], peers[ranked[j]]
+			if si.Memory != sj.Memory {
+				return si.Memory > sj.Memory
+			}
+			ni := si.NumStreamsInbound + si.NumStreamsOutbound + si.NumConnsInbound + si.NumConnsOutbound
+			nj := sj.NumStreamsInbound + sj.NumStreamsOutbound + sj.NumConnsInbound + sj.NumConnsOutbound
+			if ni != nj {
+				return ni > nj
+			}
+			return ranked[i] < ranked[j]
+		})
+	}
+
+	for _, p := range ranked[:top] {
+		c.collectUsage(ch, rcmgr.ScopeKindPeer, p.String(), peers[p])
+	}
+
+	var other network.ScopeStat
+	for _, p := range ranked[top:] {
+		st := peers[p]
+		other.Memory += st.Memory
+		other.NumStreamsInbound += st.NumStreamsInbound
+		other.NumStreamsOutbound += st.NumStreamsOutbound
+		other.NumConnsInbound += st.NumConnsInbound
+		other.NumConnsOutbound += st.NumConnsOutbound
+		other.NumFD += st.NumFD
+	}
+	c.collectUsage(ch, rcmgr.ScopeKindPeer, otherPeers, other)
+}
+
+func (c *StatCollector) collectUsage(ch chan<- prometheus.Metric, kind rcmgr.ScopeKind, name string, st network.ScopeStat) {
+	for _, v := range []struct {
+		resource rcmgr.Resource
+		value    int64
+	}{
+		{rcmgr.ResourceMemory, st.Memory},
+		{rcmgr.ResourceStreamsInbound, int64(st.NumStreamsInbound)},
+		{rcmgr.ResourceStreamsOutbound, int64(st.NumStreamsOutbound)},
+		{rcmgr.ResourceStreams, int64(st.NumStreamsInbound + st.NumStreamsOutbound)},
+		{rcmgr.ResourceConnsInbound, int64(st.NumConnsInbound)},
+		{rcmgr.ResourceConnsOutbound, int64(st.NumConnsOutbound)},
+		{rcmgr.ResourceConns, int64(st.NumConnsInbound + st.NumConnsOutbound)},
+		{rcmgr.ResourceFD, int64(st.NumFD)},
+	} {
+		ch <- prometheus.MustNewConstMetric(c.usage, prometheus.GaugeValue, float64(v.value),
+			string(kind), name, string(v.resource))
+	}
+}
+
+func (c *StatCollector) viewLimit(ch chan<- prometheus.Metric, kind rcmgr.ScopeKind, name string, view func(func(network.ResourceScope) error) error) {
+	_ = view(func(s network.ResourceScope) error {
+		c.collectLimit(ch, kind, name, s)
+		return nil
+	})
+}
+
+func (c *StatCollector) collectLimit(ch chan<- prometheus.Metric, kind rcmgr.ScopeKind, name string, s interface{}) {
+	ls, ok := s.(rcmgr.ResourceScopeLimiter)
+	if !ok {
+		return
+	}
+	l := ls.Limit()
+	if l == nil {
+		return
+	}
+
+	for _, v := range []struct {
+		resource rcmgr.Resource
+		value    int64
+	}{
+		{rcmgr.ResourceMemory, l.GetMemoryLimit()},
+		{rcmgr.ResourceStreamsInbound, int64(l.GetStreamLimit(network.DirInbound))},
+		{rcmgr.ResourceStreamsOutbound, int64(l.GetStreamLimit(network.DirOutbound))},
+		{rcmgr.ResourceStreams, int64(l.GetStreamTotalLimit())},
+		{rcmgr.ResourceConnsInbound, int64(l.GetConnLimit(network.DirInbound))},
+		{rcmgr.ResourceConnsOutbound, int64(l.GetConnLimit(network.DirOutbound))},
+		{rcmgr.ResourceConns, int64(l.GetConnTotalLimit())},
+		{rcmgr.ResourceFD, int64(l.GetFDLimit())},
+	} {
+		value := float64(v.value)
+		if v.value == math.MaxInt64 || v.value == int64(math.MaxInt) {
+			// unlimited
+			value = math.Inf(1)
+		}
+		ch <- prometheus.MustNewConstMetric(c.limit, prometheus.GaugeValue, value,
+			string(kind), name, string(v.resource))
+	}
+}
@@ -3,6 +3,8 @@ package rcmgr
 import (
 	"context"
 	"fmt"
+	"net"
+	"reflect"
 	"sync"
 	"time"
 
@@ -10,20 +12,31 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
 
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+
 	logging "github.com/ipfs/go-log/v2"
 )
 
 var log = logging.Logger("rcmgr")
 
 type resourceManager struct {
-	limits Limiter
+	limitsMx sync.RWMutex
+	limits   Limiter
 
 	trace   *trace
 	metrics *metrics
+	watch   *limitWatch
+	shadow  bool
+	clock   func() time.Time
 
 	system    *systemScope
 	transient *transientScope
 
+	allowlist            *Allowlist
+	allowlistedSystem    *systemScope
+	allowlistedTransient *transientScope
+
 	cancelCtx context.Context
 	cancel    func()
 	wg        sync.WaitGroup
@@ -33,9 +46,20 @@ type resourceManager struct {
 	proto map[protocol.ID]*protocolScope
 	peer  map[peer.ID]*peerScope
 
+	ip     map[string]*ipScope
+	subnet map[string]*ipScope
+
 	stickyProto map[protocol.ID]struct{}
 	stickyPeer  map[peer.ID]struct{}
 
+	// classMx protects limiterPeerClass and peerClass; it is never held while acquiring another
+	// lock. limiterPeerClass holds the assignments of the current limiter, and peerClass the
+	// assignments made through AssignPeerClass and UnassignPeerClass, which take precedence; the
+	// empty class marks a peer removed from its class.
+	classMx          sync.RWMutex
+	limiterPeerClass map[peer.ID]string
+	peerClass        map[peer.ID]string
+
 	connId, streamId int64
 }
 
@@ -86,13 +110,27 @@ type peerScope struct {
 
 var _ network.PeerScope = (*peerScope)(nil)
 
+// ipScope is the scope of a remote IP address or subnet, constraining all connections from it.
+type ipScope struct {
+	*resourceScope
+
+	ip     net.IP
+	subnet *net.IPNet // nil for the scope of a single IP address
+}
+
+var _ network.ResourceScope = (*ipScope)(nil)
+
 type connectionScope struct {
 	*resourceScope
 
-	dir   network.Direction
-	usefd bool
-	rcmgr *resourceManager
-	peer  *peerScope
+	dir         network.Direction
+	usefd       bool
+	endpoint    multiaddr.Multiaddr
+	allowlisted bool
+	rcmgr       *resourceManager
+	peer        *peerScope
+	ip          *ipScope
+	subnet      *ipScope
 }
 
 var _ network.ConnScope = (*connectionScope)(nil)
@@ -107,6 +145,10 @@ type streamScope struct {
 	svc   *serviceScope
 	proto *protocolScope
 
+	// protoID is the protocol of the stream, which differs from the protocol of its protocol
+	// scope when the scope is shared by a family of protocols.
+	protoID protocol.ID
+
 	peerProtoScope *resourceScope
 	peerSvcScope   *resourceScope
 }
@@ -116,12 +158,54 @@ var _ network.StreamManagementScope = (*streamScope)(nil)
 
 type Option func(*resourceManager) error
 
+// WithAllowlistedPeers is a resource manager option that adds peers to the allowlist.
+func WithAllowlistedPeers(peers ...peer.ID) Option {
+	return func(r *resourceManager) error {
+		for _, p := range peers {
+			r.allowlist.AddPeer(p)
+		}
+		return nil
+	}
+}
+
+// WithAllowlistedAddrs is a resource manager option that adds multiaddrs to the allowlist.
+func WithAllowlistedAddrs(addrs ...multiaddr.Multiaddr) Option {
+	return func(r *resourceManager) error {
+		for _, a := range addrs {
+			if err := r.allowlist.Add(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithShadowMode is a resource manager option that puts all scopes in shadow mode: limits are
+// checked and violations are reported to the trace and metrics as would-be blocks, but
+// reservations are allowed to proceed. Resource usage is accounted as usual.
+func WithShadowMode() Option {
+	return func(r *resourceManager) error {
+		r.shadow = true
+		return nil
+	}
+}
+
 func NewResourceManager(limits Limiter, opts ...Option) (network.ResourceManager, error) {
 	r := &resourceManager{
-		limits: limits,
-		svc:    make(map[string]*serviceScope),
-		proto:  make(map[protocol.ID]*protocolScope),
-		peer:   make(map[peer.ID]*peerScope),
+		limits:    limits,
+		allowlist: NewAllowlist(),
+		svc:       make(map[string]*serviceScope),
+		proto:     make(map[protocol.ID]*protocolScope),
+		peer:      make(map[peer.ID]*peerScope),
+		ip:        make(map[string]*ipScope),
+		subnet:    make(map[string]*ipScope),
+	}
+
+	if al, ok := limits.(allowlistLimiter); ok && al.GetAllowlist() != nil {
+		r.allowlist.merge(al.GetAllowlist())
+	}
+	if cl, ok := limits.(peerClassLimiter); ok {
+		r.setLimiterPeerClasses(cl.GetPeerClassAssignments())
 	}
 
 	for _, opt := range opts {
@@ -138,15 +222,31 @@ func NewResourceManager(limits Limiter, opts ...Option) (network.ResourceManager
 	r.system.IncRef()
 	r.transient = newTransientScope(limits.GetTransientLimits(), r)
 	r.transient.IncRef()
+	r.allowlistedSystem = newAllowlistedSystemScope(allowlistedSystemLimits(limits), r)
+	r.allowlistedSystem.IncRef()
+	r.allowlistedTransient = newAllowlistedTransientScope(allowlistedTransientLimits(limits), r)
+	r.allowlistedTransient.IncRef()
 
 	r.cancelCtx, r.cancel = context.WithCancel(context.Background())
 
 	r.wg.Add(1)
 	go r.background()
 
+	if r.watch != nil {
+		r.wg.Add(1)
+		go r.watchLimitConfig()
+	}
+
 	return r, nil
 }
 
+func (r *resourceManager) limiter() Limiter {
+	r.limitsMx.RLock()
+	defer r.limitsMx.RUnlock()
+
+	return r.limits
+}
+
 func (r *resourceManager) ViewSystem(f func(network.ResourceScope) error) error {
 	return f(r.system)
 }
@@ -155,6 +255,18 @@ func (r *resourceManager) ViewTransient(f func(network.ResourceScope) error) err
 	return f(r.transient)
 }
 
+func (r *resourceManager) ViewAllowlistedSystem(f func(network.ResourceScope) error) error {
+	return f(r.allowlistedSystem)
+}
+
+func (r *resourceManager) ViewAllowlistedTransient(f func(network.ResourceScope) error) error {
+	return f(r.allowlistedTransient)
+}
+
+func (r *resourceManager) Allowlist() *Allowlist {
+	return r.allowlist
+}
+
 func (r *resourceManager) ViewService(srv string, f func(network.ServiceScope) error) error {
 	s := r.getServiceScope(srv)
 	defer s.DecRef()
@@ -176,13 +288,64 @@ func (r *resourceManager) ViewPeer(p peer.ID, f func(network.PeerScope) error) e
 	return f(s)
 }
 
+func (r *resourceManager) LookupService(svc string, f func(network.ServiceScope) error) (bool, error) {
+	r.mx.Lock()
+	s, ok := r.svc[svc]
+	if ok {
+		s.IncRef()
+	}
+	r.mx.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	defer s.DecRef()
+
+	return true, f(s)
+}
+
+func (r *resourceManager) LookupProtocol(proto protocol.ID, f func(network.ProtocolScope) error) (bool, error) {
+	r.mx.Lock()
+	if fl, ok := r.limiter().(protocolFamilyLimiter); ok {
+		proto = fl.GetProtocolFamily(proto)
+	}
+	s, ok := r.proto[proto]
+	if ok {
+		s.IncRef()
+	}
+	r.mx.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	defer s.DecRef()
+
+	return true, f(s)
+}
+
+func (r *resourceManager) LookupPeer(p peer.ID, f func(network.PeerScope) error) (bool, error) {
+	r.mx.Lock()
+	s, ok := r.peer[p]
+	if ok {
+		s.IncRef()
+	}
+	r.mx.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	defer s.DecRef()
+
+	return true, f(s)
+}
+
 func (r *resourceManager) getServiceScope(svc string) *serviceScope {
 	r.mx.Lock()
 	defer r.mx.Unlock()
 
 	s, ok := r.svc[svc]
 	if !ok {
-		s = newServiceScope(svc, r.limits.GetServiceLimits(svc), r)
+		s = newServiceScope(svc, r.limiter().GetServiceLimits(svc), r)
 		r.svc[svc] = s
 	}
 
@@ -194,9 +357,14 @@ func (r *resourceManager) getProtocolScope(proto protocol.ID) *protocolScope {
 	r.mx.Lock()
 	defer r.mx.Unlock()
 
+	// protocols of a family share the scope of the family
+	if fl, ok := r.limiter().(protocolFamilyLimiter); ok {
+		proto = fl.GetProtocolFamily(proto)
+	}
+
 	s, ok := r.proto[proto]
 	if !ok {
-		s = newProtocolScope(proto, r.limits.GetProtocolLimits(proto), r)
+		s = newProtocolScope(proto, r.limiter().GetProtocolLimits(proto), r)
 		r.proto[proto] = s
 	}
 
@@ -220,7 +388,7 @@ func (r *resourceManager) getPeerScope(p peer.ID) *peerScope {
 
 	s, ok := r.peer[p]
 	if !ok {
-		s = newPeerScope(p, r.limits.GetPeerLimits(p), r)
+		s = newPeerScope(p, r.peerLimits(r.limiter(), p), r)
 		r.peer[p] = s
 	}
 
@@ -228,6 +396,62 @@ func (r *resourceManager) getPeerScope(p peer.ID) *peerScope {
 	return s
 }
 
+// getAddrScopes returns the IP and subnet scopes for a remote endpoint; either is nil if the
+// endpoint has no IP address or the limiter does not constrain it.
+func (r *resourceManager) getAddrScopes(endpoint multiaddr.Multiaddr) (ips, subnets *ipScope) {
+	if endpoint == nil {
+		return nil, nil
+	}
+
+	ip, err := manet.ToIP(endpoint)
+	if err != nil {
+		return nil, nil
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	limits, ok := r.limiter().(ipLimiter)
+	if !ok {
+		return nil, nil
+	}
+
+	if l := limits.GetIPLimits(ip); l != nil {
+		key := ip.String()
+		ips = r.ip[key]
+		if ips == nil {
+			ips = newIPScope(ip, nil, l, r)
+			r.ip[key] = ips
+		}
+		ips.IncRef()
+	}
+
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 8 * net.IPv4len
+	}
+
+	prefix := limits.GetSubnetPrefixLength(ip)
+	if prefix <= 0 || prefix > bits {
+		return ips, nil
+	}
+
+	mask := net.CIDRMask(prefix, bits)
+	subnet := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	if l := limits.GetSubnetLimits(subnet); l != nil {
+		key := subnet.String()
+		subnets = r.subnet[key]
+		if subnets == nil {
+			subnets = newIPScope(ip, subnet, l, r)
+			r.subnet[key] = subnets
+		}
+		subnets.IncRef()
+	}
+
+	return ips, subnets
+}
+
 func (r *resourceManager) setStickyPeer(p peer.ID) {
 	r.mx.Lock()
 	defer r.mx.Unlock()
@@ -256,12 +480,41 @@ func (r *resourceManager) nextStreamId() int64 {
 }
 
 func (r *resourceManager) OpenConnection(dir network.Direction, usefd bool) (network.ConnManagementScope, error) {
-	conn := newConnectionScope(dir, usefd, r.limits.GetConnLimits(), r)
+	return r.OpenConnectionWithEndpoint(dir, usefd, nil)
+}
+
+// OpenConnectionWithEndpoint opens a connection scope for a connection with the specified remote
+// endpoint. The connection is constrained by the scopes of the endpoint IP address and subnet,
+// for its whole lifetime. If the connection is blocked and the endpoint is allowlisted, the
+// connection is accounted against the allowlisted scopes instead.
+func (r *resourceManager) OpenConnectionWithEndpoint(dir network.Direction, usefd bool, endpoint multiaddr.Multiaddr) (network.ConnManagementScope, error) {
+	ip, subnet := r.getAddrScopes(endpoint)
+	conn := newConnectionScope(dir, usefd, r.limiter().GetConnLimits(), ip, subnet, r)
+	conn.endpoint = endpoint
+	// we have the references in edges
+	if ip != nil {
+		ip.DecRef()
+	}
+	if subnet != nil {
+		subnet.DecRef()
+	}
 
 	if err := conn.AddConn(dir, usefd); err != nil {
 		conn.Done()
-		r.metrics.BlockConn(dir, usefd)
-		return nil, err
+
+		if endpoint == nil || !r.allowlist.Allowed(endpoint) {
+			r.metrics.BlockConn(dir, usefd)
+			return nil, err
+		}
+
+		conn = newAllowlistedConnectionScope(dir, usefd, r.limiter().GetConnLimits(), r)
+		conn.endpoint = endpoint
+
+		if err := conn.AddConn(dir, usefd); err != nil {
+			conn.Done()
+			r.metrics.BlockConn(dir, usefd)
+			return nil, err
+		}
 	}
 
 	r.metrics.AllowConn(dir, usefd)
@@ -270,7 +523,7 @@ func (r *resourceManager) OpenConnection(dir network.Direction, usefd bool) (net
 
 func (r *resourceManager) OpenStream(p peer.ID, dir network.Direction) (network.StreamManagementScope, error) {
 	peer := r.getPeerScope(p)
-	stream := newStreamScope(dir, r.limits.GetStreamLimits(p), peer, r)
+	stream := newStreamScope(dir, r.limiter().GetStreamLimits(p), peer, r)
 	peer.DecRef() // we have the reference in edges
 
 	err := stream.AddStream(dir)
@@ -287,9 +540,8 @@ func (r *resourceManager) OpenStream(p peer.ID, dir network.Direction) (network.
 func (r *resourceManager) Close() error {
 	r.cancel()
 	r.wg.Wait()
-	r.trace.Close()
 
-	return nil
+	return r.trace.Close()
 }
 
 func (r *resourceManager) background() {
@@ -338,6 +590,20 @@ func (r *resourceManager) gc() {
 		}
 	}
 
+	for key, s := range r.ip {
+		if s.IsUnused() {
+			s.Done()
+			delete(r.ip, key)
+		}
+	}
+
+	for key, s := range r.subnet {
+		if s.IsUnused() {
+			s.Done()
+			delete(r.subnet, key)
+		}
+	}
+
 	for _, s := range r.svc {
 		s.Lock()
 		for _, p := range deadPeers {
@@ -363,26 +629,49 @@ func (r *resourceManager) gc() {
 	}
 }
 
+func (r *resourceManager) newResourceScope(limit Limit, edges []*resourceScope, name string, kind ScopeKind) *resourceScope {
+	s := newResourceScope(limit, edges, name, r.trace, r.metrics)
+	s.kind = kind
+	s.shadow = r.shadow
+	s.rc.clock = r.clock
+	return s
+}
+
 func newSystemScope(limit Limit, rcmgr *resourceManager) *systemScope {
 	return &systemScope{
-		resourceScope: newResourceScope(limit, nil, "system", rcmgr.trace, rcmgr.metrics),
+		resourceScope: rcmgr.newResourceScope(limit, nil, "system", ScopeKindSystem),
 	}
 }
 
 func newTransientScope(limit Limit, rcmgr *resourceManager) *transientScope {
 	return &transientScope{
-		resourceScope: newResourceScope(limit,
+		resourceScope: rcmgr.newResourceScope(limit,
 			[]*resourceScope{rcmgr.system.resourceScope},
-			"transient", rcmgr.trace, rcmgr.metrics),
+			"transient", ScopeKindTransient),
 		system: rcmgr.system,
 	}
 }
 
+func newAllowlistedSystemScope(limit Limit, rcmgr *resourceManager) *systemScope {
+	return &systemScope{
+		resourceScope: rcmgr.newResourceScope(limit, nil, "allowlisted-system", ScopeKindAllowlistedSystem),
+	}
+}
+
+func newAllowlistedTransientScope(limit Limit, rcmgr *resourceManager) *transientScope {
+	return &transientScope{
+		resourceScope: rcmgr.newResourceScope(limit,
+			[]*resourceScope{rcmgr.allowlistedSystem.resourceScope},
+			"allowlisted-transient", ScopeKindAllowlistedTransient),
+		system: rcmgr.allowlistedSystem,
+	}
+}
+
 func newServiceScope(name string, limit Limit, rcmgr *resourceManager) *serviceScope {
 	return &serviceScope{
-		resourceScope: newResourceScope(limit,
+		resourceScope: rcmgr.newResourceScope(limit,
 			[]*resourceScope{rcmgr.system.resourceScope},
-			fmt.Sprintf("service:%s", name), rcmgr.trace, rcmgr.metrics),
+			fmt.Sprintf("service:%s", name), ScopeKindService),
 		name:  name,
 		rcmgr: rcmgr,
 	}
@@ -390,9 +679,9 @@ func newServiceScope(name string, limit Limit, rcmgr *resourceManager) *serviceS
 
 func newProtocolScope(proto protocol.ID, limit Limit, rcmgr *resourceManager) *protocolScope {
 	return &protocolScope{
-		resourceScope: newResourceScope(limit,
+		resourceScope: rcmgr.newResourceScope(limit,
 			[]*resourceScope{rcmgr.system.resourceScope},
-			fmt.Sprintf("protocol:%s", proto), rcmgr.trace, rcmgr.metrics),
+			fmt.Sprintf("protocol:%s", proto), ScopeKindProtocol),
 		proto: proto,
 		rcmgr: rcmgr,
 	}
@@ -400,30 +689,63 @@ func newProtocolScope(proto protocol.ID, limit Limit, rcmgr *resourceManager) *p
 
 func newPeerScope(p peer.ID, limit Limit, rcmgr *resourceManager) *peerScope {
 	return &peerScope{
-		resourceScope: newResourceScope(limit,
+		resourceScope: rcmgr.newResourceScope(limit,
 			[]*resourceScope{rcmgr.system.resourceScope},
-			fmt.Sprintf("peer:%s", p), rcmgr.trace, rcmgr.metrics),
+			fmt.Sprintf("peer:%s", p), ScopeKindPeer),
 		peer:  p,
 		rcmgr: rcmgr,
 	}
 }
 
-func newConnectionScope(dir network.Direction, usefd bool, limit Limit, rcmgr *resourceManager) *connectionScope {
+func newIPScope(ip net.IP, subnet *net.IPNet, limit Limit, rcmgr *resourceManager) *ipScope {
+	name, kind := fmt.Sprintf("ip:%s", ip), ScopeKindIP
+	if subnet != nil {
+		name, kind = fmt.Sprintf("subnet:%s", subnet), ScopeKindSubnet
+	}
+
+	return &ipScope{
+		resourceScope: rcmgr.newResourceScope(limit,
+			[]*resourceScope{rcmgr.system.resourceScope},
+			name, kind),
+		ip:     ip,
+		subnet: subnet,
+	}
+}
+
+func newConnectionScope(dir network.Direction, usefd bool, limit Limit, ip, subnet *ipScope, rcmgr *resourceManager) *connectionScope {
+	conn := &connectionScope{
+		dir:    dir,
+		usefd:  usefd,
+		rcmgr:  rcmgr,
+		ip:     ip,
+		subnet: subnet,
+	}
+
+	edges := conn.addrEdges()
+	edges = append(edges, rcmgr.transient.resourceScope, rcmgr.system.resourceScope)
+	conn.resourceScope = rcmgr.newResourceScope(limit, edges,
+		fmt.Sprintf("conn-%d", rcmgr.nextConnId()), ScopeKindConn)
+
+	return conn
+}
+
+func newAllowlistedConnectionScope(dir network.Direction, usefd bool, limit Limit, rcmgr *resourceManager) *connectionScope {
 	return &connectionScope{
-		resourceScope: newResourceScope(limit,
-			[]*resourceScope{rcmgr.transient.resourceScope, rcmgr.system.resourceScope},
-			fmt.Sprintf("conn-%d", rcmgr.nextConnId()), rcmgr.trace, rcmgr.metrics),
-		dir:   dir,
-		usefd: usefd,
-		rcmgr: rcmgr,
+		resourceScope: rcmgr.newResourceScope(limit,
+			[]*resourceScope{rcmgr.allowlistedTransient.resourceScope, rcmgr.allowlistedSystem.resourceScope},
+			fmt.Sprintf("conn-%d", rcmgr.nextConnId()), ScopeKindConn),
+		dir:         dir,
+		usefd:       usefd,
+		allowlisted: true,
+		rcmgr:       rcmgr,
 	}
 }
 
 func newStreamScope(dir network.Direction, limit Limit, peer *peerScope, rcmgr *resourceManager) *streamScope {
 	return &streamScope{
-		resourceScope: newResourceScope(limit,
+		resourceScope: rcmgr.newResourceScope(limit,
 			[]*resourceScope{peer.resourceScope, rcmgr.transient.resourceScope, rcmgr.system.resourceScope},
-			fmt.Sprintf("stream-%d", rcmgr.nextStreamId()), rcmgr.trace, rcmgr.metrics),
+			fmt.Sprintf("stream-%d", rcmgr.nextStreamId()), ScopeKindStream),
 		dir:   dir,
 		rcmgr: peer.rcmgr,
 		peer:  peer,
@@ -444,13 +766,13 @@ func (s *serviceScope) getPeerScope(p peer.ID) *resourceScope {
 		return ps
 	}
 
-	l := s.rcmgr.limits.GetServicePeerLimits(s.name)
+	l := s.rcmgr.servicePeerLimits(s.rcmgr.limiter(), s.name, p)
 
 	if s.peers == nil {
 		s.peers = make(map[peer.ID]*resourceScope)
 	}
 
-	ps = newResourceScope(l, nil, fmt.Sprintf("%s.peer:%s", s.name, p), s.rcmgr.trace, s.rcmgr.metrics)
+	ps = s.rcmgr.newResourceScope(l, nil, fmt.Sprintf("%s.peer:%s", s.name, p), ScopeKindServicePeer)
 	s.peers[p] = ps
 
 	ps.IncRef()
@@ -471,13 +793,13 @@ func (s *protocolScope) getPeerScope(p peer.ID) *resourceScope {
 		return ps
 	}
 
-	l := s.rcmgr.limits.GetProtocolPeerLimits(s.proto)
+	l := s.rcmgr.protocolPeerLimits(s.rcmgr.limiter(), s.proto, p)
 
 	if s.peers == nil {
 		s.peers = make(map[peer.ID]*resourceScope)
 	}
 
-	ps = newResourceScope(l, nil, fmt.Sprintf("%s.peer:%s", s.name, p), s.rcmgr.trace, s.rcmgr.metrics)
+	ps = s.rcmgr.newResourceScope(l, nil, fmt.Sprintf("%s.peer:%s", s.name, p), ScopeKindProtocolPeer)
 	s.peers[p] = ps
 
 	ps.IncRef()
@@ -488,6 +810,30 @@ func (s *peerScope) Peer() peer.ID {
 	return s.peer
 }
 
+// limit returns the limit for the scope from a limiter, or nil if the limiter does not limit it.
+func (s *ipScope) limit(limits Limiter) Limit {
+	il, ok := limits.(ipLimiter)
+	if !ok {
+		return nil
+	}
+	if s.subnet != nil {
+		return il.GetSubnetLimits(s.subnet)
+	}
+	return il.GetIPLimits(s.ip)
+}
+
+// addrEdges returns the scopes of the connection remote address.
+func (s *connectionScope) addrEdges() []*resourceScope {
+	var edges []*resourceScope
+	if s.ip != nil {
+		edges = append(edges, s.ip.resourceScope)
+	}
+	if s.subnet != nil {
+		edges = append(edges, s.subnet.resourceScope)
+	}
+	return edges
+}
+
 func (s *connectionScope) PeerScope() network.PeerScope {
 	s.Lock()
 	defer s.Unlock()
@@ -507,20 +853,81 @@ func (s *connectionScope) SetPeer(p peer.ID) error {
 	if s.peer != nil {
 		return fmt.Errorf("connection scope already attached to a peer")
 	}
+	if s.allowlisted {
+		return s.setAllowlistedPeer(p)
+	}
+
 	s.peer = s.rcmgr.getPeerScope(p)
 
 	// juggle resources from transient scope to peer scope
 	stat := s.resourceScope.rc.stat()
-	if err := s.peer.ReserveForChild(stat); err != nil {
+	if err := s.peer.ReserveForChild(s.name, stat); err != nil {
 		s.peer.DecRef()
 		s.peer = nil
 		s.rcmgr.metrics.BlockPeer(p)
 		return err
 	}
 
-	s.rcmgr.transient.ReleaseForChild(stat)
+	s.rcmgr.transient.ReleaseForChild(s.name, stat)
 	s.rcmgr.transient.DecRef() // removed from edges
 
+	// update edges
+	edges := []*resourceScope{s.peer.resourceScope}
+	edges = append(edges, s.addrEdges()...)
+	edges = append(edges, s.rcmgr.system.resourceScope)
+	s.resourceScope.edges = edges
+
+	s.rcmgr.metrics.AllowPeer(p)
+	return nil
+}
+
+// setAllowlistedPeer attaches an allowlisted connection to a peer; it must be called with the
+// scope lock held. If the peer is allowed to use the allowlisted scopes for the connection
+// endpoint, resources are moved from the allowlisted transient scope to the peer scope.
+// Otherwise the connection is moved out of the allowlisted scopes altogether, and its resources
+// are accounted against the regular system scope.
+func (s *connectionScope) setAllowlistedPeer(p peer.ID) error {
+	s.peer = s.rcmgr.getPeerScope(p)
+
+	stat := s.resourceScope.rc.stat()
+	if err := s.peer.ReserveForChild(s.name, stat); err != nil {
+		s.peer.DecRef()
+		s.peer = nil
+		s.rcmgr.metrics.BlockPeer(p)
+		return err
+	}
+
+	if s.rcmgr.allowlist.AllowedPeerAndAddr(p, s.endpoint) {
+		s.rcmgr.allowlistedTransient.ReleaseForChild(s.name, stat)
+		s.rcmgr.allowlistedTransient.DecRef() // removed from edges
+
+		// update edges
+		edges := []*resourceScope{
+			s.peer.resourceScope,
+			s.rcmgr.allowlistedSystem.resourceScope,
+		}
+		s.resourceScope.edges = edges
+
+		s.rcmgr.metrics.AllowPeer(p)
+		return nil
+	}
+
+	// the peer is not allowlisted on this endpoint
+	if err := s.rcmgr.system.ReserveForChild(s.name, stat); err != nil {
+		s.peer.undoReserveForChild(s.name, stat)
+		s.peer.DecRef()
+		s.peer = nil
+		s.rcmgr.metrics.BlockPeer(p)
+		return err
+	}
+	s.rcmgr.system.IncRef() // added to edges
+
+	s.rcmgr.allowlistedTransient.ReleaseForChild(s.name, stat)
+	s.rcmgr.allowlistedTransient.DecRef() // removed from edges
+	s.rcmgr.allowlistedSystem.ReleaseForChild(s.name, stat)
+	s.rcmgr.allowlistedSystem.DecRef() // removed from edges
+	s.allowlisted = false
+
 	// update edges
 	edges := []*resourceScope{
 		s.peer.resourceScope,
@@ -556,7 +963,7 @@ func (s *streamScope) SetProtocol(proto protocol.ID) error {
 
 	// juggle resources from transient scope to protocol scope
 	stat := s.resourceScope.rc.stat()
-	if err := s.proto.ReserveForChild(stat); err != nil {
+	if err := s.proto.ReserveForChild(s.name, stat); err != nil {
 		s.proto.DecRef()
 		s.proto = nil
 		s.rcmgr.metrics.BlockProtocol(proto)
@@ -564,8 +971,8 @@ func (s *streamScope) SetProtocol(proto protocol.ID) error {
 	}
 
 	s.peerProtoScope = s.proto.getPeerScope(s.peer.peer)
-	if err := s.peerProtoScope.ReserveForChild(stat); err != nil {
-		s.proto.ReleaseForChild(stat)
+	if err := s.peerProtoScope.ReserveForChild(s.name, stat); err != nil {
+		s.proto.undoReserveForChild(s.name, stat)
 		s.proto.DecRef()
 		s.proto = nil
 		s.peerProtoScope.DecRef()
@@ -574,7 +981,7 @@ func (s *streamScope) SetProtocol(proto protocol.ID) error {
 		return err
 	}
 
-	s.rcmgr.transient.ReleaseForChild(stat)
+	s.rcmgr.transient.ReleaseForChild(s.name, stat)
 	s.rcmgr.transient.DecRef() // removed from edges
 
 	// update edges
@@ -586,10 +993,25 @@ func (s *streamScope) SetProtocol(proto protocol.ID) error {
 	}
 	s.resourceScope.edges = edges
 
+	s.protoID = proto
+	s.setLimit(s.rcmgr.limiter().GetProtocolStreamLimits(s.peer.peer, proto))
+
 	s.rcmgr.metrics.AllowProtocol(proto)
 	return nil
 }
 
+// setLimit replaces the limit of the stream once its protocol or service is known; the caller must
+// hold the stream scope lock. Resources already reserved by the stream are kept even if they
+// exceed the new limit, but further reservations are blocked until enough have been released.
+func (s *streamScope) setLimit(limit Limit) {
+	if limit == nil || reflect.DeepEqual(s.rc.limit, limit) {
+		return
+	}
+
+	s.rc.limit = limit
+	s.notifyWaiters()
+}
+
 func (s *streamScope) ServiceScope() network.ServiceScope {
 	s.Lock()
 	defer s.Unlock()
@@ -617,7 +1039,7 @@ func (s *streamScope) SetService(svc string) error {
 
 	// reserve resources in service
 	stat := s.resourceScope.rc.stat()
-	if err := s.svc.ReserveForChild(stat); err != nil {
+	if err := s.svc.ReserveForChild(s.name, stat); err != nil {
 		s.svc.DecRef()
 		s.svc = nil
 		s.rcmgr.metrics.BlockService(svc)
@@ -626,8 +1048,8 @@ func (s *streamScope) SetService(svc string) error {
 
 	// get the per peer service scope constraint, if any
 	s.peerSvcScope = s.svc.getPeerScope(s.peer.peer)
-	if err := s.peerSvcScope.ReserveForChild(stat); err != nil {
-		s.svc.ReleaseForChild(stat)
+	if err := s.peerSvcScope.ReserveForChild(s.name, stat); err != nil {
+		s.svc.undoReserveForChild(s.name, stat)
 		s.svc.DecRef()
 		s.svc = nil
 		s.peerSvcScope.DecRef()
@@ -647,6 +1069,8 @@ func (s *streamScope) SetService(svc string) error {
 	}
 	s.resourceScope.edges = edges
 
+	s.setLimit(s.rcmgr.limiter().GetServiceStreamLimits(s.peer.peer, s.protoID, svc))
+
 	s.rcmgr.metrics.AllowService(svc)
 	return nil
 }
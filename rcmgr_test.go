@@ -998,3 +998,48 @@ func TestResourceManager(t *testing.T) {
 	}
 
 }
+
+func TestLookupScopes(t *testing.T) {
+	nmgr, err := NewResourceManager(NewDefaultLimiter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	// looking up missing scopes does not create them
+	found, err := mgr.LookupService("svc", func(s network.ServiceScope) error { return nil })
+	if found || err != nil {
+		t.Fatalf("unexpected lookup result %v %v", found, err)
+	}
+	if found, _ := mgr.LookupProtocol("/test", func(s network.ProtocolScope) error { return nil }); found {
+		t.Fatal("unexpected protocol scope")
+	}
+	if found, _ := mgr.LookupPeer(peer.ID("A"), func(s network.PeerScope) error { return nil }); found {
+		t.Fatal("unexpected peer scope")
+	}
+	if len(mgr.ListServices()) != 0 || len(mgr.ListProtocols()) != 0 || len(mgr.ListPeers()) != 0 {
+		t.Fatal("lookup created scopes")
+	}
+
+	stream, err := mgr.OpenStream(peer.ID("A"), network.DirInbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Done()
+	if err := stream.SetProtocol("/test"); err != nil {
+		t.Fatal(err)
+	}
+
+	var name protocol.ID
+	found, err = mgr.LookupProtocol("/test", func(s network.ProtocolScope) error {
+		name = s.Protocol()
+		return nil
+	})
+	if !found || err != nil || name != "/test" {
+		t.Fatalf("unexpected lookup result %v %v %s", found, err, name)
+	}
+	if found, _ := mgr.LookupPeer(peer.ID("A"), func(s network.PeerScope) error { return nil }); !found {
+		t.Fatal("expected peer scope")
+	}
+}
@@ -1,8 +1,10 @@
 package rcmgr
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/network"
 )
@@ -16,6 +18,9 @@ type resources struct {
 	nfd                     int
 
 	memory int64
+
+	connRate, streamRate rateLimitedResources
+	clock                func() time.Time // the time source of rate limits; nil for the wall clock
 }
 
 // A resourceScope can be a DAG, where a downstream node is not allowed to outlive an upstream node
@@ -32,13 +37,18 @@ type resourceScope struct {
 	done   bool
 	refCnt int
 
-	rc    resources
-	owner *resourceScope   // set in span scopes, which define trees
-	edges []*resourceScope // set in DAG scopes, it's the linearized parent set
+	rc     resources
+	shadow bool             // in shadow mode limits are checked and reported, but not enforced
+	owner  *resourceScope   // set in span scopes, which define trees
+	edges  []*resourceScope // set in DAG scopes, it's the linearized parent set
+
+	name    string    // for debugging purposes
+	kind    ScopeKind // the class of the scope, for error reporting
+	trace   *trace    // debug tracing
+	metrics *metrics  // metrics collection
 
-	name    string   // for debugging purposes
-	trace   *trace   // debug tracing
-	metrics *metrics // metrics collection
+	waitq   *memoryWaitQueue              // callers blocked in ReserveMemoryCtx
+	waiting map[*memoryWaitQueue]struct{} // wait queues of descendant scopes constrained by this scope
 }
 
 var _ network.ResourceScope = (*resourceScope)(nil)
@@ -61,9 +71,11 @@ func newResourceScope(limit Limit, edges []*resourceScope, name string, trace *t
 
 func newResourceScopeSpan(owner *resourceScope) *resourceScope {
 	r := &resourceScope{
-		rc:      resources{limit: owner.rc.limit},
+		rc:      resources{limit: owner.rc.limit, clock: owner.rc.clock},
+		shadow:  owner.shadow,
 		owner:   owner,
 		name:    fmt.Sprintf("%s.span", owner.name),
+		kind:    ScopeKindSpan,
 		trace:   owner.trace,
 		metrics: owner.metrics,
 	}
@@ -76,10 +88,17 @@ func (rc *resources) checkMemory(rsvp int64, prio uint8) error {
 	// overflow check; this also has the side effect that we cannot reserve negative memory.
 	newmem := rc.memory + rsvp
 	limit := rc.limit.GetMemoryLimit()
-	threshold := (1 + int64(prio)) * limit / 256
+	// (1 + prio) * limit / 256, without overflowing for unlimited memory
+	threshold := limit/256*(1+int64(prio)) + limit%256*(1+int64(prio))/256
 
 	if newmem > threshold {
-		return network.ErrResourceLimitExceeded
+		return &ErrLimitExceeded{
+			Resource:  ResourceMemory,
+			Current:   rc.memory,
+			Requested: rsvp,
+			Limit:     limit,
+			Priority:  prio,
+		}
 	}
 
 	return nil
@@ -94,6 +113,11 @@ func (rc *resources) reserveMemory(size int64, prio uint8) error {
 	return nil
 }
 
+// forceReserveMemory reserves memory without checking the limit; it is used in shadow mode.
+func (rc *resources) forceReserveMemory(size int64) {
+	rc.memory += size
+}
+
 func (rc *resources) releaseMemory(size int64) {
 	rc.memory -= size
 
@@ -112,19 +136,50 @@ func (rc *resources) addStream(dir network.Direction) error {
 }
 
 func (rc *resources) addStreams(incount, outcount int) error {
-	if incount > 0 && rc.nstreamsIn+incount > rc.limit.GetStreamLimit(network.DirInbound) {
-		return fmt.Errorf("cannot reserve stream: %w", network.ErrResourceLimitExceeded)
+	if limit := rc.limit.GetStreamLimit(network.DirInbound); incount > 0 && rc.nstreamsIn+incount > limit {
+		return &ErrLimitExceeded{
+			Resource:  ResourceStreamsInbound,
+			Current:   int64(rc.nstreamsIn),
+			Requested: int64(incount),
+			Limit:     int64(limit),
+		}
+	}
+	if limit := rc.limit.GetStreamLimit(network.DirOutbound); outcount > 0 && rc.nstreamsOut+outcount > limit {
+		return &ErrLimitExceeded{
+			Resource:  ResourceStreamsOutbound,
+			Current:   int64(rc.nstreamsOut),
+			Requested: int64(outcount),
+			Limit:     int64(limit),
+		}
 	}
-	if outcount > 0 && rc.nstreamsOut+outcount > rc.limit.GetStreamLimit(network.DirOutbound) {
-		return fmt.Errorf("cannot reserve stream: %w", network.ErrResourceLimitExceeded)
+	if limit := rc.limit.GetStreamTotalLimit(); rc.nstreamsIn+incount+rc.nstreamsOut+outcount > limit {
+		return &ErrLimitExceeded{
+			Resource:  ResourceStreams,
+			Current:   int64(rc.nstreamsIn + rc.nstreamsOut),
+			Requested: int64(incount + outcount),
+			Limit:     int64(limit),
+		}
+	}
+	if err := rc.checkStreamRate(incount, outcount); err != nil {
+		return err
 	}
-	if rc.nstreamsIn+incount+rc.nstreamsOut+outcount > rc.limit.GetStreamTotalLimit() {
-		return fmt.Errorf("cannot reserve stream: %w", network.ErrResourceLimitExceeded)
+
+	rc.forceAddStreams(incount, outcount)
+	return nil
+}
+
+// forceAddStream adds a stream without checking the limits; it is used in shadow mode.
+func (rc *resources) forceAddStream(dir network.Direction) {
+	if dir == network.DirInbound {
+		rc.forceAddStreams(1, 0)
+	} else {
+		rc.forceAddStreams(0, 1)
 	}
+}
 
+func (rc *resources) forceAddStreams(incount, outcount int) {
 	rc.nstreamsIn += incount
 	rc.nstreamsOut += outcount
-	return nil
 }
 
 func (rc *resources) removeStream(dir network.Direction) {
@@ -149,6 +204,20 @@ func (rc *resources) removeStreams(incount, outcount int) {
 	}
 }
 
+// undoAddStream removes a stream whose addition is rolled back, refunding its rate limit token.
+func (rc *resources) undoAddStream(dir network.Direction) {
+	if dir == network.DirInbound {
+		rc.undoAddStreams(1, 0)
+	} else {
+		rc.undoAddStreams(0, 1)
+	}
+}
+
+func (rc *resources) undoAddStreams(incount, outcount int) {
+	rc.removeStreams(incount, outcount)
+	rc.refundStreamRate(incount, outcount)
+}
+
 func (rc *resources) addConn(dir network.Direction, usefd bool) error {
 	var fd int
 	if usefd {
@@ -163,23 +232,64 @@ func (rc *resources) addConn(dir network.Direction, usefd bool) error {
 }
 
 func (rc *resources) addConns(incount, outcount, fdcount int) error {
-	if incount > 0 && rc.nconnsIn+incount > rc.limit.GetConnLimit(network.DirInbound) {
-		return fmt.Errorf("cannot reserve connection: %w", network.ErrResourceLimitExceeded)
+	if limit := rc.limit.GetConnLimit(network.DirInbound); incount > 0 && rc.nconnsIn+incount > limit {
+		return &ErrLimitExceeded{
+			Resource:  ResourceConnsInbound,
+			Current:   int64(rc.nconnsIn),
+			Requested: int64(incount),
+			Limit:     int64(limit),
+		}
 	}
-	if outcount > 0 && rc.nconnsOut+outcount > rc.limit.GetConnLimit(network.DirOutbound) {
-		return fmt.Errorf("cannot reserve connection: %w", network.ErrResourceLimitExceeded)
+	if limit := rc.limit.GetConnLimit(network.DirOutbound); outcount > 0 && rc.nconnsOut+outcount > limit {
+		return &ErrLimitExceeded{
+			Resource:  ResourceConnsOutbound,
+			Current:   int64(rc.nconnsOut),
+			Requested: int64(outcount),
+			Limit:     int64(limit),
+		}
 	}
-	if rc.nconnsIn+incount+rc.nconnsOut+outcount > rc.limit.GetConnTotalLimit() {
-		return fmt.Errorf("cannot reserve connection: %w", network.ErrResourceLimitExceeded)
+	if limit := rc.limit.GetConnTotalLimit(); rc.nconnsIn+incount+rc.nconnsOut+outcount > limit {
+		return &ErrLimitExceeded{
+			Resource:  ResourceConns,
+			Current:   int64(rc.nconnsIn + rc.nconnsOut),
+			Requested: int64(incount + outcount),
+			Limit:     int64(limit),
+		}
 	}
-	if fdcount > 0 && rc.nfd+fdcount > rc.limit.GetFDLimit() {
-		return fmt.Errorf("cannot reserve file descriptor: %w", network.ErrResourceLimitExceeded)
+	if limit := rc.limit.GetFDLimit(); fdcount > 0 && rc.nfd+fdcount > limit {
+		return &ErrLimitExceeded{
+			Resource:  ResourceFD,
+			Current:   int64(rc.nfd),
+			Requested: int64(fdcount),
+			Limit:     int64(limit),
+		}
 	}
+	if err := rc.checkConnRate(incount, outcount); err != nil {
+		return err
+	}
+
+	rc.forceAddConns(incount, outcount, fdcount)
+	return nil
+}
 
+// forceAddConn adds a connection without checking the limits; it is used in shadow mode.
+func (rc *resources) forceAddConn(dir network.Direction, usefd bool) {
+	var fd int
+	if usefd {
+		fd = 1
+	}
+
+	if dir == network.DirInbound {
+		rc.forceAddConns(1, 0, fd)
+	} else {
+		rc.forceAddConns(0, 1, fd)
+	}
+}
+
+func (rc *resources) forceAddConns(incount, outcount, fdcount int) {
 	rc.nconnsIn += incount
 	rc.nconnsOut += outcount
 	rc.nfd += fdcount
-	return nil
 }
 
 func (rc *resources) removeConn(dir network.Direction, usefd bool) {
@@ -214,6 +324,25 @@ func (rc *resources) removeConns(incount, outcount, fdcount int) {
 	}
 }
 
+// undoAddConn removes a connection whose addition is rolled back, refunding its rate limit token.
+func (rc *resources) undoAddConn(dir network.Direction, usefd bool) {
+	var fd int
+	if usefd {
+		fd = 1
+	}
+
+	if dir == network.DirInbound {
+		rc.undoAddConns(1, 0, fd)
+	} else {
+		rc.undoAddConns(0, 1, fd)
+	}
+}
+
+func (rc *resources) undoAddConns(incount, outcount, fdcount int) {
+	rc.removeConns(incount, outcount, fdcount)
+	rc.refundConnRate(incount, outcount)
+}
+
 func (rc *resources) stat() network.ScopeStat {
 	return network.ScopeStat{
 		Memory:             rc.memory,
@@ -227,9 +356,57 @@ func (rc *resources) stat() network.ScopeStat {
 
 // resourceScope implementation
 func (s *resourceScope) wrapError(err error) error {
+	// the first scope to wrap a limit error is the one that blocked the reservation
+	var lerr *ErrLimitExceeded
+	if errors.As(err, &lerr) && lerr.Scope == "" {
+		lerr.Scope = s.name
+		lerr.Kind = s.kind
+	}
+
 	return fmt.Errorf("%s: %w", s.name, err)
 }
 
+// rateBlock logs and reports to metrics a reservation blocked by a rate limit of the scope.
+func (s *resourceScope) rateBlock(err error) {
+	var lerr *ErrLimitExceeded
+	if !errors.As(err, &lerr) || !isRateLimit(lerr.Resource) {
+		return
+	}
+	lerr.Scope = s.name
+	lerr.Kind = s.kind
+
+	log.Debugw("rate limited", "scope", s.name, "resource", lerr.Resource, "error", err)
+	s.metrics.BlockRate(lerr)
+}
+
+// limitResource returns the resource whose limit blocked a reservation.
+func limitResource(err error) Resource {
+	var lerr *ErrLimitExceeded
+	if errors.As(err, &lerr) {
+		return lerr.Resource
+	}
+	return ""
+}
+
+// shadowBlock reports whether a reservation blocked by a limit should proceed because the scope
+// is in shadow mode; if so, the would-be block is logged and reported to metrics.
+func (s *resourceScope) shadowBlock(err error) bool {
+	if !s.shadow {
+		return false
+	}
+
+	var lerr *ErrLimitExceeded
+	if !errors.As(err, &lerr) {
+		return false
+	}
+	lerr.Scope = s.name
+	lerr.Kind = s.kind
+
+	log.Debugw("shadow blocked reservation", "scope", s.name, "resource", lerr.Resource, "stat", s.rc.stat(), "error", err)
+	s.metrics.ShadowBlock(lerr)
+	return true
+}
+
 func (s *resourceScope) ReserveMemory(size int, prio uint8) error {
 	s.Lock()
 	defer s.Unlock()
@@ -239,32 +416,40 @@ func (s *resourceScope) ReserveMemory(size int, prio uint8) error {
 	}
 
 	if err := s.rc.reserveMemory(int64(size), prio); err != nil {
-		log.Debugw("blocked memory reservation", "scope", s.name, "size", size, "priority", prio, "stat", s.rc.stat(), "error", err)
-		s.trace.BlockReserveMemory(s.name, prio, int64(size), s.rc.memory)
-		s.metrics.BlockMemory(size)
-		return s.wrapError(err)
+		if !s.shadowBlock(err) {
+			log.Debugw("blocked memory reservation", "scope", s.name, "size", size, "priority", prio, "stat", s.rc.stat(), "error", err)
+			s.trace.BlockReserveMemory(s.name, "", "", prio, int64(size), s.rc.memory)
+			s.metrics.BlockMemory(size)
+			return s.wrapError(err)
+		}
+
+		s.trace.ShadowBlockReserveMemory(s.name, "", prio, int64(size), s.rc.memory)
+		s.rc.forceReserveMemory(int64(size))
 	}
 
-	if err := s.reserveMemoryForEdges(size, prio); err != nil {
+	if edge, err := s.reserveMemoryForEdges(size, prio); err != nil {
 		s.rc.releaseMemory(int64(size))
+		s.trace.BlockReserveMemory(s.name, "", edge, prio, int64(size), s.rc.memory)
 		s.metrics.BlockMemory(size)
 		return s.wrapError(err)
 	}
 
-	s.trace.ReserveMemory(s.name, prio, int64(size), s.rc.memory)
+	s.trace.ReserveMemory(s.name, "", prio, int64(size), s.rc.memory)
 	s.metrics.AllowMemory(size)
 	return nil
 }
 
-func (s *resourceScope) reserveMemoryForEdges(size int, prio uint8) error {
+// reserveMemoryForEdges reserves memory in the edges of the scope; if the reservation is blocked,
+// it returns the name of the blocking edge.
+func (s *resourceScope) reserveMemoryForEdges(size int, prio uint8) (string, error) {
 	if s.owner != nil {
-		return s.owner.ReserveMemory(size, prio)
+		return s.owner.name, s.owner.ReserveMemory(size, prio)
 	}
 
 	var reserved int
 	var err error
 	for _, e := range s.edges {
-		if err = e.ReserveMemoryForChild(int64(size), prio); err != nil {
+		if err = e.ReserveMemoryForChild(s.name, int64(size), prio); err != nil {
 			log.Debugw("blocked memory reservation from constraining edge", "scope", s.name, "edge", e.name, "size", size, "priority", prio, "stat", e.Stat(), "error", err)
 			break
 		}
@@ -275,11 +460,12 @@ func (s *resourceScope) reserveMemoryForEdges(size int, prio uint8) error {
 	if err != nil {
 		// we failed because of a constraint; undo memory reservations
 		for _, e := range s.edges[:reserved] {
-			e.ReleaseMemoryForChild(int64(size))
+			e.ReleaseMemoryForChild(s.name, int64(size))
 		}
+		return s.edges[reserved].name, err
 	}
 
-	return err
+	return "", nil
 }
 
 func (s *resourceScope) releaseMemoryForEdges(size int) {
@@ -289,11 +475,11 @@ func (s *resourceScope) releaseMemoryForEdges(size int) {
 	}
 
 	for _, e := range s.edges {
-		e.ReleaseMemoryForChild(int64(size))
+		e.ReleaseMemoryForChild(s.name, int64(size))
 	}
 }
 
-func (s *resourceScope) ReserveMemoryForChild(size int64, prio uint8) error {
+func (s *resourceScope) ReserveMemoryForChild(child string, size int64, prio uint8) error {
 	s.Lock()
 	defer s.Unlock()
 
@@ -302,11 +488,16 @@ func (s *resourceScope) ReserveMemoryForChild(size int64, prio uint8) error {
 	}
 
 	if err := s.rc.reserveMemory(size, prio); err != nil {
-		s.trace.BlockReserveMemory(s.name, prio, size, s.rc.memory)
-		return s.wrapError(err)
+		if !s.shadowBlock(err) {
+			s.trace.BlockReserveMemory(s.name, child, "", prio, size, s.rc.memory)
+			return s.wrapError(err)
+		}
+
+		s.trace.ShadowBlockReserveMemory(s.name, child, prio, size, s.rc.memory)
+		s.rc.forceReserveMemory(size)
 	}
 
-	s.trace.ReserveMemory(s.name, prio, size, s.rc.memory)
+	s.trace.ReserveMemory(s.name, child, prio, size, s.rc.memory)
 	return nil
 }
 
@@ -320,10 +511,11 @@ func (s *resourceScope) ReleaseMemory(size int) {
 
 	s.rc.releaseMemory(int64(size))
 	s.releaseMemoryForEdges(size)
-	s.trace.ReleaseMemory(s.name, int64(size), s.rc.memory)
+	s.trace.ReleaseMemory(s.name, "", int64(size), s.rc.memory)
+	s.notifyWaiters()
 }
 
-func (s *resourceScope) ReleaseMemoryForChild(size int64) {
+func (s *resourceScope) ReleaseMemoryForChild(child string, size int64) {
 	s.Lock()
 	defer s.Unlock()
 
@@ -332,7 +524,8 @@ func (s *resourceScope) ReleaseMemoryForChild(size int64) {
 	}
 
 	s.rc.releaseMemory(size)
-	s.trace.ReleaseMemory(s.name, size, s.rc.memory)
+	s.trace.ReleaseMemory(s.name, child, size, s.rc.memory)
+	s.notifyWaiters()
 }
 
 func (s *resourceScope) AddStream(dir network.Direction) error {
@@ -344,29 +537,38 @@ func (s *resourceScope) AddStream(dir network.Direction) error {
 	}
 
 	if err := s.rc.addStream(dir); err != nil {
-		log.Debugw("blocked stream", "scope", s.name, "direction", dir, "stat", s.rc.stat(), "error", err)
-		s.trace.BlockAddStream(s.name, dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
-		return s.wrapError(err)
+		if !s.shadowBlock(err) {
+			log.Debugw("blocked stream", "scope", s.name, "direction", dir, "stat", s.rc.stat(), "error", err)
+			s.rateBlock(err)
+			s.trace.BlockAddStream(s.name, "", "", limitResource(err), dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
+			return s.wrapError(err)
+		}
+
+		s.trace.ShadowBlockAddStream(s.name, "", limitResource(err), dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
+		s.rc.forceAddStream(dir)
 	}
 
-	if err := s.addStreamForEdges(dir); err != nil {
-		s.rc.removeStream(dir)
+	if edge, err := s.addStreamForEdges(dir); err != nil {
+		s.rc.undoAddStream(dir)
+		s.trace.BlockAddStream(s.name, "", edge, limitResource(err), dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
 		return s.wrapError(err)
 	}
 
-	s.trace.AddStream(s.name, dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
+	s.trace.AddStream(s.name, "", dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
 	return nil
 }
 
-func (s *resourceScope) addStreamForEdges(dir network.Direction) error {
+// addStreamForEdges adds a stream to the edges of the scope; if the stream is blocked, it returns
+// the name of the blocking edge.
+func (s *resourceScope) addStreamForEdges(dir network.Direction) (string, error) {
 	if s.owner != nil {
-		return s.owner.AddStream(dir)
+		return s.owner.name, s.owner.AddStream(dir)
 	}
 
 	var err error
 	var reserved int
 	for _, e := range s.edges {
-		if err = e.AddStreamForChild(dir); err != nil {
+		if err = e.AddStreamForChild(s.name, dir); err != nil {
 			log.Debugw("blocked stream from constraining edge", "scope", s.name, "edge", e.name, "direction", dir, "stat", e.Stat(), "error", err)
 			break
 		}
@@ -375,14 +577,15 @@ func (s *resourceScope) addStreamForEdges(dir network.Direction) error {
 
 	if err != nil {
 		for _, e := range s.edges[:reserved] {
-			e.RemoveStreamForChild(dir)
+			e.undoAddStreamForChild(s.name, dir)
 		}
+		return s.edges[reserved].name, err
 	}
 
-	return err
+	return "", nil
 }
 
-func (s *resourceScope) AddStreamForChild(dir network.Direction) error {
+func (s *resourceScope) AddStreamForChild(child string, dir network.Direction) error {
 	s.Lock()
 	defer s.Unlock()
 
@@ -391,11 +594,17 @@ func (s *resourceScope) AddStreamForChild(dir network.Direction) error {
 	}
 
 	if err := s.rc.addStream(dir); err != nil {
-		s.trace.BlockAddStream(s.name, dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
-		return s.wrapError(err)
+		if !s.shadowBlock(err) {
+			s.rateBlock(err)
+			s.trace.BlockAddStream(s.name, child, "", limitResource(err), dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
+			return s.wrapError(err)
+		}
+
+		s.trace.ShadowBlockAddStream(s.name, child, limitResource(err), dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
+		s.rc.forceAddStream(dir)
 	}
 
-	s.trace.AddStream(s.name, dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
+	s.trace.AddStream(s.name, child, dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
 	return nil
 }
 
@@ -409,7 +618,7 @@ func (s *resourceScope) RemoveStream(dir network.Direction) {
 
 	s.rc.removeStream(dir)
 	s.removeStreamForEdges(dir)
-	s.trace.RemoveStream(s.name, dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
+	s.trace.RemoveStream(s.name, "", dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
 }
 
 func (s *resourceScope) removeStreamForEdges(dir network.Direction) {
@@ -419,11 +628,11 @@ func (s *resourceScope) removeStreamForEdges(dir network.Direction) {
 	}
 
 	for _, e := range s.edges {
-		e.RemoveStreamForChild(dir)
+		e.RemoveStreamForChild(s.name, dir)
 	}
 }
 
-func (s *resourceScope) RemoveStreamForChild(dir network.Direction) {
+func (s *resourceScope) RemoveStreamForChild(child string, dir network.Direction) {
 	s.Lock()
 	defer s.Unlock()
 
@@ -432,7 +641,21 @@ func (s *resourceScope) RemoveStreamForChild(dir network.Direction) {
 	}
 
 	s.rc.removeStream(dir)
-	s.trace.RemoveStream(s.name, dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
+	s.trace.RemoveStream(s.name, child, dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
+}
+
+// undoAddStreamForChild removes a stream added with AddStreamForChild when the addition is rolled
+// back, refunding its rate limit token.
+func (s *resourceScope) undoAddStreamForChild(child string, dir network.Direction) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.done {
+		return
+	}
+
+	s.rc.undoAddStream(dir)
+	s.trace.RemoveStream(s.name, child, dir, s.rc.nstreamsIn, s.rc.nstreamsOut)
 }
 
 func (s *resourceScope) AddConn(dir network.Direction, usefd bool) error {
@@ -444,29 +667,38 @@ func (s *resourceScope) AddConn(dir network.Direction, usefd bool) error {
 	}
 
 	if err := s.rc.addConn(dir, usefd); err != nil {
-		log.Debugw("blocked connection", "scope", s.name, "direction", dir, "usefd", usefd, "stat", s.rc.stat(), "error", err)
-		s.trace.BlockAddConn(s.name, dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
-		return s.wrapError(err)
+		if !s.shadowBlock(err) {
+			log.Debugw("blocked connection", "scope", s.name, "direction", dir, "usefd", usefd, "stat", s.rc.stat(), "error", err)
+			s.rateBlock(err)
+			s.trace.BlockAddConn(s.name, "", "", limitResource(err), dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+			return s.wrapError(err)
+		}
+
+		s.trace.ShadowBlockAddConn(s.name, "", limitResource(err), dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+		s.rc.forceAddConn(dir, usefd)
 	}
 
-	if err := s.addConnForEdges(dir, usefd); err != nil {
-		s.rc.removeConn(dir, usefd)
+	if edge, err := s.addConnForEdges(dir, usefd); err != nil {
+		s.rc.undoAddConn(dir, usefd)
+		s.trace.BlockAddConn(s.name, "", edge, limitResource(err), dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
 		return s.wrapError(err)
 	}
 
-	s.trace.AddConn(s.name, dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+	s.trace.AddConn(s.name, "", dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
 	return nil
 }
 
-func (s *resourceScope) addConnForEdges(dir network.Direction, usefd bool) error {
+// addConnForEdges adds a connection to the edges of the scope; if the connection is blocked, it
+// returns the name of the blocking edge.
+func (s *resourceScope) addConnForEdges(dir network.Direction, usefd bool) (string, error) {
 	if s.owner != nil {
-		return s.owner.AddConn(dir, usefd)
+		return s.owner.name, s.owner.AddConn(dir, usefd)
 	}
 
 	var err error
 	var reserved int
 	for _, e := range s.edges {
-		if err = e.AddConnForChild(dir, usefd); err != nil {
+		if err = e.AddConnForChild(s.name, dir, usefd); err != nil {
 			log.Debugw("blocked connection from constraining edge", "scope", s.name, "edge", e.name, "direction", dir, "usefd", usefd, "stat", e.Stat(), "error", err)
 			break
 		}
@@ -475,14 +707,15 @@ func (s *resourceScope) addConnForEdges(dir network.Direction, usefd bool) error
 
 	if err != nil {
 		for _, e := range s.edges[:reserved] {
-			e.RemoveConnForChild(dir, usefd)
+			e.undoAddConnForChild(s.name, dir, usefd)
 		}
+		return s.edges[reserved].name, err
 	}
 
-	return err
+	return "", nil
 }
 
-func (s *resourceScope) AddConnForChild(dir network.Direction, usefd bool) error {
+func (s *resourceScope) AddConnForChild(child string, dir network.Direction, usefd bool) error {
 	s.Lock()
 	defer s.Unlock()
 
@@ -491,11 +724,17 @@ func (s *resourceScope) AddConnForChild(dir network.Direction, usefd bool) error
 	}
 
 	if err := s.rc.addConn(dir, usefd); err != nil {
-		s.trace.BlockAddConn(s.name, dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
-		return s.wrapError(err)
+		if !s.shadowBlock(err) {
+			s.rateBlock(err)
+			s.trace.BlockAddConn(s.name, child, "", limitResource(err), dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+			return s.wrapError(err)
+		}
+
+		s.trace.ShadowBlockAddConn(s.name, child, limitResource(err), dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+		s.rc.forceAddConn(dir, usefd)
 	}
 
-	s.trace.AddConn(s.name, dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+	s.trace.AddConn(s.name, child, dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
 	return nil
 }
 
@@ -509,7 +748,7 @@ func (s *resourceScope) RemoveConn(dir network.Direction, usefd bool) {
 
 	s.rc.removeConn(dir, usefd)
 	s.removeConnForEdges(dir, usefd)
-	s.trace.RemoveConn(s.name, dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+	s.trace.RemoveConn(s.name, "", dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
 }
 
 func (s *resourceScope) removeConnForEdges(dir network.Direction, usefd bool) {
@@ -518,11 +757,11 @@ func (s *resourceScope) removeConnForEdges(dir network.Direction, usefd bool) {
 	}
 
 	for _, e := range s.edges {
-		e.RemoveConnForChild(dir, usefd)
+		e.RemoveConnForChild(s.name, dir, usefd)
 	}
 }
 
-func (s *resourceScope) RemoveConnForChild(dir network.Direction, usefd bool) {
+func (s *resourceScope) RemoveConnForChild(child string, dir network.Direction, usefd bool) {
 	s.Lock()
 	defer s.Unlock()
 
@@ -531,10 +770,24 @@ func (s *resourceScope) RemoveConnForChild(dir network.Direction, usefd bool) {
 	}
 
 	s.rc.removeConn(dir, usefd)
-	s.trace.RemoveConn(s.name, dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+	s.trace.RemoveConn(s.name, child, dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+}
+
+// undoAddConnForChild removes a connection added with AddConnForChild when the addition is rolled
+// back, refunding its rate limit token.
+func (s *resourceScope) undoAddConnForChild(child string, dir network.Direction, usefd bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.done {
+		return
+	}
+
+	s.rc.undoAddConn(dir, usefd)
+	s.trace.RemoveConn(s.name, child, dir, usefd, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
 }
 
-func (s *resourceScope) ReserveForChild(st network.ScopeStat) error {
+func (s *resourceScope) ReserveForChild(child string, st network.ScopeStat) error {
 	s.Lock()
 	defer s.Unlock()
 
@@ -543,32 +796,49 @@ func (s *resourceScope) ReserveForChild(st network.ScopeStat) error {
 	}
 
 	if err := s.rc.reserveMemory(st.Memory, network.ReservationPriorityAlways); err != nil {
-		s.trace.BlockReserveMemory(s.name, 255, st.Memory, s.rc.memory)
-		return s.wrapError(err)
+		if !s.shadowBlock(err) {
+			s.trace.BlockReserveMemory(s.name, child, "", 255, st.Memory, s.rc.memory)
+			return s.wrapError(err)
+		}
+
+		s.trace.ShadowBlockReserveMemory(s.name, child, 255, st.Memory, s.rc.memory)
+		s.rc.forceReserveMemory(st.Memory)
 	}
 
 	if err := s.rc.addStreams(st.NumStreamsInbound, st.NumStreamsOutbound); err != nil {
-		s.trace.BlockAddStreams(s.name, st.NumStreamsInbound, st.NumStreamsOutbound, s.rc.nstreamsIn, s.rc.nstreamsOut)
-		s.rc.releaseMemory(st.Memory)
-		return s.wrapError(err)
+		if !s.shadowBlock(err) {
+			s.rateBlock(err)
+			s.trace.BlockAddStreams(s.name, child, "", limitResource(err), st.NumStreamsInbound, st.NumStreamsOutbound, s.rc.nstreamsIn, s.rc.nstreamsOut)
+			s.rc.releaseMemory(st.Memory)
+			return s.wrapError(err)
+		}
+
+		s.trace.ShadowBlockAddStreams(s.name, child, limitResource(err), st.NumStreamsInbound, st.NumStreamsOutbound, s.rc.nstreamsIn, s.rc.nstreamsOut)
+		s.rc.forceAddStreams(st.NumStreamsInbound, st.NumStreamsOutbound)
 	}
 
 	if err := s.rc.addConns(st.NumConnsInbound, st.NumConnsOutbound, st.NumFD); err != nil {
-		s.trace.BlockAddConns(s.name, st.NumConnsInbound, st.NumConnsOutbound, st.NumFD, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+		if !s.shadowBlock(err) {
+			s.rateBlock(err)
+			s.trace.BlockAddConns(s.name, child, "", limitResource(err), st.NumConnsInbound, st.NumConnsOutbound, st.NumFD, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
 
-		s.rc.releaseMemory(st.Memory)
-		s.rc.removeStreams(st.NumStreamsInbound, st.NumStreamsOutbound)
-		return s.wrapError(err)
+			s.rc.releaseMemory(st.Memory)
+			s.rc.undoAddStreams(st.NumStreamsInbound, st.NumStreamsOutbound)
+			return s.wrapError(err)
+		}
+
+		s.trace.ShadowBlockAddConns(s.name, child, limitResource(err), st.NumConnsInbound, st.NumConnsOutbound, st.NumFD, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+		s.rc.forceAddConns(st.NumConnsInbound, st.NumConnsOutbound, st.NumFD)
 	}
 
-	s.trace.ReserveMemory(s.name, 255, st.Memory, s.rc.memory)
-	s.trace.AddStreams(s.name, st.NumStreamsInbound, st.NumStreamsOutbound, s.rc.nstreamsIn, s.rc.nstreamsOut)
-	s.trace.AddConns(s.name, st.NumConnsInbound, st.NumConnsOutbound, st.NumFD, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+	s.trace.ReserveMemory(s.name, child, 255, st.Memory, s.rc.memory)
+	s.trace.AddStreams(s.name, child, st.NumStreamsInbound, st.NumStreamsOutbound, s.rc.nstreamsIn, s.rc.nstreamsOut)
+	s.trace.AddConns(s.name, child, st.NumConnsInbound, st.NumConnsOutbound, st.NumFD, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
 
 	return nil
 }
 
-func (s *resourceScope) ReleaseForChild(st network.ScopeStat) {
+func (s *resourceScope) ReleaseForChild(child string, st network.ScopeStat) {
 	s.Lock()
 	defer s.Unlock()
 
@@ -580,9 +850,36 @@ func (s *resourceScope) ReleaseForChild(st network.ScopeStat) {
 	s.rc.removeStreams(st.NumStreamsInbound, st.NumStreamsOutbound)
 	s.rc.removeConns(st.NumConnsInbound, st.NumConnsOutbound, st.NumFD)
 
-	s.trace.ReleaseMemory(s.name, st.Memory, s.rc.memory)
-	s.trace.RemoveStreams(s.name, st.NumStreamsInbound, st.NumStreamsOutbound, s.rc.nstreamsIn, s.rc.nstreamsOut)
-	s.trace.RemoveConns(s.name, st.NumConnsInbound, st.NumConnsOutbound, st.NumFD, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+	s.trace.ReleaseMemory(s.name, child, st.Memory, s.rc.memory)
+	s.trace.RemoveStreams(s.name, child, st.NumStreamsInbound, st.NumStreamsOutbound, s.rc.nstreamsIn, s.rc.nstreamsOut)
+	s.trace.RemoveConns(s.name, child, st.NumConnsInbound, st.NumConnsOutbound, st.NumFD, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+
+	if st.Memory > 0 {
+		s.notifyWaiters()
+	}
+}
+
+// undoReserveForChild releases resources reserved with ReserveForChild when the reservation is
+// rolled back, refunding the rate limit tokens of its streams and connections.
+func (s *resourceScope) undoReserveForChild(child string, st network.ScopeStat) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.done {
+		return
+	}
+
+	s.rc.releaseMemory(st.Memory)
+	s.rc.undoAddStreams(st.NumStreamsInbound, st.NumStreamsOutbound)
+	s.rc.undoAddConns(st.NumConnsInbound, st.NumConnsOutbound, st.NumFD)
+
+	s.trace.ReleaseMemory(s.name, child, st.Memory, s.rc.memory)
+	s.trace.RemoveStreams(s.name, child, st.NumStreamsInbound, st.NumStreamsOutbound, s.rc.nstreamsIn, s.rc.nstreamsOut)
+	s.trace.RemoveConns(s.name, child, st.NumConnsInbound, st.NumConnsOutbound, st.NumFD, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+
+	if st.Memory > 0 {
+		s.notifyWaiters()
+	}
 }
 
 func (s *resourceScope) ReleaseResources(st network.ScopeStat) {
@@ -601,13 +898,17 @@ func (s *resourceScope) ReleaseResources(st network.ScopeStat) {
 		s.owner.ReleaseResources(st)
 	} else {
 		for _, e := range s.edges {
-			e.ReleaseForChild(st)
+			e.ReleaseForChild(s.name, st)
 		}
 	}
 
-	s.trace.ReleaseMemory(s.name, st.Memory, s.rc.memory)
-	s.trace.RemoveStreams(s.name, st.NumStreamsInbound, st.NumStreamsOutbound, s.rc.nstreamsIn, s.rc.nstreamsOut)
-	s.trace.RemoveConns(s.name, st.NumConnsInbound, st.NumConnsOutbound, st.NumFD, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+	s.trace.ReleaseMemory(s.name, "", st.Memory, s.rc.memory)
+	s.trace.RemoveStreams(s.name, "", st.NumStreamsInbound, st.NumStreamsOutbound, s.rc.nstreamsIn, s.rc.nstreamsOut)
+	s.trace.RemoveConns(s.name, "", st.NumConnsInbound, st.NumConnsOutbound, st.NumFD, s.rc.nconnsIn, s.rc.nconnsOut, s.rc.nfd)
+
+	if st.Memory > 0 {
+		s.notifyWaiters()
+	}
 }
 
 func (s *resourceScope) BeginSpan() (network.ResourceScopeSpan, error) {
@@ -636,7 +937,7 @@ func (s *resourceScope) Done() {
 		s.owner.DecRef()
 	} else {
 		for _, e := range s.edges {
-			e.ReleaseForChild(stat)
+			e.ReleaseForChild(s.name, stat)
 			e.DecRef()
 		}
 	}
@@ -651,6 +952,9 @@ func (s *resourceScope) Done() {
 	s.done = true
 
 	s.trace.DestroyScope(s.name)
+
+	// waiters in a closed scope fail on their next attempt
+	s.notifyWaiters()
 }
 
 func (s *resourceScope) Stat() network.ScopeStat {
@@ -0,0 +1,121 @@
+package rcmgr
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+type shadowReporter struct {
+	mx     sync.Mutex
+	blocks []*ErrLimitExceeded
+	allows int
+}
+
+var _ ShadowMetricsReporter = (*shadowReporter)(nil)
+
+func (r *shadowReporter) ShadowBlock(err *ErrLimitExceeded) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.blocks = append(r.blocks, err)
+}
+
+func (r *shadowReporter) AllowConn(network.Direction, bool) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.allows++
+}
+
+func (r *shadowReporter) BlockConn(network.Direction, bool)      {}
+func (r *shadowReporter) AllowStream(peer.ID, network.Direction) {}
+func (r *shadowReporter) BlockStream(peer.ID, network.Direction) {}
+func (r *shadowReporter) AllowPeer(peer.ID)                      {}
+func (r *shadowReporter) BlockPeer(peer.ID)                      {}
+func (r *shadowReporter) AllowProtocol(protocol.ID)              {}
+func (r *shadowReporter) BlockProtocol(protocol.ID)              {}
+func (r *shadowReporter) BlockProtocolPeer(protocol.ID, peer.ID) {}
+func (r *shadowReporter) AllowService(string)                    {}
+func (r *shadowReporter) BlockService(string)                    {}
+func (r *shadowReporter) BlockServicePeer(string, peer.ID)       {}
+func (r *shadowReporter) AllowMemory(int)                        {}
+func (r *shadowReporter) BlockMemory(int)                        {}
+
+func TestShadowMode(t *testing.T) {
+	limit := &StaticLimit{
+		Memory: 4096,
+		BaseLimit: BaseLimit{
+			StreamsInbound:  1,
+			StreamsOutbound: 1,
+			Streams:         1,
+			ConnsInbound:    1,
+			ConnsOutbound:   1,
+			Conns:           1,
+			FD:              1,
+		},
+	}
+	reporter := &shadowReporter{}
+	nmgr, err := NewResourceManager(&BasicLimiter{
+		SystemLimits:              limit,
+		TransientLimits:           limit,
+		DefaultServiceLimits:      limit,
+		DefaultServicePeerLimits:  limit,
+		DefaultProtocolLimits:     limit,
+		DefaultProtocolPeerLimits: limit,
+		DefaultPeerLimits:         limit,
+		ConnLimits:                limit,
+		StreamLimits:              limit,
+	}, WithShadowMode(), WithMetrics(reporter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := nmgr.(*resourceManager)
+	defer mgr.Close()
+
+	// the second connection exceeds both the transient and the system limits, but is allowed
+	conn1, err := mgr.OpenConnection(network.DirInbound, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn1.Done()
+	conn2, err := mgr.OpenConnection(network.DirInbound, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Done()
+
+	// usage is still accounted
+	checkResources(t, &mgr.transient.rc, network.ScopeStat{NumConnsInbound: 2, NumFD: 2})
+	checkResources(t, &mgr.system.rc, network.ScopeStat{NumConnsInbound: 2, NumFD: 2})
+
+	// and the would-be blocks are reported
+	if reporter.allows != 2 {
+		t.Fatalf("expected 2 allowed connections, got %d", reporter.allows)
+	}
+	if len(reporter.blocks) != 2 {
+		t.Fatalf("expected 2 shadow blocks, got %d", len(reporter.blocks))
+	}
+	for i, scope := range []string{"transient", "system"} {
+		if reporter.blocks[i].Scope != scope {
+			t.Fatalf("expected shadow block in %s, got %s", scope, reporter.blocks[i].Scope)
+		}
+		if reporter.blocks[i].Resource != ResourceConnsInbound {
+			t.Fatalf("expected shadow block for inbound conns, got %s", reporter.blocks[i].Resource)
+		}
+	}
+
+	// memory reservations are allowed too
+	if err := conn1.ReserveMemory(8192, network.ReservationPriorityAlways); err != nil {
+		t.Fatal(err)
+	}
+	checkResources(t, &conn1.(*connectionScope).rc, network.ScopeStat{NumConnsInbound: 1, NumFD: 1, Memory: 8192})
+	conn1.ReleaseMemory(8192)
+
+	// shadow mode can be turned off for an individual scope
+	mgr.transient.SetShadow(false)
+	if _, err := mgr.OpenConnection(network.DirInbound, false); err == nil {
+		t.Fatal("expected OpenConnection to fail")
+	}
+}
@@ -1,11 +1,10 @@
 package rcmgr
 
 import (
-	"compress/gzip"
 	"context"
-	"encoding/json"
-	"io"
-	"os"
+	"fmt"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -13,43 +12,154 @@ import (
 )
 
 type trace struct {
-	path string
+	open func() (TraceSink, error)
+	sink TraceSink
 
 	ctx    context.Context
 	cancel func()
 	closed chan struct{}
+	err    error // the sink error that stopped the trace, if any
+
+	filt    *traceFilter    // the events to record; nil for all events
+	bufSize int             // the maximum number of pending events; 0 for unbounded
+	policy  TraceDropPolicy // what to drop when the pending events are at capacity
+
+	mx      sync.Mutex
+	done    bool
+	start   time.Time
+	seq     uint64
+	pend    []TraceEvt
+	head    int            // the oldest pending event, when dropping the oldest events
+	pushed  int            // events pushed since the last write, for sampling
+	dropped map[string]int // events dropped since the last write, by type
+}
+
+// DefaultTraceBufferSize is the default maximum number of trace events buffered between writes
+// to the trace sink, which happen every second.
+const DefaultTraceBufferSize = 1 << 16
+
+// TraceDropPolicy determines which events are dropped when the trace buffer is full.
+type TraceDropPolicy int
+
+const (
+	// TraceDropNewest drops new events while the buffer is full.
+	TraceDropNewest TraceDropPolicy = iota
+	// TraceDropOldest drops the oldest buffered events to make room for new ones.
+	TraceDropOldest
+	// TraceDropSample retains a uniform random sample of the events pushed since the last write.
+	TraceDropSample
+)
+
+// TraceOption is an option for the resource manager trace.
+type TraceOption func(*trace) error
+
+// WithTraceBuffer is a trace option that sets the maximum number of events buffered between
+// writes to the trace sink, and the policy for dropping events when the buffer is full. A size of
+// 0 leaves the buffer unbounded. Dropped events are accounted in dropped events in the trace.
+func WithTraceBuffer(size int, policy TraceDropPolicy) TraceOption {
+	return func(t *trace) error {
+		if size < 0 {
+			return fmt.Errorf("invalid trace buffer size: %d", size)
+		}
+		switch policy {
+		case TraceDropNewest, TraceDropOldest, TraceDropSample:
+		default:
+			return fmt.Errorf("invalid trace drop policy: %d", policy)
+		}
 
-	mx   sync.Mutex
-	done bool
-	pend []interface{}
+		t.bufSize = size
+		t.policy = policy
+		return nil
+	}
+}
+
+// WithTrace is a resource manager option that writes a trace of the resource manager events to a
+// gzipped JSON file at path, truncating any existing file.
+func WithTrace(path string, opts ...TraceOption) Option {
+	return withTrace(func() (TraceSink, error) { return NewFileTraceSink(path) }, opts)
+}
+
+// WithTraceSink is a resource manager option that writes a trace of the resource manager events
+// to a TraceSink. The sink is closed when the resource manager is closed.
+func WithTraceSink(sink TraceSink, opts ...TraceOption) Option {
+	if sink == nil {
+		return func(r *resourceManager) error {
+			return fmt.Errorf("nil trace sink")
+		}
+	}
+	return withTrace(func() (TraceSink, error) { return sink, nil }, opts)
 }
 
-func WithTrace(path string) Option {
+func withTrace(open func() (TraceSink, error), opts []TraceOption) Option {
 	return func(r *resourceManager) error {
-		r.trace = &trace{path: path}
+		t := &trace{open: open, bufSize: DefaultTraceBufferSize}
+		for _, opt := range opts {
+			if err := opt(t); err != nil {
+				return err
+			}
+		}
+
+		r.trace = t
 		return nil
 	}
 }
 
+// TraceVersion is the version of the trace format written by the resource manager; it is recorded
+// in the start event of the trace. Traces without a version were written before the trace events
+// had timestamps, sequence numbers, and child scope and blocking edge details.
+const TraceVersion = 1
+
+// Trace event types
 const (
-	traceStartEvt              = "start"
-	traceCreateScopeEvt        = "create_scope"
-	traceDestroyScopeEvt       = "destroy_scope"
-	traceReserveMemoryEvt      = "reserve_memory"
-	traceBlockReserveMemoryEvt = "block_reserve_memory"
-	traceReleaseMemoryEvt      = "release_memory"
-	traceAddStreamEvt          = "add_stream"
-	traceBlockAddStreamEvt     = "block_add_stream"
-	traceRemoveStreamEvt       = "remove_stream"
-	traceAddConnEvt            = "add_conn"
-	traceBlockAddConnEvt       = "block_add_conn"
-	traceRemoveConnEvt         = "remove_conn"
+	TraceStartEvt              = "start"
+	TraceCreateScopeEvt        = "create_scope"
+	TraceDestroyScopeEvt       = "destroy_scope"
+	TraceReserveMemoryEvt      = "reserve_memory"
+	TraceBlockReserveMemoryEvt = "block_reserve_memory"
+	TraceReleaseMemoryEvt      = "release_memory"
+	TraceAddStreamEvt          = "add_stream"
+	TraceBlockAddStreamEvt     = "block_add_stream"
+	TraceRemoveStreamEvt       = "remove_stream"
+	TraceAddConnEvt            = "add_conn"
+	TraceBlockAddConnEvt       = "block_add_conn"
+	TraceRemoveConnEvt         = "remove_conn"
+
+	TraceShadowBlockReserveMemoryEvt = "shadow_block_reserve_memory"
+	TraceShadowBlockAddStreamEvt     = "shadow_block_add_stream"
+	TraceShadowBlockAddConnEvt       = "shadow_block_add_conn"
+
+	TraceDroppedEvt = "dropped"
 )
 
-type traceEvt struct {
+// TraceEvt is a trace event, as written in the trace file.
+//
+// For usage changing events, the Memory, Streams, Conns and FD fields hold the usage of the scope
+// after the change; for blocked events, they hold the usage at the time of the block. The deltas
+// of remove events are negative, while release_memory events carry the (positive) released size;
+// for conn events, Delta is the fd delta.
+//
+// The Limit of start events is the Limiter and the Limit of create_scope events is the scope's
+// Limit; when an event is read back with a TraceReader, Limit holds the raw json.RawMessage.
+//
+// Events of a scope accounting for the resources of a child scope have the name of the child in
+// Child. When a reservation is blocked by one of the edges of a scope, the block is recorded both
+// in the edge and in the scope itself, with the name of the blocking edge in Edge.
+type TraceEvt struct {
+	// Version is the trace format version; it is only set in the start event.
+	Version int `json:",omitempty"`
+
+	// Time is the wall clock time of the event.
+	Time time.Time
+	// Mono is the time of the event since the start of the trace, from the monotonic clock.
+	Mono time.Duration `json:",omitempty"`
+	// Seq is the sequence number of the event in the trace.
+	Seq uint64 `json:",omitempty"`
+
 	Type string
 
 	Scope string `json:",omitempty"`
+	Child string `json:",omitempty"`
+	Edge  string `json:",omitempty"`
 
 	Limit interface{} `json:",omitempty"`
 
@@ -68,9 +178,21 @@ type traceEvt struct {
 	ConnsOut int `json:",omitempty"`
 
 	FD int `json:",omitempty"`
+
+	// Resource is the resource whose limit blocked the reservation of block_add_* and
+	// shadow_block_add_* events.
+	Resource Resource `json:",omitempty"`
+
+	// Dropped is the number of events dropped from the trace since the previous write, by event
+	// type; it is only set in dropped events.
+	Dropped map[string]int `json:",omitempty"`
 }
 
-func (t *trace) push(evt interface{}) {
+func (t *trace) push(evt TraceEvt) {
+	if t.filt != nil && !t.filt.keep(&evt) {
+		return
+	}
+
 	t.mx.Lock()
 	defer t.mx.Unlock()
 
@@ -78,29 +200,82 @@ func (t *trace) push(evt interface{}) {
 		return
 	}
 
-	t.pend = append(t.pend, evt)
+	t.stamp(&evt)
+	t.pushed++
+
+	if t.bufSize == 0 || len(t.pend) < t.bufSize {
+		t.pend = append(t.pend, evt)
+		return
+	}
+
+	var idx int
+	switch t.policy {
+	case TraceDropOldest:
+		// the buffer is used as a ring; events are put back in sequence order when they are
+		// taken for writing.
+		idx = t.head
+		t.head = (t.head + 1) % t.bufSize
+	case TraceDropSample:
+		// reservoir sampling
+		idx = rand.Intn(t.pushed)
+	default:
+		idx = t.bufSize
+	}
+
+	if t.dropped == nil {
+		t.dropped = make(map[string]int)
+	}
+	if idx < t.bufSize {
+		t.dropped[t.pend[idx].Type]++
+		t.pend[idx] = evt
+	} else {
+		t.dropped[evt.Type]++
+	}
 }
 
-func (t *trace) background(out io.WriteCloser) {
-	defer close(t.closed)
-	defer out.Close()
+// stamp sets the time and sequence number of an event; it must be called with the lock held.
+func (t *trace) stamp(evt *TraceEvt) {
+	now := time.Now()
+	evt.Time = now
+	evt.Mono = now.Sub(t.start)
+	evt.Seq = t.seq
+	t.seq++
+}
 
-	gzOut := gzip.NewWriter(out)
-	defer gzOut.Close()
+// takeEvents swaps the pending events with buf, and returns them in sequence order, followed by a
+// dropped event if any events were dropped.
+func (t *trace) takeEvents(buf []TraceEvt) []TraceEvt {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	pend := t.pend
+	t.pend = buf[:0]
+	t.head = 0
+	t.pushed = 0
+
+	if t.dropped == nil {
+		return pend
+	}
+
+	sort.Slice(pend, func(i, j int) bool { return pend[i].Seq < pend[j].Seq })
 
-	jsonOut := json.NewEncoder(gzOut)
+	evt := TraceEvt{Type: TraceDroppedEvt, Dropped: t.dropped}
+	t.stamp(&evt)
+	t.dropped = nil
+
+	return append(pend, evt)
+}
+
+func (t *trace) background() {
+	defer close(t.closed)
 
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
-	var pend []interface{}
+	var pend []TraceEvt
 
 	getEvents := func() {
-		t.mx.Lock()
-		tmp := t.pend
-		t.pend = pend[:0]
-		pend = tmp
-		t.mx.Unlock()
+		pend = t.takeEvents(pend)
 	}
 
 	for {
@@ -112,36 +287,33 @@ func (t *trace) background(out io.WriteCloser) {
 				continue
 			}
 
-			if err := t.writeEvents(pend, jsonOut); err != nil {
+			if err := t.sink.Write(pend); err != nil {
 				log.Warnf("error writing rcmgr trace: %s", err)
 				t.mx.Lock()
 				t.done = true
 				t.mx.Unlock()
-				return
-			}
-
-			if err := gzOut.Flush(); err != nil {
-				log.Warnf("error flushing rcmgr trace: %s", err)
-				t.mx.Lock()
-				t.done = true
-				t.mx.Unlock()
+				t.err = err
+				if err := t.sink.Close(); err != nil {
+					log.Warnf("error closing rcmgr trace: %s", err)
+				}
 				return
 			}
 
 		case <-t.ctx.Done():
 			getEvents()
 
-			if len(pend) == 0 {
-				return
+			if len(pend) > 0 {
+				if err := t.sink.Write(pend); err != nil {
+					log.Warnf("error writing rcmgr trace: %s", err)
+					t.err = err
+				}
 			}
 
-			if err := t.writeEvents(pend, jsonOut); err != nil {
-				log.Warnf("error writing rcmgr trace: %s", err)
-				return
-			}
-
-			if err := gzOut.Flush(); err != nil {
-				log.Warnf("error flushing rcmgr trace: %s", err)
+			if err := t.sink.Close(); err != nil {
+				log.Warnf("error closing rcmgr trace: %s", err)
+				if t.err == nil {
+					t.err = err
+				}
 			}
 
 			return
@@ -149,39 +321,34 @@ func (t *trace) background(out io.WriteCloser) {
 	}
 }
 
-func (t *trace) writeEvents(pend []interface{}, jout *json.Encoder) error {
-	for _, e := range pend {
-		if err := jout.Encode(e); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
 func (t *trace) Start(limits Limiter) error {
 	if t == nil {
 		return nil
 	}
 
-	t.ctx, t.cancel = context.WithCancel(context.Background())
-	t.closed = make(chan struct{})
-
-	out, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	sink, err := t.open()
 	if err != nil {
-		return nil
+		return fmt.Errorf("error opening trace sink: %w", err)
 	}
+	t.sink = sink
+
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	t.closed = make(chan struct{})
 
-	go t.background(out)
+	t.start = time.Now()
+	go t.background()
 
-	t.push(traceEvt{
-		Type:  traceStartEvt,
-		Limit: limits,
+	t.push(TraceEvt{
+		Version: TraceVersion,
+		Type:    TraceStartEvt,
+		Limit:   limits,
 	})
 
 	return nil
 }
 
+// Close stops the trace and closes its sink; it returns the sink error that stopped the trace,
+// if any.
 func (t *trace) Close() error {
 	if t == nil {
 		return nil
@@ -191,7 +358,8 @@ func (t *trace) Close() error {
 
 	if t.done {
 		t.mx.Unlock()
-		return nil
+		<-t.closed
+		return t.err
 	}
 
 	t.cancel()
@@ -199,7 +367,7 @@ func (t *trace) Close() error {
 	t.mx.Unlock()
 
 	<-t.closed
-	return nil
+	return t.err
 }
 
 func (t *trace) CreateScope(scope string, limit Limit) {
@@ -207,8 +375,8 @@ func (t *trace) CreateScope(scope string, limit Limit) {
 		return
 	}
 
-	t.push(traceEvt{
-		Type:  traceCreateScopeEvt,
+	t.push(TraceEvt{
+		Type:  TraceCreateScopeEvt,
 		Scope: scope,
 		Limit: limit,
 	})
@@ -219,54 +387,73 @@ func (t *trace) DestroyScope(scope string) {
 		return
 	}
 
-	t.push(traceEvt{
-		Type:  traceDestroyScopeEvt,
+	t.push(TraceEvt{
+		Type:  TraceDestroyScopeEvt,
 		Scope: scope,
 	})
 }
 
-func (t *trace) ReserveMemory(scope string, prio uint8, size, mem int64) {
+func (t *trace) ReserveMemory(scope, child string, prio uint8, size, mem int64) {
 	if t == nil {
 		return
 	}
 
-	t.push(traceEvt{
-		Type:     traceReserveMemoryEvt,
+	t.push(TraceEvt{
+		Type:     TraceReserveMemoryEvt,
 		Scope:    scope,
+		Child:    child,
 		Priority: prio,
 		Delta:    size,
 		Memory:   mem,
 	})
 }
 
-func (t *trace) BlockReserveMemory(scope string, prio uint8, size, mem int64) {
+func (t *trace) BlockReserveMemory(scope, child, edge string, prio uint8, size, mem int64) {
 	if t == nil {
 		return
 	}
 
-	t.push(traceEvt{
-		Type:     traceBlockReserveMemoryEvt,
+	t.push(TraceEvt{
+		Type:     TraceBlockReserveMemoryEvt,
 		Scope:    scope,
+		Child:    child,
+		Edge:     edge,
 		Priority: prio,
 		Delta:    size,
 		Memory:   mem,
 	})
 }
 
-func (t *trace) ReleaseMemory(scope string, size, mem int64) {
+func (t *trace) ShadowBlockReserveMemory(scope, child string, prio uint8, size, mem int64) {
 	if t == nil {
 		return
 	}
 
-	t.push(traceEvt{
-		Type:   traceReleaseMemoryEvt,
+	t.push(TraceEvt{
+		Type:     TraceShadowBlockReserveMemoryEvt,
+		Scope:    scope,
+		Child:    child,
+		Priority: prio,
+		Delta:    size,
+		Memory:   mem,
+	})
+}
+
+func (t *trace) ReleaseMemory(scope, child string, size, mem int64) {
+	if t == nil {
+		return
+	}
+
+	t.push(TraceEvt{
+		Type:   TraceReleaseMemoryEvt,
 		Scope:  scope,
+		Child:  child,
 		Delta:  size,
 		Memory: mem,
 	})
 }
 
-func (t *trace) AddStream(scope string, dir network.Direction, nstreamsIn, nstreamsOut int) {
+func (t *trace) AddStream(scope, child string, dir network.Direction, nstreamsIn, nstreamsOut int) {
 	if t == nil {
 		return
 	}
@@ -278,9 +465,10 @@ func (t *trace) AddStream(scope string, dir network.Direction, nstreamsIn, nstre
 		deltaOut = 1
 	}
 
-	t.push(traceEvt{
-		Type:       traceAddStreamEvt,
+	t.push(TraceEvt{
+		Type:       TraceAddStreamEvt,
 		Scope:      scope,
+		Child:      child,
 		DeltaIn:    deltaIn,
 		DeltaOut:   deltaOut,
 		StreamsIn:  nstreamsIn,
@@ -288,7 +476,7 @@ func (t *trace) AddStream(scope string, dir network.Direction, nstreamsIn, nstre
 	})
 }
 
-func (t *trace) BlockAddStream(scope string, dir network.Direction, nstreamsIn, nstreamsOut int) {
+func (t *trace) BlockAddStream(scope, child, edge string, res Resource, dir network.Direction, nstreamsIn, nstreamsOut int) {
 	if t == nil {
 		return
 	}
@@ -300,9 +488,12 @@ func (t *trace) BlockAddStream(scope string, dir network.Direction, nstreamsIn,
 		deltaOut = 1
 	}
 
-	t.push(traceEvt{
-		Type:       traceBlockAddStreamEvt,
+	t.push(TraceEvt{
+		Type:       TraceBlockAddStreamEvt,
 		Scope:      scope,
+		Child:      child,
+		Edge:       edge,
+		Resource:   res,
 		DeltaIn:    deltaIn,
 		DeltaOut:   deltaOut,
 		StreamsIn:  nstreamsIn,
@@ -310,7 +501,31 @@ func (t *trace) BlockAddStream(scope string, dir network.Direction, nstreamsIn,
 	})
 }
 
-func (t *trace) RemoveStream(scope string, dir network.Direction, nstreamsIn, nstreamsOut int) {
+func (t *trace) ShadowBlockAddStream(scope, child string, res Resource, dir network.Direction, nstreamsIn, nstreamsOut int) {
+	if t == nil {
+		return
+	}
+
+	var deltaIn, deltaOut int
+	if dir == network.DirInbound {
+		deltaIn = 1
+	} else {
+		deltaOut = 1
+	}
+
+	t.push(TraceEvt{
+		Type:       TraceShadowBlockAddStreamEvt,
+		Scope:      scope,
+		Child:      child,
+		Resource:   res,
+		DeltaIn:    deltaIn,
+		DeltaOut:   deltaOut,
+		StreamsIn:  nstreamsIn,
+		StreamsOut: nstreamsOut,
+	})
+}
+
+func (t *trace) RemoveStream(scope, child string, dir network.Direction, nstreamsIn, nstreamsOut int) {
 	if t == nil {
 		return
 	}
@@ -322,9 +537,26 @@ func (t *trace) RemoveStream(scope string, dir network.Direction, nstreamsIn, ns
 		deltaOut = -1
 	}
 
-	t.push(traceEvt{
-		Type:       traceRemoveStreamEvt,
+	t.push(TraceEvt{
+		Type:       TraceRemoveStreamEvt,
+		Scope:      scope,
+		Child:      child,
+		DeltaIn:    deltaIn,
+		DeltaOut:   deltaOut,
+		StreamsIn:  nstreamsIn,
+		StreamsOut: nstreamsOut,
+	})
+}
+
+func (t *trace) AddStreams(scope, child string, deltaIn, deltaOut, nstreamsIn, nstreamsOut int) {
+	if t == nil {
+		return
+	}
+
+	t.push(TraceEvt{
+		Type:       TraceAddStreamEvt,
 		Scope:      scope,
+		Child:      child,
 		DeltaIn:    deltaIn,
 		DeltaOut:   deltaOut,
 		StreamsIn:  nstreamsIn,
@@ -332,14 +564,17 @@ func (t *trace) RemoveStream(scope string, dir network.Direction, nstreamsIn, ns
 	})
 }
 
-func (t *trace) AddStreams(scope string, deltaIn, deltaOut, nstreamsIn, nstreamsOut int) {
+func (t *trace) BlockAddStreams(scope, child, edge string, res Resource, deltaIn, deltaOut, nstreamsIn, nstreamsOut int) {
 	if t == nil {
 		return
 	}
 
-	t.push(traceEvt{
-		Type:       traceAddStreamEvt,
+	t.push(TraceEvt{
+		Type:       TraceBlockAddStreamEvt,
 		Scope:      scope,
+		Child:      child,
+		Edge:       edge,
+		Resource:   res,
 		DeltaIn:    deltaIn,
 		DeltaOut:   deltaOut,
 		StreamsIn:  nstreamsIn,
@@ -347,14 +582,16 @@ func (t *trace) AddStreams(scope string, deltaIn, deltaOut, nstreamsIn, nstreams
 	})
 }
 
-func (t *trace) BlockAddStreams(scope string, deltaIn, deltaOut, nstreamsIn, nstreamsOut int) {
+func (t *trace) ShadowBlockAddStreams(scope, child string, res Resource, deltaIn, deltaOut, nstreamsIn, nstreamsOut int) {
 	if t == nil {
 		return
 	}
 
-	t.push(traceEvt{
-		Type:       traceBlockAddStreamEvt,
+	t.push(TraceEvt{
+		Type:       TraceShadowBlockAddStreamEvt,
 		Scope:      scope,
+		Child:      child,
+		Resource:   res,
 		DeltaIn:    deltaIn,
 		DeltaOut:   deltaOut,
 		StreamsIn:  nstreamsIn,
@@ -362,14 +599,15 @@ func (t *trace) BlockAddStreams(scope string, deltaIn, deltaOut, nstreamsIn, nst
 	})
 }
 
-func (t *trace) RemoveStreams(scope string, deltaIn, deltaOut, nstreamsIn, nstreamsOut int) {
+func (t *trace) RemoveStreams(scope, child string, deltaIn, deltaOut, nstreamsIn, nstreamsOut int) {
 	if t == nil {
 		return
 	}
 
-	t.push(traceEvt{
-		Type:       traceRemoveStreamEvt,
+	t.push(TraceEvt{
+		Type:       TraceRemoveStreamEvt,
 		Scope:      scope,
+		Child:      child,
 		DeltaIn:    -deltaIn,
 		DeltaOut:   -deltaOut,
 		StreamsIn:  nstreamsIn,
@@ -377,7 +615,7 @@ func (t *trace) RemoveStreams(scope string, deltaIn, deltaOut, nstreamsIn, nstre
 	})
 }
 
-func (t *trace) AddConn(scope string, dir network.Direction, usefd bool, nconnsIn, nconnsOut, nfd int) {
+func (t *trace) AddConn(scope, child string, dir network.Direction, usefd bool, nconnsIn, nconnsOut, nfd int) {
 	if t == nil {
 		return
 	}
@@ -392,9 +630,10 @@ func (t *trace) AddConn(scope string, dir network.Direction, usefd bool, nconnsI
 		deltafd = 1
 	}
 
-	t.push(traceEvt{
-		Type:     traceAddConnEvt,
+	t.push(TraceEvt{
+		Type:     TraceAddConnEvt,
 		Scope:    scope,
+		Child:    child,
 		DeltaIn:  deltaIn,
 		DeltaOut: deltaOut,
 		Delta:    int64(deltafd),
@@ -404,7 +643,7 @@ func (t *trace) AddConn(scope string, dir network.Direction, usefd bool, nconnsI
 	})
 }
 
-func (t *trace) BlockAddConn(scope string, dir network.Direction, usefd bool, nconnsIn, nconnsOut, nfd int) {
+func (t *trace) BlockAddConn(scope, child, edge string, res Resource, dir network.Direction, usefd bool, nconnsIn, nconnsOut, nfd int) {
 	if t == nil {
 		return
 	}
@@ -419,9 +658,12 @@ func (t *trace) BlockAddConn(scope string, dir network.Direction, usefd bool, nc
 		deltafd = 1
 	}
 
-	t.push(traceEvt{
-		Type:     traceBlockAddConnEvt,
+	t.push(TraceEvt{
+		Type:     TraceBlockAddConnEvt,
 		Scope:    scope,
+		Child:    child,
+		Edge:     edge,
+		Resource: res,
 		DeltaIn:  deltaIn,
 		DeltaOut: deltaOut,
 		Delta:    int64(deltafd),
@@ -431,7 +673,36 @@ func (t *trace) BlockAddConn(scope string, dir network.Direction, usefd bool, nc
 	})
 }
 
-func (t *trace) RemoveConn(scope string, dir network.Direction, usefd bool, nconnsIn, nconnsOut, nfd int) {
+func (t *trace) ShadowBlockAddConn(scope, child string, res Resource, dir network.Direction, usefd bool, nconnsIn, nconnsOut, nfd int) {
+	if t == nil {
+		return
+	}
+
+	var deltaIn, deltaOut, deltafd int
+	if dir == network.DirInbound {
+		deltaIn = 1
+	} else {
+		deltaOut = 1
+	}
+	if usefd {
+		deltafd = 1
+	}
+
+	t.push(TraceEvt{
+		Type:     TraceShadowBlockAddConnEvt,
+		Scope:    scope,
+		Child:    child,
+		Resource: res,
+		DeltaIn:  deltaIn,
+		DeltaOut: deltaOut,
+		Delta:    int64(deltafd),
+		ConnsIn:  nconnsIn,
+		ConnsOut: nconnsOut,
+		FD:       nfd,
+	})
+}
+
+func (t *trace) RemoveConn(scope, child string, dir network.Direction, usefd bool, nconnsIn, nconnsOut, nfd int) {
 	if t == nil {
 		return
 	}
@@ -446,9 +717,28 @@ func (t *trace) RemoveConn(scope string, dir network.Direction, usefd bool, ncon
 		deltafd = -1
 	}
 
-	t.push(traceEvt{
-		Type:     traceRemoveConnEvt,
+	t.push(TraceEvt{
+		Type:     TraceRemoveConnEvt,
+		Scope:    scope,
+		Child:    child,
+		DeltaIn:  deltaIn,
+		DeltaOut: deltaOut,
+		Delta:    int64(deltafd),
+		ConnsIn:  nconnsIn,
+		ConnsOut: nconnsOut,
+		FD:       nfd,
+	})
+}
+
+func (t *trace) AddConns(scope, child string, deltaIn, deltaOut, deltafd, nconnsIn, nconnsOut, nfd int) {
+	if t == nil {
+		return
+	}
+
+	t.push(TraceEvt{
+		Type:     TraceAddConnEvt,
 		Scope:    scope,
+		Child:    child,
 		DeltaIn:  deltaIn,
 		DeltaOut: deltaOut,
 		Delta:    int64(deltafd),
@@ -458,14 +748,17 @@ func (t *trace) RemoveConn(scope string, dir network.Direction, usefd bool, ncon
 	})
 }
 
-func (t *trace) AddConns(scope string, deltaIn, deltaOut, deltafd, nconnsIn, nconnsOut, nfd int) {
+func (t *trace) BlockAddConns(scope, child, edge string, res Resource, deltaIn, deltaOut, deltafd, nconnsIn, nconnsOut, nfd int) {
 	if t == nil {
 		return
 	}
 
-	t.push(traceEvt{
-		Type:     traceAddConnEvt,
+	t.push(TraceEvt{
+		Type:     TraceBlockAddConnEvt,
 		Scope:    scope,
+		Child:    child,
+		Edge:     edge,
+		Resource: res,
 		DeltaIn:  deltaIn,
 		DeltaOut: deltaOut,
 		Delta:    int64(deltafd),
@@ -475,14 +768,16 @@ func (t *trace) AddConns(scope string, deltaIn, deltaOut, deltafd, nconnsIn, nco
 	})
 }
 
-func (t *trace) BlockAddConns(scope string, deltaIn, deltaOut, deltafd, nconnsIn, nconnsOut, nfd int) {
+func (t *trace) ShadowBlockAddConns(scope, child string, res Resource, deltaIn, deltaOut, deltafd, nconnsIn, nconnsOut, nfd int) {
 	if t == nil {
 		return
 	}
 
-	t.push(traceEvt{
-		Type:     traceBlockAddConnEvt,
+	t.push(TraceEvt{
+		Type:     TraceShadowBlockAddConnEvt,
 		Scope:    scope,
+		Child:    child,
+		Resource: res,
 		DeltaIn:  deltaIn,
 		DeltaOut: deltaOut,
 		Delta:    int64(deltafd),
@@ -492,14 +787,15 @@ func (t *trace) BlockAddConns(scope string, deltaIn, deltaOut, deltafd, nconnsIn
 	})
 }
 
-func (t *trace) RemoveConns(scope string, deltaIn, deltaOut, deltafd, nconnsIn, nconnsOut, nfd int) {
+func (t *trace) RemoveConns(scope, child string, deltaIn, deltaOut, deltafd, nconnsIn, nconnsOut, nfd int) {
 	if t == nil {
 		return
 	}
 
-	t.push(traceEvt{
-		Type:     traceRemoveConnEvt,
+	t.push(TraceEvt{
+		Type:     TraceRemoveConnEvt,
 		Scope:    scope,
+		Child:    child,
 		DeltaIn:  -deltaIn,
 		DeltaOut: -deltaOut,
 		Delta:    -int64(deltafd),
@@ -0,0 +1,340 @@
+package rcmgr
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+// TraceAnalysis is the analysis of a resource manager trace.
+type TraceAnalysis struct {
+	// Events is the number of events in the trace.
+	Events int
+	// Scopes holds the analysis of every scope in the trace, keyed by scope name.
+	Scopes map[string]*ScopeTrace
+	// Dropped is the number of events dropped from the trace, by event type; if any events were
+	// dropped, the analysis is incomplete.
+	Dropped map[string]int
+}
+
+// ScopeTrace is the analysis of the events of a single scope in a trace.
+type ScopeTrace struct {
+	Name string
+	// Limit is the limit of the scope at its (last) creation; it is nil if the trace does not
+	// contain the creation of the scope.
+	Limit *BasicLimitConfig
+
+	// Created and Destroyed are the indices of the first creation and the last destruction event
+	// of the scope in the trace, or -1 if there is no such event.
+	Created, Destroyed int
+
+	// Timeline is the usage of the scope after every event that changed it.
+	Timeline []ScopeUsage
+	// Peak is the peak usage of the scope for every resource.
+	Peak map[Resource]int64
+	// Blocked is the number of reservations blocked by the scope limits for every resource.
+	Blocked map[Resource]int
+	// BlockedBy is the number of reservations of the scope blocked by each of its edges, keyed
+	// by the edge name; it is only available for versioned traces.
+	BlockedBy map[string]int
+	// ShadowBlocked is the number of reservations in the scope that would have been blocked
+	// for every resource, when running in shadow mode.
+	ShadowBlocked map[Resource]int
+}
+
+// ScopeUsage is the usage of a scope at some point in a trace.
+type ScopeUsage struct {
+	// Event is the index of the event in the trace.
+	Event int
+	// Time is the time of the event; it is zero for traces without timestamps.
+	Time time.Time
+	Stat network.ScopeStat
+}
+
+// PeakUsage is the peak usage of a resource in a scope, alongside its limit.
+type PeakUsage struct {
+	Resource Resource
+	Peak     int64
+	// Limit is the limit for the resource, or -1 if the limit of the scope is unknown. The
+	// memory limit of dynamic limits is their maximum memory.
+	Limit int64
+}
+
+// ScopeBlocks is the number of blocked reservations in a scope.
+type ScopeBlocks struct {
+	Scope  string
+	Blocks int
+}
+
+// AnalyzeTrace reads all the events from a trace reader and analyzes them. A truncated trace is
+// analyzed up to the last complete event.
+func AnalyzeTrace(r *TraceReader) (*TraceAnalysis, error) {
+	a := &TraceAnalysis{
+		Scopes:  make(map[string]*ScopeTrace),
+		Dropped: make(map[string]int),
+	}
+
+	for {
+		evt, err := r.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return a, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := a.add(evt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (a *TraceAnalysis) scope(name string) *ScopeTrace {
+	s, ok := a.Scopes[name]
+	if !ok {
+		s = &ScopeTrace{
+			Name:          name,
+			Created:       -1,
+			Destroyed:     -1,
+			Peak:          make(map[Resource]int64),
+			Blocked:       make(map[Resource]int),
+			BlockedBy:     make(map[string]int),
+			ShadowBlocked: make(map[Resource]int),
+		}
+		a.Scopes[name] = s
+	}
+	return s
+}
+
+func (a *TraceAnalysis) add(evt *TraceEvt) error {
+	idx := a.Events
+	a.Events++
+
+	for typ, n := range evt.Dropped {
+		a.Dropped[typ] += n
+	}
+
+	if evt.Scope == "" {
+		return nil
+	}
+
+	s := a.scope(evt.Scope)
+
+	switch evt.Type {
+	case TraceCreateScopeEvt:
+		limit, err := evt.LimitConfig()
+		if err != nil {
+			return err
+		}
+		if limit != nil {
+			s.Limit = limit
+		}
+		if s.Created < 0 {
+			s.Created = idx
+		}
+
+	case TraceDestroyScopeEvt:
+		s.Destroyed = idx
+
+	case TraceReserveMemoryEvt, TraceReleaseMemoryEvt:
+		st := s.stat()
+		st.Memory = evt.Memory
+		s.record(idx, evt.Time, st)
+
+	case TraceAddStreamEvt, TraceRemoveStreamEvt:
+		st := s.stat()
+		st.NumStreamsInbound = evt.StreamsIn
+		st.NumStreamsOutbound = evt.StreamsOut
+		s.record(idx, evt.Time, st)
+
+	case TraceAddConnEvt, TraceRemoveConnEvt:
+		st := s.stat()
+		st.NumConnsInbound = evt.ConnsIn
+		st.NumConnsOutbound = evt.ConnsOut
+		st.NumFD = evt.FD
+		s.record(idx, evt.Time, st)
+
+	case TraceBlockReserveMemoryEvt, TraceBlockAddStreamEvt, TraceBlockAddConnEvt:
+		if evt.Edge != "" {
+			s.BlockedBy[evt.Edge]++
+			break
+		}
+		s.Blocked[s.blockedResource(evt)]++
+
+	case TraceShadowBlockReserveMemoryEvt, TraceShadowBlockAddStreamEvt, TraceShadowBlockAddConnEvt:
+		s.ShadowBlocked[s.blockedResource(evt)]++
+	}
+
+	return nil
+}
+
+func (s *ScopeTrace) stat() network.ScopeStat {
+	if len(s.Timeline) == 0 {
+		return network.ScopeStat{}
+	}
+	return s.Timeline[len(s.Timeline)-1].Stat
+}
+
+func (s *ScopeTrace) record(idx int, t time.Time, st network.ScopeStat) {
+	s.Timeline = append(s.Timeline, ScopeUsage{Event: idx, Time: t, Stat: st})
+
+	for _, v := range []struct {
+		resource Resource
+		value    int64
+	}{
+		{ResourceMemory, st.Memory},
+		{ResourceStreamsInbound, int64(st.NumStreamsInbound)},
+		{ResourceStreamsOutbound, int64(st.NumStreamsOutbound)},
+		{ResourceStreams, int64(st.NumStreamsInbound + st.NumStreamsOutbound)},
+		{ResourceConnsInbound, int64(st.NumConnsInbound)},
+		{ResourceConnsOutbound, int64(st.NumConnsOutbound)},
+		{ResourceConns, int64(st.NumConnsInbound + st.NumConnsOutbound)},
+		{ResourceFD, int64(st.NumFD)},
+	} {
+		if v.value > s.Peak[v.resource] {
+			s.Peak[v.resource] = v.value
+		}
+	}
+}
+
+// blockedResource determines the resource whose limit blocked a reservation. Traces without the
+// resource in block events are replayed against the scope limit when it is known, otherwise the
+// resource is inferred from the direction of the reservation.
+func (s *ScopeTrace) blockedResource(evt *TraceEvt) Resource {
+	if evt.Resource != "" {
+		return evt.Resource
+	}
+
+	var rc resources
+	if s.Limit != nil {
+		limit, err := s.Limit.toLimit(BaseLimit{}, MemoryLimit{})
+		if err == nil {
+			rc.limit = limit
+		}
+	}
+
+	var err error
+	switch evt.Type {
+	case TraceBlockReserveMemoryEvt, TraceShadowBlockReserveMemoryEvt:
+		return ResourceMemory
+
+	case TraceBlockAddStreamEvt, TraceShadowBlockAddStreamEvt:
+		if rc.limit != nil {
+			rc.nstreamsIn, rc.nstreamsOut = evt.StreamsIn, evt.StreamsOut
+			err = rc.addStreams(evt.DeltaIn, evt.DeltaOut)
+		}
+		if err == nil {
+			switch {
+			case evt.DeltaOut == 0:
+				return ResourceStreamsInbound
+			case evt.DeltaIn == 0:
+				return ResourceStreamsOutbound
+			default:
+				return ResourceStreams
+			}
+		}
+
+	default:
+		if rc.limit != nil {
+			rc.nconnsIn, rc.nconnsOut, rc.nfd = evt.ConnsIn, evt.ConnsOut, evt.FD
+			err = rc.addConns(evt.DeltaIn, evt.DeltaOut, int(evt.Delta))
+		}
+		if err == nil {
+			switch {
+			case evt.DeltaOut == 0:
+				return ResourceConnsInbound
+			case evt.DeltaIn == 0:
+				return ResourceConnsOutbound
+			default:
+				return ResourceConns
+			}
+		}
+	}
+
+	var lerr *ErrLimitExceeded
+	if errors.As(err, &lerr) {
+		return lerr.Resource
+	}
+	return ResourceStreams
+}
+
+// PeakUsage returns the peak usage of every resource in the scope, alongside its limit.
+func (s *ScopeTrace) PeakUsage() []PeakUsage {
+	var limit Limit
+	if s.Limit != nil {
+		limit, _ = s.Limit.toLimit(BaseLimit{}, MemoryLimit{})
+	}
+
+	result := make([]PeakUsage, 0, 8)
+	for _, res := range []Resource{
+		ResourceMemory,
+		ResourceStreamsInbound,
+		ResourceStreamsOutbound,
+		ResourceStreams,
+		ResourceConnsInbound,
+		ResourceConnsOutbound,
+		ResourceConns,
+		ResourceFD,
+	} {
+		u := PeakUsage{Resource: res, Peak: s.Peak[res], Limit: -1}
+		if limit != nil {
+			u.Limit = resourceLimit(limit, s.Limit, res)
+		}
+		result = append(result, u)
+	}
+
+	return result
+}
+
+func resourceLimit(l Limit, cfg *BasicLimitConfig, res Resource) int64 {
+	switch res {
+	case ResourceMemory:
+		if cfg.Dynamic {
+			return cfg.MaxMemory
+		}
+		return l.GetMemoryLimit()
+	case ResourceStreamsInbound:
+		return int64(l.GetStreamLimit(network.DirInbound))
+	case ResourceStreamsOutbound:
+		return int64(l.GetStreamLimit(network.DirOutbound))
+	case ResourceStreams:
+		return int64(l.GetStreamTotalLimit())
+	case ResourceConnsInbound:
+		return int64(l.GetConnLimit(network.DirInbound))
+	case ResourceConnsOutbound:
+		return int64(l.GetConnLimit(network.DirOutbound))
+	case ResourceConns:
+		return int64(l.GetConnTotalLimit())
+	case ResourceFD:
+		return int64(l.GetFDLimit())
+	default:
+		return -1
+	}
+}
+
+// TopBlocked returns the scopes with the most blocked reservations for a resource, in decreasing
+// order of blocks; scopes without blocks are omitted. If n is positive, at most n scopes are
+// returned.
+func (a *TraceAnalysis) TopBlocked(res Resource, n int) []ScopeBlocks {
+	var result []ScopeBlocks
+	for name, s := range a.Scopes {
+		if blocks := s.Blocked[res]; blocks > 0 {
+			result = append(result, ScopeBlocks{Scope: name, Blocks: blocks})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Blocks != result[j].Blocks {
+			return result[i].Blocks > result[j].Blocks
+		}
+		return result[i].Scope < result[j].Scope
+	})
+
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
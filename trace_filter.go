@@ -0,0 +1,119 @@
+package rcmgr
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// traceFilter selects the events recorded in a trace.
+type traceFilter struct {
+	types  []string // event type patterns; empty for all types
+	scopes []string // scope name patterns; empty for all scopes
+	sample float64  // the fraction of high volume events to retain; 0 for all
+}
+
+// WithTraceEventTypes is a trace option that only records events whose type matches one of the
+// patterns, e.g. "block_*". In patterns, "*" matches any sequence of characters. The start and
+// dropped events are always recorded.
+func WithTraceEventTypes(patterns ...string) TraceOption {
+	return func(t *trace) error {
+		if len(patterns) == 0 {
+			return fmt.Errorf("no trace event type patterns")
+		}
+		t.filter().types = append(t.filter().types, patterns...)
+		return nil
+	}
+}
+
+// WithTraceScopes is a trace option that only records events of scopes whose name matches one of
+// the patterns, e.g. "peer:*" or "protocol:/ipfs/*". In patterns, "*" matches any sequence of
+// characters, including "/".
+func WithTraceScopes(patterns ...string) TraceOption {
+	return func(t *trace) error {
+		if len(patterns) == 0 {
+			return fmt.Errorf("no trace scope patterns")
+		}
+		t.filter().scopes = append(t.filter().scopes, patterns...)
+		return nil
+	}
+}
+
+// WithTraceSampling is a trace option that only records a random sample of the high volume
+// memory, stream and connection reservation and release events, at the given rate in (0, 1].
+// Block events and scope creation and destruction events are always recorded.
+func WithTraceSampling(rate float64) TraceOption {
+	return func(t *trace) error {
+		if rate <= 0 || rate > 1 {
+			return fmt.Errorf("invalid trace sampling rate: %f", rate)
+		}
+		t.filter().sample = rate
+		return nil
+	}
+}
+
+func (t *trace) filter() *traceFilter {
+	if t.filt == nil {
+		t.filt = new(traceFilter)
+	}
+	return t.filt
+}
+
+// keep reports whether an event should be recorded.
+func (f *traceFilter) keep(evt *TraceEvt) bool {
+	switch evt.Type {
+	case TraceStartEvt, TraceDroppedEvt:
+		return true
+	}
+
+	if len(f.types) > 0 && !matchAny(f.types, evt.Type) {
+		return false
+	}
+	if len(f.scopes) > 0 && !matchAny(f.scopes, evt.Scope) {
+		return false
+	}
+
+	if f.sample > 0 {
+		switch evt.Type {
+		case TraceReserveMemoryEvt, TraceReleaseMemoryEvt,
+			TraceAddStreamEvt, TraceRemoveStreamEvt,
+			TraceAddConnEvt, TraceRemoveConnEvt:
+			return rand.Float64() < f.sample
+		}
+	}
+
+	return true
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if globMatch(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches s against a pattern in which "*" matches any sequence of characters.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+
+	return strings.HasSuffix(s, last)
+}
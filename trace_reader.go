@@ -0,0 +1,135 @@
+package rcmgr
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// TraceReader decodes the events of a trace written by a resource manager created with WithTrace.
+//
+// The reader supports all trace versions up to TraceVersion. Events of traces written before the
+// trace format was versioned have no timestamps, and their sequence number is their index in the
+// trace.
+type TraceReader struct {
+	gz  *gzip.Reader
+	dec *json.Decoder
+
+	version int
+	count   uint64
+}
+
+// NewTraceReader creates a new reader for the gzipped trace stream in r.
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	gz, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("error opening trace: %w", err)
+	}
+
+	return &TraceReader{gz: gz, dec: json.NewDecoder(gz)}, nil
+}
+
+// Next returns the next event in the trace; it returns io.EOF at the end of the trace.
+// If the trace was truncated, as is the case with the trace of a running or crashed process,
+// Next returns io.ErrUnexpectedEOF after the last complete event.
+func (r *TraceReader) Next() (*TraceEvt, error) {
+	evt := new(TraceEvt)
+	if err := r.dec.Decode(evt); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error decoding trace event: %w", err)
+	}
+
+	if r.count == 0 && evt.Type == TraceStartEvt {
+		if evt.Version > TraceVersion {
+			return nil, fmt.Errorf("unsupported trace version %d", evt.Version)
+		}
+		r.version = evt.Version
+	}
+	if r.version == 0 {
+		evt.Seq = r.count
+	}
+	r.count++
+
+	return evt, nil
+}
+
+// Version returns the version of the trace format, as recorded in the start event; it is 0 for
+// traces written before the format was versioned, or if the start event has not been read yet.
+func (r *TraceReader) Version() int {
+	return r.version
+}
+
+// Close closes the reader; it does not close the underlying reader.
+func (r *TraceReader) Close() error {
+	return r.gz.Close()
+}
+
+// ReadTraceFile reads the trace at path and calls f for every event in it. Reading stops at the
+// first error returned by f, which is returned to the caller.
+func ReadTraceFile(path string, f func(*TraceEvt) error) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r, err := NewTraceReader(in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for {
+		evt, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := f(evt); err != nil {
+			return err
+		}
+	}
+}
+
+// UnmarshalJSON decodes a trace event, retaining the raw Limit for later decoding.
+func (e *TraceEvt) UnmarshalJSON(b []byte) error {
+	type traceEvt TraceEvt
+	var evt struct {
+		traceEvt
+		Limit json.RawMessage `json:",omitempty"`
+	}
+	if err := json.Unmarshal(b, &evt); err != nil {
+		return err
+	}
+
+	*e = TraceEvt(evt.traceEvt)
+	if len(evt.Limit) > 0 {
+		e.Limit = evt.Limit
+	}
+	return nil
+}
+
+// LimitConfig decodes the Limit of a create_scope event read with a TraceReader. It returns nil
+// if the event has no limit. For dynamic limits, the returned config has Dynamic set and the
+// memory bounds of the limit.
+func (e *TraceEvt) LimitConfig() (*BasicLimitConfig, error) {
+	raw, ok := e.Limit.(json.RawMessage)
+	if !ok || len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	cfg := new(BasicLimitConfig)
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, fmt.Errorf("error decoding limit of %s: %w", e.Scope, err)
+	}
+	cfg.Dynamic = cfg.Dynamic || cfg.MemoryFraction > 0
+	return cfg, nil
+}
@@ -0,0 +1,231 @@
+package rcmgr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestTraceReader(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`{"Type":"create_scope","Scope":"system","Limit":{"StreamsInbound":1,"Memory":1024}}` + "\n"))
+	gz.Write([]byte(`{"Type":"add_stream","Scope":"system","DeltaIn":1,"StreamsIn":1}` + "\n"))
+	// flush without closing, as if the process was still running
+	gz.Flush()
+
+	r, err := NewTraceReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	evt, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.Type != TraceCreateScopeEvt || evt.Scope != "system" || evt.Seq != 0 {
+		t.Fatalf("unexpected event: %+v", evt)
+	}
+	cfg, err := evt.LimitConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.StreamsInbound != 1 || cfg.Memory != 1024 || cfg.Dynamic {
+		t.Fatalf("unexpected limit: %+v", cfg)
+	}
+
+	evt, err = r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the reader numbers the events of unversioned traces
+	if evt.Type != TraceAddStreamEvt || evt.DeltaIn != 1 || evt.StreamsIn != 1 || evt.Seq != 1 {
+		t.Fatalf("unexpected event: %+v", evt)
+	}
+	if r.Version() != 0 || !evt.Time.IsZero() {
+		t.Fatalf("unexpected version %d and time %s", r.Version(), evt.Time)
+	}
+	if cfg, err := evt.LimitConfig(); err != nil || cfg != nil {
+		t.Fatalf("expected no limit, got %+v %v", cfg, err)
+	}
+
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+}
+
+// writeTestTrace writes a trace in which an inbound stream is allowed and a second one is blocked
+// by the transient scope, and returns its path.
+func writeTestTrace(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "rcmgr.json.gz")
+
+	limiter := NewDefaultLimiter()
+	limiter.TransientLimits = &StaticLimit{
+		Memory: 4096,
+		BaseLimit: BaseLimit{
+			StreamsInbound:  1,
+			StreamsOutbound: 1,
+			Streams:         2,
+			ConnsInbound:    1,
+			ConnsOutbound:   1,
+			Conns:           2,
+			FD:              1,
+		},
+	}
+
+	mgr, err := NewResourceManager(limiter, WithTrace(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the simulator resolves peer scopes by name, so the trace must have valid peer IDs
+	peerA, err := peer.Decode("QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerB, err := peer.Decode("QmZ4ekCHNYSdqcvnGNMtUndPpRUJ3bD3E4zWcj4oPv9ytN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := mgr.OpenStream(peerA, network.DirInbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.ReserveMemory(1024, network.ReservationPriorityAlways); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.OpenStream(peerB, network.DirInbound); err == nil {
+		t.Fatal("expected transient stream to be blocked")
+	}
+	stream.ReleaseMemory(1024)
+	stream.Done()
+
+	mgr.Close()
+
+	return path
+}
+
+func TestTraceAnalysis(t *testing.T) {
+	path := writeTestTrace(t)
+
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	r, err := NewTraceReader(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	a, err := AnalyzeTrace(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Events == 0 {
+		t.Fatal("expected events")
+	}
+
+	transient, ok := a.Scopes["transient"]
+	if !ok {
+		t.Fatal("missing transient scope")
+	}
+	if transient.Created < 0 || transient.Limit == nil || transient.Limit.StreamsInbound != 1 {
+		t.Fatalf("unexpected transient scope: %+v", transient)
+	}
+	if transient.Blocked[ResourceStreamsInbound] != 1 {
+		t.Fatalf("expected 1 blocked inbound stream, got %v", transient.Blocked)
+	}
+	if last := transient.Timeline[len(transient.Timeline)-1].Stat; last != (network.ScopeStat{}) {
+		t.Fatalf("expected no usage at the end of the trace, got %+v", last)
+	}
+
+	for _, u := range transient.PeakUsage() {
+		switch u.Resource {
+		case ResourceStreamsInbound:
+			if u.Peak != 1 || u.Limit != 1 {
+				t.Fatalf("unexpected peak usage: %+v", u)
+			}
+		case ResourceMemory:
+			if u.Peak != 1024 || u.Limit != 4096 {
+				t.Fatalf("unexpected peak usage: %+v", u)
+			}
+		case ResourceConns:
+			if u.Peak != 0 || u.Limit != 2 {
+				t.Fatalf("unexpected peak usage: %+v", u)
+			}
+		}
+	}
+
+	top := a.TopBlocked(ResourceStreamsInbound, 1)
+	if len(top) != 1 || top[0].Scope != "transient" || top[0].Blocks != 1 {
+		t.Fatalf("unexpected top blocked scopes: %+v", top)
+	}
+	if top := a.TopBlocked(ResourceMemory, 0); len(top) != 0 {
+		t.Fatalf("unexpected top blocked scopes: %+v", top)
+	}
+
+	if system := a.Scopes["system"]; system.Peak[ResourceMemory] != 1024 {
+		t.Fatalf("unexpected system peak memory: %d", system.Peak[ResourceMemory])
+	}
+}
+
+func TestTraceEventDetails(t *testing.T) {
+	path := writeTestTrace(t)
+
+	var evts []*TraceEvt
+	err := ReadTraceFile(path, func(evt *TraceEvt) error {
+		evts = append(evts, evt)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if start := evts[0]; start.Type != TraceStartEvt || start.Version != TraceVersion {
+		t.Fatalf("unexpected start event: %+v", start)
+	}
+
+	var edgeBlock, childBlock *TraceEvt
+	for i, evt := range evts {
+		if evt.Seq != uint64(i) {
+			t.Fatalf("expected sequence number %d, got %d", i, evt.Seq)
+		}
+		if evt.Time.IsZero() {
+			t.Fatalf("missing time in event %+v", evt)
+		}
+		if i > 0 && evt.Mono < evts[i-1].Mono {
+			t.Fatalf("monotonic time went backwards in event %+v", evt)
+		}
+
+		if evt.Type != TraceBlockAddStreamEvt {
+			continue
+		}
+		if evt.Edge != "" {
+			edgeBlock = evt
+		} else {
+			childBlock = evt
+		}
+	}
+
+	// the block is recorded in the blocking transient scope, with the stream as child, and in the
+	// stream scope, with the transient scope as edge
+	if childBlock == nil || childBlock.Scope != "transient" || !strings.HasPrefix(childBlock.Child, "stream-") {
+		t.Fatalf("unexpected block event: %+v", childBlock)
+	}
+	if edgeBlock == nil || edgeBlock.Scope != childBlock.Child || edgeBlock.Edge != "transient" {
+		t.Fatalf("unexpected edge block event: %+v", edgeBlock)
+	}
+}
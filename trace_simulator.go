@@ -0,0 +1,481 @@
+package rcmgr
+
+import (
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// SimulationResult is the outcome of replaying a trace against a candidate limiter.
+type SimulationResult struct {
+	// Events is the number of events in the trace.
+	Events int
+	// Replayed is the number of reservation and release events that were replayed.
+	Replayed int
+	// Skipped is the number of reservation and release events that were not replayed, because
+	// their scope could not be resolved or is not constrained by the candidate limiter, or because
+	// they are blocks of an unversioned trace.
+	Skipped int
+
+	// NewlyBlocked are the reservations that succeeded in the trace but would be blocked by the
+	// candidate limiter.
+	NewlyBlocked []SimulatedEvent
+	// NewlyAllowed are the reservations that were blocked in the trace but would be allowed by
+	// the candidate limiter.
+	NewlyAllowed []SimulatedEvent
+}
+
+// SimulatedEvent is a trace event whose outcome differs under the candidate limiter.
+type SimulatedEvent struct {
+	// Index is the index of the event in the trace.
+	Index int
+	Event *TraceEvt
+	// Err is the error returned by the candidate limiter for newly blocked reservations.
+	Err error
+}
+
+// SimulateTraceFile replays the trace at tracePath against the JSON limiter configuration at
+// configPath, using the default limits for fallback.
+func SimulateTraceFile(tracePath, configPath string) (*SimulationResult, error) {
+	cfg, err := os.Open(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cfg.Close()
+
+	limits, err := NewDefaultLimiterFromJSON(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := os.Open(tracePath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	r, err := NewTraceReader(in)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return SimulateTrace(r, limits)
+}
+
+// SimulateTrace replays the reservation and release events of a trace through a resource manager
+// using the candidate limiter, and reports the reservations whose outcome would differ.
+//
+// Every event is replayed in the scope it was recorded in, which is checked against its candidate
+// limit in isolation; a reservation that is newly blocked in a scope is not accounted there, and
+// neither are the matching releases. Reservations that are newly allowed are released right away,
+// as the trace does not contain their release.
+//
+// Rate limits are checked against the time of the events rather than the wall clock, as the trace
+// is replayed much faster than it was recorded; they are not enforced for traces without
+// timestamps.
+//
+// Traces written before the trace format was versioned record a block caused by an edge in the
+// blocked scope as well, without naming the blocking edge; as the scope that actually blocked the
+// reservation cannot be determined, the blocks of these traces are skipped, and only the
+// reservations that would be newly blocked are reported.
+func SimulateTrace(r *TraceReader, limits Limiter) (*SimulationResult, error) {
+	sim := &simulator{
+		scopes: make(map[string]*simScope),
+		result: new(SimulationResult),
+	}
+
+	mgr, err := NewResourceManager(limits, withClock(sim.clock))
+	if err != nil {
+		return nil, err
+	}
+	defer mgr.Close()
+
+	sim.mgr = mgr.(*resourceManager)
+	defer sim.done()
+
+	for {
+		evt, err := r.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sim.result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sim.versioned = r.Version() > 0
+		sim.replay(evt)
+	}
+}
+
+type simulator struct {
+	mgr    *resourceManager
+	scopes map[string]*simScope
+	result *SimulationResult
+
+	now       time.Time // the time of the replayed event
+	versioned bool      // true if the trace records the blocking edge of blocks
+}
+
+type simScope struct {
+	scope *resourceScope // nil if the scope is not constrained
+	leaf  bool           // true for conn, stream and span scopes, which are owned by the simulator
+	proto protocol.ID    // the protocol of stream scopes, once attached to it
+
+	// usage of newly blocked reservations, which is skipped on release
+	skipped network.ScopeStat
+}
+
+// clock is the time source of the rate limits of the simulated resource manager.
+func (sim *simulator) clock() time.Time {
+	return sim.now
+}
+
+func (sim *simulator) done() {
+	for _, s := range sim.scopes {
+		s.release()
+	}
+}
+
+func (s *simScope) release() {
+	if s.scope == nil {
+		return
+	}
+	if s.leaf {
+		s.scope.Done()
+	} else {
+		s.scope.DecRef()
+	}
+}
+
+func (sim *simulator) replay(evt *TraceEvt) {
+	idx := sim.result.Events
+	sim.result.Events++
+	sim.now = evt.Time
+
+	if evt.Scope == "" {
+		return
+	}
+
+	switch evt.Type {
+	case TraceCreateScopeEvt:
+		sim.scope(evt.Scope)
+		return
+
+	case TraceDestroyScopeEvt:
+		if s, ok := sim.scopes[evt.Scope]; ok {
+			s.release()
+			delete(sim.scopes, evt.Scope)
+		}
+		return
+	}
+
+	// blocks recorded in a scope on behalf of its blocking edge are replayed in the edge
+	if evt.Edge != "" {
+		return
+	}
+
+	st, reserve, blocked := simStat(evt)
+	if st == (network.ScopeStat{}) {
+		return
+	}
+
+	// blocks of unversioned traces may have been recorded on behalf of an unknown edge
+	if blocked && !sim.versioned {
+		sim.result.Skipped++
+		return
+	}
+
+	if reserve && !blocked {
+		sim.attach(evt)
+	}
+
+	s := sim.scope(evt.Scope)
+	if s.scope == nil {
+		sim.result.Skipped++
+		return
+	}
+	sim.result.Replayed++
+
+	if !reserve {
+		s.releaseStat(evt.Child, st)
+		return
+	}
+
+	var err error
+	if st.Memory > 0 {
+		err = s.scope.ReserveMemoryForChild(evt.Child, st.Memory, evt.Priority)
+	} else {
+		err = s.scope.ReserveForChild(evt.Child, st)
+	}
+
+	switch {
+	case blocked && err == nil:
+		sim.result.NewlyAllowed = append(sim.result.NewlyAllowed, SimulatedEvent{Index: idx, Event: evt})
+		s.scope.ReleaseForChild(evt.Child, st)
+
+	case !blocked && err != nil:
+		sim.result.NewlyBlocked = append(sim.result.NewlyBlocked, SimulatedEvent{Index: idx, Event: evt, Err: err})
+		s.skip(st)
+	}
+}
+
+// attach follows a stream of the trace into the peer, protocol and service scopes it is reserved
+// in, and updates the limit of the simulated stream as the resource manager does once the peer,
+// protocol and service of the stream are known.
+func (sim *simulator) attach(evt *TraceEvt) {
+	if !strings.HasPrefix(evt.Child, "stream-") {
+		return
+	}
+	s, ok := sim.scopes[evt.Child]
+	if !ok || s.scope == nil {
+		return
+	}
+
+	limits := sim.mgr.limiter()
+	var limit Limit
+	switch {
+	case strings.HasPrefix(evt.Scope, "peer:"):
+		p, err := peer.Decode(strings.TrimPrefix(evt.Scope, "peer:"))
+		if err != nil {
+			return
+		}
+		limit = limits.GetStreamLimits(p)
+
+	case strings.HasPrefix(evt.Scope, "protocol:"):
+		proto, p, ok := splitPeerScope(strings.TrimPrefix(evt.Scope, "protocol:"))
+		if !ok || p == "" {
+			return
+		}
+		s.proto = protocol.ID(proto)
+		limit = limits.GetProtocolStreamLimits(p, s.proto)
+
+	default:
+		svc, p, ok := splitServicePeerScope(evt.Scope)
+		if !ok {
+			return
+		}
+		limit = limits.GetServiceStreamLimits(p, s.proto, svc)
+	}
+
+	if limit != nil && !reflect.DeepEqual(s.scope.Limit(), limit) {
+		s.scope.SetLimit(limit)
+	}
+}
+
+// splitPeerScope splits the name of a service or protocol peer scope, without its kind prefix,
+// into the service or protocol and the peer; the peer is empty for the service or protocol scope
+// itself.
+func splitPeerScope(name string) (string, peer.ID, bool) {
+	i := strings.LastIndex(name, ".peer:")
+	if i < 0 {
+		return name, "", true
+	}
+	p, err := peer.Decode(name[i+len(".peer:"):])
+	if err != nil {
+		return "", "", false
+	}
+	return name[:i], p, true
+}
+
+// splitServicePeerScope splits the name of a service peer scope into the service and the peer;
+// service peer scopes are named after the service, without the kind prefix.
+func splitServicePeerScope(name string) (string, peer.ID, bool) {
+	svc, p, ok := splitPeerScope(name)
+	return svc, p, ok && p != ""
+}
+
+// simStat returns the usage change of a reservation or release event, whether it is a
+// reservation and whether it was blocked in the trace.
+func simStat(evt *TraceEvt) (st network.ScopeStat, reserve, blocked bool) {
+	switch evt.Type {
+	case TraceReserveMemoryEvt, TraceBlockReserveMemoryEvt:
+		st.Memory = evt.Delta
+		return st, true, evt.Type == TraceBlockReserveMemoryEvt
+
+	case TraceReleaseMemoryEvt:
+		st.Memory = evt.Delta
+		return st, false, false
+
+	case TraceAddStreamEvt, TraceBlockAddStreamEvt:
+		st.NumStreamsInbound = evt.DeltaIn
+		st.NumStreamsOutbound = evt.DeltaOut
+		return st, true, evt.Type == TraceBlockAddStreamEvt
+
+	case TraceRemoveStreamEvt:
+		st.NumStreamsInbound = -evt.DeltaIn
+		st.NumStreamsOutbound = -evt.DeltaOut
+		return st, false, false
+
+	case TraceAddConnEvt, TraceBlockAddConnEvt:
+		st.NumConnsInbound = evt.DeltaIn
+		st.NumConnsOutbound = evt.DeltaOut
+		st.NumFD = int(evt.Delta)
+		return st, true, evt.Type == TraceBlockAddConnEvt
+
+	case TraceRemoveConnEvt:
+		st.NumConnsInbound = -evt.DeltaIn
+		st.NumConnsOutbound = -evt.DeltaOut
+		st.NumFD = -int(evt.Delta)
+		return st, false, false
+
+	default:
+		// shadow blocks are followed by the forced reservation, which is replayed instead
+		return st, false, false
+	}
+}
+
+func (s *simScope) skip(st network.ScopeStat) {
+	s.skipped.Memory += st.Memory
+	s.skipped.NumStreamsInbound += st.NumStreamsInbound
+	s.skipped.NumStreamsOutbound += st.NumStreamsOutbound
+	s.skipped.NumConnsInbound += st.NumConnsInbound
+	s.skipped.NumConnsOutbound += st.NumConnsOutbound
+	s.skipped.NumFD += st.NumFD
+}
+
+// releaseStat releases usage from the scope, discounting the usage of skipped reservations first.
+func (s *simScope) releaseStat(child string, st network.ScopeStat) {
+	discount := func(v *int64, skipped *int64) {
+		d := *skipped
+		if d > *v {
+			d = *v
+		}
+		*v -= d
+		*skipped -= d
+	}
+	discountInt := func(v *int, skipped *int) {
+		v64, skipped64 := int64(*v), int64(*skipped)
+		discount(&v64, &skipped64)
+		*v, *skipped = int(v64), int(skipped64)
+	}
+
+	discount(&st.Memory, &s.skipped.Memory)
+	discountInt(&st.NumStreamsInbound, &s.skipped.NumStreamsInbound)
+	discountInt(&st.NumStreamsOutbound, &s.skipped.NumStreamsOutbound)
+	discountInt(&st.NumConnsInbound, &s.skipped.NumConnsInbound)
+	discountInt(&st.NumConnsOutbound, &s.skipped.NumConnsOutbound)
+	discountInt(&st.NumFD, &s.skipped.NumFD)
+
+	if st != (network.ScopeStat{}) {
+		s.scope.ReleaseForChild(child, st)
+	}
+}
+
+// scope resolves a scope of the trace by name to the corresponding scope of the simulated
+// resource manager.
+func (sim *simulator) scope(name string) *simScope {
+	s, ok := sim.scopes[name]
+	if !ok {
+		s = sim.resolve(name)
+		sim.scopes[name] = s
+	}
+	return s
+}
+
+func (sim *simulator) resolve(name string) *simScope {
+	r := sim.mgr
+
+	switch {
+	case name == "system":
+		r.system.IncRef()
+		return &simScope{scope: r.system.resourceScope}
+
+	case name == "transient":
+		r.transient.IncRef()
+		return &simScope{scope: r.transient.resourceScope}
+
+	case name == "allowlisted-system":
+		r.allowlistedSystem.IncRef()
+		return &simScope{scope: r.allowlistedSystem.resourceScope}
+
+	case name == "allowlisted-transient":
+		r.allowlistedTransient.IncRef()
+		return &simScope{scope: r.allowlistedTransient.resourceScope}
+
+	case strings.HasSuffix(name, ".span"):
+		owner := sim.scope(strings.TrimSuffix(name, ".span"))
+		if owner.scope == nil {
+			return &simScope{}
+		}
+		return &simScope{scope: r.newResourceScope(owner.scope.Limit(), nil, name, ScopeKindSpan), leaf: true}
+
+	case strings.HasPrefix(name, "conn-"):
+		return &simScope{scope: r.newResourceScope(r.limiter().GetConnLimits(), nil, name, ScopeKindConn), leaf: true}
+
+	case strings.HasPrefix(name, "stream-"):
+		return &simScope{scope: r.newResourceScope(r.limiter().GetStreamLimits(""), nil, name, ScopeKindStream), leaf: true}
+
+	case strings.HasPrefix(name, "service:"):
+		return &simScope{scope: r.getServiceScope(strings.TrimPrefix(name, "service:")).resourceScope}
+
+	case strings.HasPrefix(name, "protocol:"):
+		proto, p, ok := splitPeerScope(strings.TrimPrefix(name, "protocol:"))
+		if !ok {
+			return &simScope{}
+		}
+		if p != "" {
+			s := r.getProtocolScope(protocol.ID(proto))
+			defer s.DecRef()
+			return &simScope{scope: s.getPeerScope(p)}
+		}
+		return &simScope{scope: r.getProtocolScope(protocol.ID(proto)).resourceScope}
+
+	case strings.HasPrefix(name, "peer:"):
+		p, err := peer.Decode(strings.TrimPrefix(name, "peer:"))
+		if err != nil {
+			return &simScope{}
+		}
+		return &simScope{scope: r.getPeerScope(p).resourceScope}
+
+	case strings.HasPrefix(name, "ip:"):
+		addr, err := manet.FromIP(net.ParseIP(strings.TrimPrefix(name, "ip:")))
+		if err != nil {
+			return &simScope{}
+		}
+		ips, subnets := r.getAddrScopes(addr)
+		if subnets != nil {
+			subnets.DecRef()
+		}
+		if ips == nil {
+			return &simScope{}
+		}
+		return &simScope{scope: ips.resourceScope}
+
+	case strings.HasPrefix(name, "subnet:"):
+		_, subnet, err := net.ParseCIDR(strings.TrimPrefix(name, "subnet:"))
+		if err != nil {
+			return &simScope{}
+		}
+		addr, err := manet.FromIP(subnet.IP)
+		if err != nil {
+			return &simScope{}
+		}
+		ips, subnets := r.getAddrScopes(addr)
+		if ips != nil {
+			ips.DecRef()
+		}
+		if subnets == nil {
+			return &simScope{}
+		}
+		return &simScope{scope: subnets.resourceScope}
+
+	default:
+		svc, p, ok := splitServicePeerScope(name)
+		if !ok {
+			return &simScope{}
+		}
+		s := r.getServiceScope(svc)
+		defer s.DecRef()
+		return &simScope{scope: s.getPeerScope(p)}
+	}
+}
@@ -0,0 +1,204 @@
+package rcmgr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+func simulateTestTrace(t *testing.T, path string, limits Limiter) *SimulationResult {
+	t.Helper()
+
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	r, err := NewTraceReader(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	result, err := SimulateTrace(r, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestSimulateTrace(t *testing.T) {
+	path := writeTestTrace(t)
+
+	// the default limits allow the blocked stream
+	result := simulateTestTrace(t, path, NewDefaultLimiter())
+	if result.Replayed == 0 || result.Skipped != 0 {
+		t.Fatalf("unexpected replay counts: %+v", result)
+	}
+	if len(result.NewlyBlocked) != 0 {
+		t.Fatalf("unexpected newly blocked events: %+v", result.NewlyBlocked)
+	}
+	if len(result.NewlyAllowed) != 1 {
+		t.Fatalf("expected 1 newly allowed event, got %+v", result.NewlyAllowed)
+	}
+	if evt := result.NewlyAllowed[0].Event; evt.Type != TraceBlockAddStreamEvt || evt.Scope != "transient" {
+		t.Fatalf("unexpected newly allowed event: %+v", evt)
+	}
+
+	// a tighter peer memory limit blocks the memory reservation
+	limiter := NewDefaultLimiter()
+	limiter.DefaultPeerLimits = &StaticLimit{
+		Memory:    512,
+		BaseLimit: DefaultLimits.PeerBaseLimit,
+	}
+	result = simulateTestTrace(t, path, limiter)
+	if len(result.NewlyBlocked) != 1 {
+		t.Fatalf("expected 1 newly blocked event, got %+v", result.NewlyBlocked)
+	}
+	blocked := result.NewlyBlocked[0]
+	if blocked.Event.Type != TraceReserveMemoryEvt || !strings.HasPrefix(blocked.Event.Scope, "peer:") {
+		t.Fatalf("unexpected newly blocked event: %+v", blocked.Event)
+	}
+	var lerr *ErrLimitExceeded
+	if !errors.As(blocked.Err, &lerr) || lerr.Resource != ResourceMemory {
+		t.Fatalf("unexpected error: %v", blocked.Err)
+	}
+}
+
+func TestSimulateTraceStreamLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rcmgr.json.gz")
+	mgr, err := NewResourceManager(NewDefaultLimiter(), WithTrace(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := peer.Decode("QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, svc := range []string{"", "svc"} {
+		stream, err := mgr.OpenStream(p, network.DirInbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.SetProtocol("/test"); err != nil {
+			t.Fatal(err)
+		}
+		if svc != "" {
+			if err := stream.SetService(svc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := stream.ReserveMemory(8192, network.ReservationPriorityAlways); err != nil {
+			t.Fatal(err)
+		}
+		stream.ReleaseMemory(8192)
+		stream.Done()
+	}
+	mgr.Close()
+
+	// the streams are limited by the limits of their protocol and service
+	limiter := NewDefaultLimiter()
+	limiter.ProtocolStreamLimits = map[protocol.ID]Limit{
+		"/test": &StaticLimit{Memory: 4096, BaseLimit: DefaultLimits.StreamBaseLimit},
+	}
+	limiter.ServiceStreamLimits = map[string]Limit{
+		"svc": &StaticLimit{Memory: 16384, BaseLimit: DefaultLimits.StreamBaseLimit},
+	}
+	result := simulateTestTrace(t, path, limiter)
+	if len(result.NewlyBlocked) != 1 {
+		t.Fatalf("expected 1 newly blocked event, got %+v", result.NewlyBlocked)
+	}
+	if evt := result.NewlyBlocked[0].Event; evt.Type != TraceReserveMemoryEvt || !strings.HasPrefix(evt.Scope, "stream-") {
+		t.Fatalf("unexpected newly blocked event: %+v", evt)
+	}
+}
+
+func TestSimulateTraceRateLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rcmgr.json.gz")
+	mgr, err := NewResourceManager(NewDefaultLimiter(), WithTrace(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := peer.Decode("QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second stream is opened after the bucket refilled, the third one right away
+	for i := 0; i < 3; i++ {
+		if i == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		stream, err := mgr.OpenStream(p, network.DirInbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Done()
+	}
+	mgr.Close()
+
+	// the rate limits are checked against the time of the events, so only the third stream is
+	// blocked even though the trace is replayed at once
+	const cfg = `{"PeerDefault": {"StreamRateInbound": {"Rate": 10, "Burst": 1}}}`
+	limiter, err := NewLimiterFromJSONStrict(strings.NewReader(cfg), DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := simulateTestTrace(t, path, limiter)
+	if len(result.NewlyBlocked) != 1 {
+		t.Fatalf("expected 1 newly blocked event, got %+v", result.NewlyBlocked)
+	}
+	blocked := result.NewlyBlocked[0]
+	if blocked.Event.Type != TraceAddStreamEvt || blocked.Event.Child != "stream-3" {
+		t.Fatalf("unexpected newly blocked event: %+v", blocked.Event)
+	}
+	var lerr *ErrLimitExceeded
+	if !errors.As(blocked.Err, &lerr) || lerr.Resource != ResourceStreamRateInbound {
+		t.Fatalf("unexpected error: %v", blocked.Err)
+	}
+}
+
+func TestSimulateUnversionedTrace(t *testing.T) {
+	// a trace written before the format was versioned, with a stream blocked by its peer scope
+	// that is recorded in the stream scope as well
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, evt := range []string{
+		`{"Type":"start"}`,
+		`{"Type":"create_scope","Scope":"stream-1"}`,
+		`{"Type":"add_stream","Scope":"transient","Child":"stream-1","DeltaIn":1,"StreamsIn":1}`,
+		`{"Type":"block_add_stream","Scope":"peer:QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC","Child":"stream-1","DeltaIn":1}`,
+		`{"Type":"block_add_stream","Scope":"stream-1","DeltaIn":1}`,
+	} {
+		gz.Write([]byte(evt + "\n"))
+	}
+	gz.Close()
+
+	r, err := NewTraceReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// the blocks cannot be attributed to the blocking scope, so they are not replayed
+	result, err := SimulateTrace(r, NewDefaultLimiter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Replayed != 1 || result.Skipped != 2 {
+		t.Fatalf("unexpected replay counts: %+v", result)
+	}
+	if len(result.NewlyAllowed) != 0 || len(result.NewlyBlocked) != 0 {
+		t.Fatalf("unexpected simulated events: %+v", result)
+	}
+}
@@ -0,0 +1,332 @@
+package rcmgr
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// TraceSink is a destination for the events of a resource manager trace.
+//
+// Events are written in batches from a single background goroutine; once Write returns an error,
+// tracing stops and the sink is closed.
+type TraceSink interface {
+	// Write writes a batch of trace events.
+	Write(evts []TraceEvt) error
+	// Close flushes any buffered events and releases the resources of the sink.
+	Close() error
+}
+
+// writerTraceSink writes a trace as a gzipped JSON stream, the format read by TraceReader.
+type writerTraceSink struct {
+	out  io.Writer
+	gz   *gzip.Writer
+	json *json.Encoder
+}
+
+var _ TraceSink = (*writerTraceSink)(nil)
+
+// NewWriterTraceSink creates a trace sink that writes a gzipped JSON stream to w. Every batch of
+// events is flushed to w. Closing the sink does not close w.
+func NewWriterTraceSink(w io.Writer) TraceSink {
+	return newWriterTraceSink(w)
+}
+
+func newWriterTraceSink(w io.Writer) *writerTraceSink {
+	gz := gzip.NewWriter(w)
+	return &writerTraceSink{out: w, gz: gz, json: json.NewEncoder(gz)}
+}
+
+func (s *writerTraceSink) Write(evts []TraceEvt) error {
+	for _, evt := range evts {
+		if err := s.json.Encode(evt); err != nil {
+			return err
+		}
+	}
+
+	return s.gz.Flush()
+}
+
+func (s *writerTraceSink) Close() error {
+	return s.gz.Close()
+}
+
+// fileTraceSink writes a trace to a file.
+type fileTraceSink struct {
+	*writerTraceSink
+	f *os.File
+}
+
+// NewFileTraceSink creates a trace sink that writes a gzipped JSON stream to a file at path,
+// truncating any existing file.
+func NewFileTraceSink(path string) (TraceSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileTraceSink{writerTraceSink: newWriterTraceSink(f), f: f}, nil
+}
+
+func (s *fileTraceSink) Close() error {
+	err := s.writerTraceSink.Close()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// rotatingTraceSink writes a trace to a set of files with bounded size.
+type rotatingTraceSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	// the start event of the trace, which is repeated at the beginning of every file
+	start *TraceEvt
+
+	f    *os.File
+	size *countingWriter
+	out  *writerTraceSink
+}
+
+var _ TraceSink = (*rotatingTraceSink)(nil)
+
+// NewRotatingFileTraceSink creates a trace sink that writes to a rotating set of files. Events are
+// written to the file at path; once its (compressed) size exceeds maxSize bytes, the file is
+// rotated to path.1, the previous path.1 to path.2 and so on, keeping at most maxFiles files in
+// total, including the current one. Every file is a complete trace that can be read on its own.
+func NewRotatingFileTraceSink(path string, maxSize int64, maxFiles int) (TraceSink, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid maximum trace file size: %d", maxSize)
+	}
+	if maxFiles < 1 {
+		return nil, fmt.Errorf("invalid maximum number of trace files: %d", maxFiles)
+	}
+
+	s := &rotatingTraceSink{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := s.openFile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *rotatingTraceSink) openFile() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.f = f
+	s.size = &countingWriter{w: f}
+	s.out = newWriterTraceSink(s.size)
+	return nil
+}
+
+func (s *rotatingTraceSink) closeFile() error {
+	err := s.out.Close()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *rotatingTraceSink) Write(evts []TraceEvt) error {
+	if s.start == nil && len(evts) > 0 && evts[0].Type == TraceStartEvt {
+		start := evts[0]
+		s.start = &start
+	}
+
+	if err := s.out.Write(evts); err != nil {
+		return err
+	}
+
+	if s.size.n < s.maxSize {
+		return nil
+	}
+
+	return s.rotate()
+}
+
+func (s *rotatingTraceSink) rotate() error {
+	if err := s.closeFile(); err != nil {
+		return err
+	}
+
+	if s.maxFiles == 1 {
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+	}
+
+	for i := s.maxFiles - 1; i > 0; i-- {
+		from := s.path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", s.path, i-1)
+		}
+		err := os.Rename(from, fmt.Sprintf("%s.%d", s.path, i))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err := s.openFile(); err != nil {
+		return err
+	}
+
+	if s.start != nil {
+		return s.out.Write([]TraceEvt{*s.start})
+	}
+	return nil
+}
+
+func (s *rotatingTraceSink) Close() error {
+	return s.closeFile()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// RingBufferTraceSink is a trace sink that retains the most recent events in memory.
+type RingBufferTraceSink struct {
+	mx    sync.Mutex
+	evts  []TraceEvt
+	next  int
+	full  bool
+	start *TraceEvt
+}
+
+var _ TraceSink = (*RingBufferTraceSink)(nil)
+
+// NewRingBufferTraceSink creates a trace sink that retains the last size events in memory.
+func NewRingBufferTraceSink(size int) (*RingBufferTraceSink, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("invalid trace ring buffer size: %d", size)
+	}
+
+	return &RingBufferTraceSink{evts: make([]TraceEvt, size)}, nil
+}
+
+func (s *RingBufferTraceSink) Write(evts []TraceEvt) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, evt := range evts {
+		if evt.Type == TraceStartEvt && s.start == nil {
+			start := evt
+			s.start = &start
+		}
+
+		s.evts[s.next] = evt
+		s.next++
+		if s.next == len(s.evts) {
+			s.next = 0
+			s.full = true
+		}
+	}
+
+	return nil
+}
+
+func (s *RingBufferTraceSink) Close() error {
+	return nil
+}
+
+// Events returns the events retained in the buffer, from the oldest to the most recent.
+func (s *RingBufferTraceSink) Events() []TraceEvt {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if !s.full {
+		return append([]TraceEvt(nil), s.evts[:s.next]...)
+	}
+
+	result := make([]TraceEvt, 0, len(s.evts))
+	result = append(result, s.evts[s.next:]...)
+	return append(result, s.evts[:s.next]...)
+}
+
+// WriteTo writes the events retained in the buffer to w as a gzipped JSON stream, preceded by the
+// start event of the trace if it is no longer in the buffer, so that it can be read with a
+// TraceReader.
+func (s *RingBufferTraceSink) WriteTo(w io.Writer) (int64, error) {
+	evts := s.Events()
+
+	s.mx.Lock()
+	start := s.start
+	s.mx.Unlock()
+
+	if start != nil && (len(evts) == 0 || evts[0].Type != TraceStartEvt) {
+		evts = append([]TraceEvt{*start}, evts...)
+	}
+
+	cw := &countingWriter{w: w}
+	out := newWriterTraceSink(cw)
+	if err := out.Write(evts); err != nil {
+		return cw.n, err
+	}
+	err := out.Close()
+	return cw.n, err
+}
+
+// fanOutTraceSink writes a trace to multiple sinks.
+type fanOutTraceSink struct {
+	sinks []TraceSink
+}
+
+var _ TraceSink = (*fanOutTraceSink)(nil)
+
+// NewFanOutTraceSink creates a trace sink that writes events to all of the given sinks. A sink that
+// fails to write is closed and dropped, while writing continues to the rest; the fan-out sink
+// only fails when all of its sinks have failed.
+func NewFanOutTraceSink(sinks ...TraceSink) (TraceSink, error) {
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("no trace sinks")
+	}
+
+	return &fanOutTraceSink{sinks: append([]TraceSink(nil), sinks...)}, nil
+}
+
+func (s *fanOutTraceSink) Write(evts []TraceEvt) error {
+	var lastErr error
+	sinks := s.sinks[:0]
+	for _, sink := range s.sinks {
+		if err := sink.Write(evts); err != nil {
+			log.Warnf("error writing rcmgr trace sink; dropping sink: %s", err)
+			if err := sink.Close(); err != nil {
+				log.Warnf("error closing rcmgr trace sink: %s", err)
+			}
+			lastErr = err
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+	s.sinks = sinks
+
+	if len(s.sinks) == 0 {
+		return fmt.Errorf("all trace sinks failed: %w", lastErr)
+	}
+	return nil
+}
+
+func (s *fanOutTraceSink) Close() error {
+	var result error
+	for _, sink := range s.sinks {
+		if err := sink.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
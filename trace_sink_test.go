@@ -0,0 +1,182 @@
+package rcmgr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readTestTrace(t *testing.T, in io.Reader) []*TraceEvt {
+	t.Helper()
+
+	r, err := NewTraceReader(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var evts []*TraceEvt
+	for {
+		evt, err := r.Next()
+		if err == io.EOF {
+			return evts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		evts = append(evts, evt)
+	}
+}
+
+func TestWriterTraceSink(t *testing.T) {
+	var buf bytes.Buffer
+	mgr, err := NewResourceManager(NewDefaultLimiter(), WithTraceSink(NewWriterTraceSink(&buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	evts := readTestTrace(t, &buf)
+	if len(evts) == 0 || evts[0].Type != TraceStartEvt {
+		t.Fatalf("unexpected events: %+v", evts)
+	}
+}
+
+func TestTraceSinkOpenError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "rcmgr.json.gz")
+	if _, err := NewResourceManager(NewDefaultLimiter(), WithTrace(path)); err == nil {
+		t.Fatal("expected error opening the trace")
+	}
+}
+
+func testTraceEvts(n int) []TraceEvt {
+	evts := []TraceEvt{{Version: TraceVersion, Type: TraceStartEvt}}
+	for i := 1; i < n; i++ {
+		evts = append(evts, TraceEvt{Seq: uint64(i), Type: TraceCreateScopeEvt, Scope: fmt.Sprintf("stream-%d", i)})
+	}
+	return evts
+}
+
+func TestRotatingFileTraceSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rcmgr.json.gz")
+	sink, err := NewRotatingFileTraceSink(path, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every batch rotates the file, as it exceeds the maximum size
+	evts := testTraceEvts(5)
+	for i := range evts {
+		if err := sink.Write(evts[i : i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected at most 3 files, got %v", err)
+	}
+
+	for i, name := range []string{path, path + ".1", path + ".2"} {
+		in, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := readTestTrace(t, in)
+		in.Close()
+
+		// every file starts with the start event of the trace
+		if len(got) == 0 || got[0].Type != TraceStartEvt {
+			t.Fatalf("missing start event in %s: %+v", name, got)
+		}
+		if i == 0 {
+			// the current file only has the start event, written after the last rotation
+			if len(got) != 1 {
+				t.Fatalf("unexpected events in %s: %+v", name, got)
+			}
+			continue
+		}
+		if len(got) != 2 || got[1].Seq != uint64(5-i) {
+			t.Fatalf("unexpected events in %s: %+v", name, got)
+		}
+	}
+
+	if _, err := NewRotatingFileTraceSink(path, 0, 3); err == nil {
+		t.Fatal("expected error for invalid maximum size")
+	}
+}
+
+func TestRingBufferTraceSink(t *testing.T) {
+	sink, err := NewRingBufferTraceSink(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Write(testTraceEvts(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	evts := sink.Events()
+	if len(evts) != 3 || evts[0].Seq != 2 || evts[2].Seq != 4 {
+		t.Fatalf("unexpected events: %+v", evts)
+	}
+
+	var buf bytes.Buffer
+	if _, err := sink.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := readTestTrace(t, &buf)
+	if len(got) != 4 || got[0].Type != TraceStartEvt || got[1].Seq != 2 {
+		t.Fatalf("unexpected dumped events: %+v", got)
+	}
+}
+
+type failingTraceSink struct {
+	closed bool
+}
+
+func (s *failingTraceSink) Write([]TraceEvt) error { return errors.New("write failed") }
+func (s *failingTraceSink) Close() error           { s.closed = true; return nil }
+
+func TestFanOutTraceSink(t *testing.T) {
+	ring, err := NewRingBufferTraceSink(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := &failingTraceSink{}
+
+	sink, err := NewFanOutTraceSink(ring, failing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the failing sink is dropped, while the ring buffer keeps receiving events
+	if err := sink.Write(testTraceEvts(2)); err != nil {
+		t.Fatal(err)
+	}
+	if !failing.closed {
+		t.Fatal("expected failing sink to be closed")
+	}
+	if err := sink.Write(testTraceEvts(2)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ring.Events()); n != 4 {
+		t.Fatalf("expected 4 events, got %d", n)
+	}
+
+	sink, err = NewFanOutTraceSink(&failingTraceSink{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(testTraceEvts(1)); err == nil {
+		t.Fatal("expected error when all sinks fail")
+	}
+}
@@ -0,0 +1,142 @@
+package rcmgr
+
+import (
+	"testing"
+)
+
+func TestTraceBuffer(t *testing.T) {
+	push := func(tr *trace, n int) {
+		for i := 0; i < n; i++ {
+			typ := TraceAddStreamEvt
+			if i%2 == 1 {
+				typ = TraceRemoveStreamEvt
+			}
+			tr.push(TraceEvt{Type: typ})
+		}
+	}
+
+	for _, tc := range []struct {
+		policy TraceDropPolicy
+		seqs   []uint64
+	}{
+		{TraceDropNewest, []uint64{0, 1, 2}},
+		{TraceDropOldest, []uint64{2, 3, 4}},
+	} {
+		tr := &trace{bufSize: 3, policy: tc.policy}
+		push(tr, 5)
+
+		evts := tr.takeEvents(nil)
+		if len(evts) != 4 {
+			t.Fatalf("expected 3 events and a dropped event, got %+v", evts)
+		}
+		for i, seq := range tc.seqs {
+			if evts[i].Seq != seq {
+				t.Fatalf("policy %d: expected event %d to have sequence %d, got %d", tc.policy, i, seq, evts[i].Seq)
+			}
+		}
+
+		dropped := evts[3]
+		if dropped.Type != TraceDroppedEvt || dropped.Seq != 5 {
+			t.Fatalf("unexpected dropped event: %+v", dropped)
+		}
+		if dropped.Dropped[TraceAddStreamEvt]+dropped.Dropped[TraceRemoveStreamEvt] != 2 {
+			t.Fatalf("unexpected dropped counts: %+v", dropped.Dropped)
+		}
+
+		// the drop counts are reset after every write
+		push(tr, 2)
+		if evts := tr.takeEvents(evts); len(evts) != 2 {
+			t.Fatalf("expected 2 events, got %+v", evts)
+		}
+	}
+
+	// sampling retains a subset of the events in sequence order
+	tr := &trace{bufSize: 10, policy: TraceDropSample}
+	push(tr, 100)
+	evts := tr.takeEvents(nil)
+	if len(evts) != 11 {
+		t.Fatalf("expected 10 events and a dropped event, got %d", len(evts))
+	}
+	for i := 1; i < 10; i++ {
+		if evts[i].Seq <= evts[i-1].Seq {
+			t.Fatalf("events out of order: %+v", evts)
+		}
+	}
+	if n := evts[10].Dropped[TraceAddStreamEvt] + evts[10].Dropped[TraceRemoveStreamEvt]; n != 90 {
+		t.Fatalf("expected 90 dropped events, got %d", n)
+	}
+
+	if err := WithTraceBuffer(-1, TraceDropNewest)(tr); err == nil {
+		t.Fatal("expected error for negative buffer size")
+	}
+}
+
+func TestTraceFilter(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"peer:*", "peer:QmA", true},
+		{"peer:*", "protocol:/a", false},
+		{"protocol:/ipfs/*", "protocol:/ipfs/bitswap/1.2.0", true},
+		{"protocol:/ipfs/*", "protocol:/libp2p/ping", false},
+		{"*.span", "stream-1.span", true},
+		{"block_*_stream", "block_add_stream", true},
+		{"a*a", "a", false},
+		{"system", "system", true},
+	} {
+		if globMatch(tc.pattern, tc.s) != tc.match {
+			t.Fatalf("expected match of %q against %q to be %t", tc.s, tc.pattern, tc.match)
+		}
+	}
+
+	tr := new(trace)
+	for _, opt := range []TraceOption{
+		WithTraceEventTypes("block_*", TraceCreateScopeEvt),
+		WithTraceScopes("peer:*"),
+	} {
+		if err := opt(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		evt  TraceEvt
+		keep bool
+	}{
+		{TraceEvt{Type: TraceStartEvt}, true},
+		{TraceEvt{Type: TraceBlockAddStreamEvt, Scope: "peer:QmA"}, true},
+		{TraceEvt{Type: TraceCreateScopeEvt, Scope: "peer:QmA"}, true},
+		{TraceEvt{Type: TraceBlockAddStreamEvt, Scope: "transient"}, false},
+		{TraceEvt{Type: TraceAddStreamEvt, Scope: "peer:QmA"}, false},
+	} {
+		if tr.filt.keep(&tc.evt) != tc.keep {
+			t.Fatalf("expected keep of %+v to be %t", tc.evt, tc.keep)
+		}
+	}
+
+	// sampling only applies to high volume events
+	tr = new(trace)
+	if err := WithTraceSampling(0.1)(tr); err != nil {
+		t.Fatal(err)
+	}
+	var kept int
+	for i := 0; i < 1000; i++ {
+		if tr.filt.keep(&TraceEvt{Type: TraceReserveMemoryEvt, Scope: "system"}) {
+			kept++
+		}
+		if !tr.filt.keep(&TraceEvt{Type: TraceBlockReserveMemoryEvt, Scope: "system"}) {
+			t.Fatal("expected block events to be kept")
+		}
+		if !tr.filt.keep(&TraceEvt{Type: TraceDestroyScopeEvt, Scope: "system"}) {
+			t.Fatal("expected destroy events to be kept")
+		}
+	}
+	if kept == 0 || kept > 500 {
+		t.Fatalf("unexpected number of sampled events: %d", kept)
+	}
+
+	if err := WithTraceSampling(0)(tr); err == nil {
+		t.Fatal("expected error for zero sampling rate")
+	}
+}
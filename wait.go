@@ -0,0 +1,175 @@
+package rcmgr
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+// memoryWaitQueue is the queue of callers blocked in ReserveMemoryCtx for a scope.
+// Waiters are ordered by priority, and in FIFO order within the same priority; only the waiter
+// at the head of the queue attempts to reserve memory when the queue is notified.
+type memoryWaitQueue struct {
+	mx      sync.Mutex
+	seq     uint64
+	waiters []*memoryWaiter
+}
+
+type memoryWaiter struct {
+	prio uint8
+	seq  uint64
+	wake chan struct{}
+}
+
+func (q *memoryWaitQueue) push(prio uint8) *memoryWaiter {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.seq++
+	w := &memoryWaiter{prio: prio, seq: q.seq, wake: make(chan struct{}, 1)}
+
+	i := len(q.waiters)
+	for i > 0 && q.waiters[i-1].prio < prio {
+		i--
+	}
+	q.waiters = append(q.waiters, nil)
+	copy(q.waiters[i+1:], q.waiters[i:])
+	q.waiters[i] = w
+
+	return w
+}
+
+func (q *memoryWaitQueue) remove(w *memoryWaiter) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	for i, qw := range q.waiters {
+		if qw == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *memoryWaitQueue) isHead(w *memoryWaiter) bool {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	return len(q.waiters) > 0 && q.waiters[0] == w
+}
+
+// notify wakes up the waiter at the head of the queue; it returns false if the queue is empty.
+func (q *memoryWaitQueue) notify() bool {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if len(q.waiters) == 0 {
+		return false
+	}
+
+	select {
+	case q.waiters[0].wake <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// ReserveMemoryCtx reserves memory in the scope, blocking until the reservation can be satisfied
+// or the context is done. Blocked callers wait in a per-scope queue, ordered by priority, and are
+// woken up whenever memory is released in the scope or in any of the scopes constraining it.
+func (s *resourceScope) ReserveMemoryCtx(ctx context.Context, size int, prio uint8) error {
+	q, err := s.getWaitQueue()
+	if err != nil {
+		return err
+	}
+
+	w := q.push(prio)
+	defer func() {
+		q.remove(w)
+		// pass on to the next waiter, there might be enough memory left for it too
+		q.notify()
+	}()
+
+	s.watchAncestors(q)
+	q.notify()
+
+	for {
+		select {
+		case <-w.wake:
+			if !q.isHead(w) {
+				continue
+			}
+
+			err := s.ReserveMemory(size, prio)
+			if err == nil {
+				return nil
+			}
+			if !errors.Is(err, network.ErrResourceLimitExceeded) {
+				return err
+			}
+
+			// the scope might have been attached to different edges while we were waiting
+			s.watchAncestors(q)
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *resourceScope) getWaitQueue() (*memoryWaitQueue, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.done {
+		return nil, s.wrapError(network.ErrResourceScopeClosed)
+	}
+
+	if s.waitq == nil {
+		s.waitq = new(memoryWaitQueue)
+	}
+
+	return s.waitq, nil
+}
+
+// watchAncestors registers the wait queue with all scopes that constrain memory reservations
+// in this scope, so that the queue is notified when memory is released in any of them.
+func (s *resourceScope) watchAncestors(q *memoryWaitQueue) {
+	for _, a := range s.ancestors() {
+		a.Lock()
+		if a.waiting == nil {
+			a.waiting = make(map[*memoryWaitQueue]struct{})
+		}
+		a.waiting[q] = struct{}{}
+		a.Unlock()
+	}
+}
+
+func (s *resourceScope) ancestors() []*resourceScope {
+	s.Lock()
+	owner, edges := s.owner, s.edges
+	s.Unlock()
+
+	if owner != nil {
+		return append([]*resourceScope{owner}, owner.ancestors()...)
+	}
+
+	return edges
+}
+
+// notifyWaiters wakes up waiters that might be able to proceed after memory has been released
+// or the limit has changed; it must be called with the scope lock held.
+func (s *resourceScope) notifyWaiters() {
+	if s.waitq != nil {
+		s.waitq.notify()
+	}
+
+	for q := range s.waiting {
+		if !q.notify() {
+			delete(s.waiting, q)
+		}
+	}
+}
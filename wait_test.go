@@ -0,0 +1,193 @@
+package rcmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+func TestReserveMemoryCtx(t *testing.T) {
+	s := newResourceScope(&StaticLimit{Memory: 4096}, nil, "test", nil, nil)
+	defer s.Done()
+
+	if err := s.ReserveMemoryCtx(context.Background(), 4096, network.ReservationPriorityAlways); err != nil {
+		t.Fatal(err)
+	}
+
+	// the scope is full, the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.ReserveMemoryCtx(ctx, 1024, network.ReservationPriorityAlways); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// a blocked reservation proceeds once memory is released
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ReserveMemoryCtx(context.Background(), 2048, network.ReservationPriorityAlways)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected reservation to block, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.ReleaseMemory(2048)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reservation was not unblocked")
+	}
+
+	checkResources(t, &s.rc, network.ScopeStat{Memory: 4096})
+}
+
+func waitQueued(t *testing.T, s *resourceScope, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.Lock()
+		q := s.waitq
+		s.Unlock()
+
+		if q != nil {
+			q.mx.Lock()
+			queued := len(q.waiters)
+			q.mx.Unlock()
+
+			if queued == n {
+				return
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued waiters", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReserveMemoryCtxOrder(t *testing.T) {
+	s := newResourceScope(&StaticLimit{Memory: 4096}, nil, "test", nil, nil)
+	defer s.Done()
+
+	if err := s.ReserveMemory(4096, network.ReservationPriorityAlways); err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan int, 3)
+	for i, prio := range []uint8{network.ReservationPriorityLow, network.ReservationPriorityAlways, network.ReservationPriorityAlways} {
+		i, prio := i, prio
+		go func() {
+			if err := s.ReserveMemoryCtx(context.Background(), 1024, prio); err != nil {
+				t.Error(err)
+			}
+			order <- i
+		}()
+		waitQueued(t, s, i+1)
+	}
+
+	expectNext := func(expected int) {
+		t.Helper()
+
+		select {
+		case i := <-order:
+			if i != expected {
+				t.Fatalf("expected waiter %d to proceed, got %d", expected, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("reservation was not unblocked")
+		}
+
+		select {
+		case i := <-order:
+			t.Fatalf("expected only waiter %d to proceed, but %d did too", expected, i)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// the higher priority waiters go first, in FIFO order
+	s.ReleaseMemory(1024)
+	expectNext(1)
+	s.ReleaseMemory(1024)
+	expectNext(2)
+
+	// the low priority waiter can only proceed once memory is below its priority threshold
+	s.ReleaseMemory(4096)
+	expectNext(0)
+
+	checkResources(t, &s.rc, network.ScopeStat{Memory: 1024})
+}
+
+func TestReserveMemoryCtxEdges(t *testing.T) {
+	system := newResourceScope(&StaticLimit{Memory: 4096}, nil, "system", nil, nil)
+	defer system.Done()
+	child := newResourceScope(&StaticLimit{Memory: 4096}, []*resourceScope{system}, "child", nil, nil)
+	defer child.Done()
+	other := newResourceScope(&StaticLimit{Memory: 4096}, []*resourceScope{system}, "other", nil, nil)
+	defer other.Done()
+
+	if err := other.ReserveMemory(4096, network.ReservationPriorityAlways); err != nil {
+		t.Fatal(err)
+	}
+
+	span, err := child.BeginSpan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer span.Done()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- span.(*resourceScope).ReserveMemoryCtx(context.Background(), 1024, network.ReservationPriorityAlways)
+	}()
+	waitQueued(t, span.(*resourceScope), 1)
+
+	// releasing memory in an unrelated scope sharing the system scope wakes up the waiter
+	other.ReleaseMemory(1024)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reservation was not unblocked")
+	}
+
+	checkResources(t, &system.rc, network.ScopeStat{Memory: 4096})
+	checkResources(t, &child.rc, network.ScopeStat{Memory: 1024})
+}
+
+func TestReserveMemoryCtxDone(t *testing.T) {
+	s := newResourceScope(&StaticLimit{Memory: 4096}, nil, "test", nil, nil)
+
+	if err := s.ReserveMemory(4096, network.ReservationPriorityAlways); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ReserveMemoryCtx(context.Background(), 1024, network.ReservationPriorityAlways)
+	}()
+	waitQueued(t, s, 1)
+
+	s.Done()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected reservation in closed scope to fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reservation was not unblocked")
+	}
+}